@@ -0,0 +1,230 @@
+// Package archive keeps old messages in compressed files on disk, moved there
+// from live database by Archiver and read back by Tiered query store.
+package archive
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/jozuenoon/dunder/model"
+	"github.com/jozuenoon/dunder/repository"
+	"github.com/oklog/ulid"
+)
+
+const segmentExt = ".ndjson.gz"
+
+func New(dir string) *Archive {
+	return &Archive{dir: dir}
+}
+
+// Archive stores messages in daily partitions `YYYY/MM/DD` of gzip compressed
+// NDJSON segments. Segment is written to temporary file and renamed, so readers
+// never see partial writes.
+type Archive struct {
+	dir string
+}
+
+// Write stores messages in partitions of their creation day.
+func (a *Archive) Write(msgs []*model.Message) error {
+	days := make(map[string][]*model.Message)
+	for _, m := range msgs {
+		p := a.partition(m.CreatedAt)
+		days[p] = append(days[p], m)
+	}
+	for p, dayMsgs := range days {
+		if err := a.writeSegment(p, dayMsgs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *Archive) partition(t time.Time) string {
+	return filepath.Join(a.dir, t.UTC().Format("2006/01/02"))
+}
+
+func (a *Archive) writeSegment(partition string, msgs []*model.Message) (err error) {
+	if err := os.MkdirAll(partition, 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(partition, ".segment-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	zw := gzip.NewWriter(f)
+	enc := json.NewEncoder(zw)
+	for _, m := range msgs {
+		if err = enc.Encode(m); err != nil {
+			return err
+		}
+	}
+	if err = zw.Close(); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	// Segment is named after range of its messages, so rewriting same range
+	// after interrupted run replaces the segment.
+	name := msgs[0].ID + "-" + msgs[len(msgs)-1].ID + segmentExt
+	return os.Rename(f.Name(), filepath.Join(partition, name))
+}
+
+// readPartition returns messages of partition, newest first. Duplicates left
+// by interrupted archiving are dropped.
+func (a *Archive) readPartition(partition string) ([]*model.Message, error) {
+	files, err := filepath.Glob(filepath.Join(partition, "*"+segmentExt))
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var msgs []*model.Message
+	for _, file := range files {
+		segment, err := readSegment(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range segment {
+			if !seen[m.ID] {
+				seen[m.ID] = true
+				msgs = append(msgs, m)
+			}
+		}
+	}
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].ID > msgs[j].ID })
+	return msgs, nil
+}
+
+func readSegment(file string) ([]*model.Message, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(bufio.NewReader(f))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	var msgs []*model.Message
+	dec := json.NewDecoder(zr)
+	for dec.More() {
+		var m model.Message
+		if err := dec.Decode(&m); err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, &m)
+	}
+	return msgs, nil
+}
+
+// partitions lists partitions from newest to oldest, skipping ones newer than upper.
+func (a *Archive) partitions(upper time.Time) ([]string, error) {
+	dirs, err := filepath.Glob(filepath.Join(a.dir, "[0-9][0-9][0-9][0-9]", "[0-9][0-9]", "[0-9][0-9]"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+	last := a.partition(upper)
+	for i, d := range dirs {
+		if d <= last {
+			return dirs[i:], nil
+		}
+	}
+	return nil, nil
+}
+
+// Message returns archived message with given ulid, nil when it's not archived.
+func (a *Archive) Message(id string) (*model.Message, error) {
+	u, err := ulid.Parse(id)
+	if err != nil {
+		return nil, nil
+	}
+	msgs, err := a.readPartition(a.partition(ulid.Time(u.Time())))
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range msgs {
+		if m.ID == id {
+			return m, nil
+		}
+	}
+	return nil, nil
+}
+
+// Messages returns archived messages matching filter older than message with
+// ulid before, newest first. Empty before means no upper bound.
+func (a *Archive) Messages(filter repository.Filter, before string) ([]*model.Message, error) {
+	upper := time.Now()
+	dateRange := !filter.IsCursorQuery() && filter.IsDateRangeQuery()
+	if dateRange {
+		upper = filter.GetToDate()
+	}
+	if before != "" {
+		if u, err := ulid.Parse(before); err == nil {
+			upper = ulid.Time(u.Time())
+		}
+	}
+	partitions, err := a.partitions(upper)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp []*model.Message
+	for _, p := range partitions {
+		if dateRange && p < a.partition(filter.GetFromDate()) {
+			break
+		}
+		msgs, err := a.readPartition(p)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range msgs {
+			if uint(len(resp)) >= filter.GetLimit() {
+				return resp, nil
+			}
+			if matches(filter, m, before, dateRange) {
+				resp = append(resp, m)
+			}
+		}
+	}
+	return resp, nil
+}
+
+func matches(filter repository.Filter, m *model.Message, before string, dateRange bool) bool {
+	if before != "" && m.ID >= before {
+		return false
+	}
+	if dateRange && (!m.CreatedAt.After(filter.GetFromDate()) || !m.CreatedAt.Before(filter.GetToDate())) {
+		return false
+	}
+	if filter.IsUserQuery() && m.User.Name != filter.GetUserName() {
+		return false
+	}
+	if filter.IsHashtagsQuery() {
+		for _, h := range m.Hashtags {
+			if h == filter.GetHashtag() {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,221 @@
+package archive
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/jozuenoon/dunder/model"
+	"github.com/jozuenoon/dunder/repository"
+	"github.com/oklog/ulid"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var now = time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+
+// liveStore keeps messages ordered by ulid, newest first.
+type liveStore struct {
+	repository.QueryStore
+	msgs []*repository.Message
+}
+
+func (s *liveStore) Message(ctx context.Context, id string) (*repository.Message, error) {
+	for _, m := range s.msgs {
+		if *m.Ulid == id {
+			return m, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (s *liveStore) Messages(ctx context.Context, filter repository.Filter) ([]*repository.Message, error) {
+	var resp []*repository.Message
+	for _, m := range s.msgs {
+		switch {
+		case filter.IsCursorQuery():
+			if *m.Ulid >= filter.GetCursor() {
+				continue
+			}
+		case filter.IsDateRangeQuery():
+			if !m.CreatedAt.After(filter.GetFromDate()) || !m.CreatedAt.Before(filter.GetToDate()) {
+				continue
+			}
+		}
+		if uint(len(resp)) < filter.GetLimit() {
+			resp = append(resp, m)
+		}
+	}
+	return resp, nil
+}
+
+func (s *liveStore) MessagesBefore(ctx context.Context, before time.Time, limit uint) ([]*repository.Message, error) {
+	var resp []*repository.Message
+	for i := len(s.msgs) - 1; i >= 0 && uint(len(resp)) < limit; i-- {
+		if s.msgs[i].CreatedAt.Before(before) {
+			resp = append(resp, s.msgs[i])
+		}
+	}
+	return resp, nil
+}
+
+func (s *liveStore) DeleteMessages(ctx context.Context, ulids []string) error {
+	deleted := make(map[string]bool)
+	for _, id := range ulids {
+		deleted[id] = true
+	}
+	var kept []*repository.Message
+	for _, m := range s.msgs {
+		if !deleted[*m.Ulid] {
+			kept = append(kept, m)
+		}
+	}
+	s.msgs = kept
+	return nil
+}
+
+// newLiveStore holds message posted every 12 hours during 10 days before now.
+func newLiveStore() *liveStore {
+	s := &liveStore{}
+	for i := 1; i <= 20; i++ {
+		created := now.Add(-time.Duration(i) * 12 * time.Hour)
+		id := ulid.MustNew(ulid.Timestamp(created), nil).String()
+		name, tag := "john", "go"
+		s.msgs = append(s.msgs, &repository.Message{
+			CreatedAt: created,
+			Ulid:      &id,
+			User:      repository.User{Name: &name},
+			Text:      "text",
+			Hashtags:  []*repository.Hashtag{{Text: &tag}},
+		})
+	}
+	return s
+}
+
+func setup(t *testing.T) (*liveStore, *Archive, *Tiered, func()) {
+	dir, err := ioutil.TempDir("", "archive")
+	require.NoError(t, err)
+	live := newLiveStore()
+	archive := New(dir)
+	log := zerolog.Nop()
+
+	archiver := NewArchiver(live, archive, &Config{MaxAge: 4 * 24 * time.Hour, BatchSize: 5}, &log)
+	archiver.now = func() time.Time { return now }
+	n, err := archiver.Run(context.Background())
+	require.NoError(t, err)
+	// Messages from 4.5 to 10 days ago are archived.
+	require.Equal(t, 12, n)
+	require.Len(t, live.msgs, 8)
+
+	tiered := NewTiered(live, archive, 4*24*time.Hour)
+	tiered.now = func() time.Time { return now }
+	return live, archive, tiered, func() { os.RemoveAll(dir) }
+}
+
+func ulids(msgs []*repository.Message) []string {
+	var out []string
+	for _, m := range msgs {
+		out = append(out, *m.Ulid)
+	}
+	return out
+}
+
+func TestTiered_DateRange(t *testing.T) {
+	live, _, tiered, cleanup := setup(t)
+	defer cleanup()
+	all := append([]*repository.Message(nil), live.msgs...)
+
+	msgs, err := tiered.Messages(context.Background(), &repository.FilterImpl{QueryRequest: model.QueryRequest{
+		FromDate: []time.Time{now.Add(-6 * 24 * time.Hour)},
+		ToDate:   []time.Time{now},
+	}})
+	require.NoError(t, err)
+	require.Len(t, msgs, 11)
+	assert.Equal(t, ulids(all), ulids(msgs[:8]))
+	assert.True(t, sort.SliceIsSorted(msgs, func(i, j int) bool { return *msgs[i].Ulid > *msgs[j].Ulid }))
+}
+
+func TestTiered_CursorPaging(t *testing.T) {
+	_, _, tiered, cleanup := setup(t)
+	defer cleanup()
+
+	var pages [][]string
+	filter := model.QueryRequest{Limit: []uint{6}}
+	for {
+		msgs, err := tiered.Messages(context.Background(), &repository.FilterImpl{QueryRequest: filter})
+		require.NoError(t, err)
+		if len(msgs) == 0 {
+			break
+		}
+		pages = append(pages, ulids(msgs))
+		filter.Cursor = []string{*msgs[len(msgs)-1].Ulid}
+	}
+	require.Len(t, pages, 4)
+	assert.Len(t, pages[3], 2)
+}
+
+func TestTiered_Backfilled(t *testing.T) {
+	live, _, tiered, cleanup := setup(t)
+	defer cleanup()
+	// Importer backfills live database past archived messages.
+	for _, age := range []time.Duration{7*24*time.Hour + time.Hour, 15 * 24 * time.Hour} {
+		created := now.Add(-age)
+		id := ulid.MustNew(ulid.Timestamp(created), nil).String()
+		name := "importer"
+		live.msgs = append(live.msgs, &repository.Message{CreatedAt: created, Ulid: &id, User: repository.User{Name: &name}})
+	}
+	sort.Slice(live.msgs, func(i, j int) bool { return *live.msgs[i].Ulid > *live.msgs[j].Ulid })
+
+	var all []*repository.Message
+	filter := model.QueryRequest{Limit: []uint{6}}
+	for {
+		msgs, err := tiered.Messages(context.Background(), &repository.FilterImpl{QueryRequest: filter})
+		require.NoError(t, err)
+		if len(msgs) == 0 {
+			break
+		}
+		all = append(all, msgs...)
+		filter.Cursor = []string{*msgs[len(msgs)-1].Ulid}
+	}
+	require.Len(t, all, 22)
+	assert.True(t, sort.SliceIsSorted(all, func(i, j int) bool { return *all[i].Ulid > *all[j].Ulid }))
+	assert.Equal(t, "importer", *all[21].User.Name)
+}
+
+func TestTiered_LatestSkipsArchive(t *testing.T) {
+	_, _, tiered, cleanup := setup(t)
+	defer cleanup()
+
+	msgs, err := tiered.Messages(context.Background(), &repository.FilterImpl{})
+	require.NoError(t, err)
+	assert.Len(t, msgs, 8)
+}
+
+func TestTiered_Message(t *testing.T) {
+	_, archive, tiered, cleanup := setup(t)
+	defer cleanup()
+
+	old := ulid.MustNew(ulid.Timestamp(now.Add(-19*12*time.Hour)), nil).String()
+	msg, err := tiered.Message(context.Background(), old)
+	require.NoError(t, err)
+	assert.Equal(t, "john", *msg.User.Name)
+
+	_, err = tiered.Message(context.Background(), ulid.MustNew(ulid.Timestamp(now), nil).String())
+	assert.Equal(t, gorm.ErrRecordNotFound, err)
+
+	// Segment written again by interrupted archiver doesn't duplicate messages.
+	archived, err := archive.Message(old)
+	require.NoError(t, err)
+	require.NoError(t, archive.Write([]*model.Message{archived}))
+	files, _ := filepath.Glob(filepath.Join(archive.dir, "2019", "09", "22", "*"+segmentExt))
+	assert.Len(t, files, 2)
+	msgs, err := archive.Messages(&repository.FilterImpl{}, "")
+	require.NoError(t, err)
+	assert.Len(t, msgs, 12)
+}
@@ -0,0 +1,117 @@
+package archive
+
+import (
+	"context"
+	"time"
+
+	"github.com/jozuenoon/dunder/metrics"
+	"github.com/jozuenoon/dunder/model"
+	"github.com/jozuenoon/dunder/repository"
+	"github.com/rs/zerolog"
+)
+
+type Config struct {
+	// MaxAge is age after which messages are moved to archive.
+	MaxAge    time.Duration
+	Interval  time.Duration
+	BatchSize uint
+}
+
+func NewArchiver(store repository.ArchiveStore, archive *Archive, cfg *Config, log *zerolog.Logger) *Archiver {
+	return &Archiver{
+		store:   store,
+		archive: archive,
+		cfg:     *cfg,
+		log:     log,
+		now:     time.Now,
+		done:    make(chan struct{}),
+	}
+}
+
+// Archiver periodically moves messages older than MaxAge from live database
+// to archive. Messages are written to archive before they are deleted, so
+// interrupted run leaves duplicates which readers drop, never gaps.
+type Archiver struct {
+	store   repository.ArchiveStore
+	archive *Archive
+	cfg     Config
+	log     *zerolog.Logger
+	now     func() time.Time
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Run archives all messages older than MaxAge and returns number of moved messages.
+func (a *Archiver) Run(ctx context.Context) (int, error) {
+	var total int
+	for {
+		n, err := a.archiveBatch(ctx)
+		total += n
+		if err != nil || uint(n) < a.cfg.BatchSize {
+			return total, err
+		}
+	}
+}
+
+func (a *Archiver) archiveBatch(ctx context.Context) (int, error) {
+	msgs, err := a.store.MessagesBefore(ctx, a.now().Add(-a.cfg.MaxAge), a.cfg.BatchSize)
+	if err != nil || len(msgs) == 0 {
+		return 0, err
+	}
+	archived := make([]*model.Message, 0, len(msgs))
+	ulids := make([]string, 0, len(msgs))
+	for _, m := range msgs {
+		archived = append(archived, fromRepository(m))
+		ulids = append(ulids, *m.Ulid)
+	}
+	if err := a.archive.Write(archived); err != nil {
+		return 0, err
+	}
+	if err := a.store.DeleteMessages(ctx, ulids); err != nil {
+		return 0, err
+	}
+	metrics.MessagesArchived(len(msgs))
+	return len(msgs), nil
+}
+
+// Start runs archiver every Interval in background until Close is called.
+func (a *Archiver) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	a.cancel = cancel
+	go a.run(ctx)
+}
+
+// Close stops archiver and waits for batch in progress.
+func (a *Archiver) Close(ctx context.Context) error {
+	if a.cancel == nil {
+		return nil
+	}
+	a.cancel()
+	select {
+	case <-a.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (a *Archiver) run(ctx context.Context) {
+	defer close(a.done)
+	ticker := time.NewTicker(a.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		n, err := a.Run(ctx)
+		if err != nil && ctx.Err() == nil {
+			a.log.Error().Err(err).Msg("archive: failed to archive messages")
+		}
+		if n > 0 {
+			a.log.Info().Int("messages", n).Msg("archive: moved messages")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
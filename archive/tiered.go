@@ -0,0 +1,140 @@
+package archive
+
+import (
+	"context"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/jozuenoon/dunder/model"
+	"github.com/jozuenoon/dunder/repository"
+)
+
+var _ repository.QueryStore = (*Tiered)(nil)
+
+func NewTiered(hot repository.QueryStore, archive *Archive, maxAge time.Duration) *Tiered {
+	return &Tiered{
+		hot:     hot,
+		archive: archive,
+		maxAge:  maxAge,
+		now:     time.Now,
+	}
+}
+
+// Tiered serves queries from live database and merges them with archive when
+// results reach past archival age. Archiver moves messages in ulid order, but
+// importers may backfill live database with messages older than archived
+// ones, so both tiers are read from the same bound and merged by ulid.
+type Tiered struct {
+	hot     repository.QueryStore
+	archive *Archive
+	maxAge  time.Duration
+	now     func() time.Time
+}
+
+func (t *Tiered) Message(ctx context.Context, ulid string) (*repository.Message, error) {
+	msg, err := t.hot.Message(ctx, ulid)
+	if err != gorm.ErrRecordNotFound {
+		return msg, err
+	}
+	archived, err := t.archive.Message(ulid)
+	if err != nil {
+		return nil, err
+	}
+	if archived == nil {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return toRepository(archived), nil
+}
+
+func (t *Tiered) Messages(ctx context.Context, filter repository.Filter) ([]*repository.Message, error) {
+	msgs, err := t.hot.Messages(ctx, filter)
+	if err != nil || !t.reachesArchive(filter, msgs) {
+		return msgs, err
+	}
+
+	before := ""
+	if filter.IsCursorQuery() {
+		before = filter.GetCursor()
+	}
+	archived, err := t.archive.Messages(filter, before)
+	if err != nil {
+		return nil, err
+	}
+	return merge(msgs, archived, filter.GetLimit()), nil
+}
+
+// reachesArchive tells whether query could match archived messages. Full
+// page reaches archive when it runs past archival age, which happens with
+// backfilled messages. Otherwise queries for latest messages don't look into
+// archive, paging with cursor or date range reaching past archival age does.
+func (t *Tiered) reachesArchive(filter repository.Filter, msgs []*repository.Message) bool {
+	cutoff := t.now().Add(-t.maxAge)
+	switch {
+	case len(msgs) > 0 && uint(len(msgs)) >= filter.GetLimit():
+		return msgs[len(msgs)-1].CreatedAt.Before(cutoff)
+	case filter.IsCursorQuery():
+		return true
+	case filter.IsDateRangeQuery():
+		return filter.GetFromDate().Before(cutoff)
+	}
+	return false
+}
+
+// merge joins live and archived messages, both newest first, up to limit.
+// Messages left in live database by interrupted archiving are taken once.
+func merge(hot []*repository.Message, archived []*model.Message, limit uint) []*repository.Message {
+	resp := make([]*repository.Message, 0, limit)
+	i, j := 0, 0
+	for uint(len(resp)) < limit && (i < len(hot) || j < len(archived)) {
+		switch {
+		case j == len(archived) || i < len(hot) && *hot[i].Ulid > archived[j].ID:
+			resp = append(resp, hot[i])
+			i++
+		case i == len(hot) || archived[j].ID > *hot[i].Ulid:
+			resp = append(resp, toRepository(archived[j]))
+			j++
+		default:
+			resp = append(resp, hot[i])
+			i++
+			j++
+		}
+	}
+	return resp
+}
+
+// Trends are kept in live database after archiving.
+func (t *Tiered) Trends(ctx context.Context, filter repository.Filter) (*repository.MessagesAggregate, error) {
+	return t.hot.Trends(ctx, filter)
+}
+
+func toRepository(m *model.Message) *repository.Message {
+	id, name := m.ID, m.User.Name
+	hashtags := make([]*repository.Hashtag, 0, len(m.Hashtags))
+	for _, h := range m.Hashtags {
+		text := h
+		hashtags = append(hashtags, &repository.Hashtag{Text: &text})
+	}
+	return &repository.Message{
+		CreatedAt: m.CreatedAt,
+		UpdatedAt: m.CreatedAt,
+		Ulid:      &id,
+		User:      repository.User{ID: m.User.ID, Name: &name},
+		UserRef:   m.User.ID,
+		Text:      m.Text,
+		Hashtags:  hashtags,
+	}
+}
+
+func fromRepository(m *repository.Message) *model.Message {
+	hashtags := make([]string, 0, len(m.Hashtags))
+	for _, h := range m.Hashtags {
+		hashtags = append(hashtags, *h.Text)
+	}
+	return &model.Message{
+		ID:        *m.Ulid,
+		User:      model.User{ID: m.User.ID, Name: *m.User.Name},
+		Text:      m.Text,
+		Hashtags:  hashtags,
+		CreatedAt: m.CreatedAt,
+	}
+}
@@ -0,0 +1,48 @@
+package cache
+
+import (
+	"time"
+
+	"github.com/jozuenoon/dunder/metrics"
+	"github.com/jozuenoon/dunder/repository"
+)
+
+var _ repository.IDCache = (*IDs)(nil)
+
+// NewIDs creates cache of user and hashtag ids, each holding up to size entries.
+func NewIDs(size int, ttl time.Duration) *IDs {
+	return &IDs{
+		users:    NewLRU(size, ttl),
+		hashtags: NewLRU(size, ttl),
+	}
+}
+
+type IDs struct {
+	users    *LRU
+	hashtags *LRU
+}
+
+func (c *IDs) UserID(name string) (uint, bool) {
+	return lookupID(c.users, "user_id", name)
+}
+
+func (c *IDs) HashtagID(text string) (uint, bool) {
+	return lookupID(c.hashtags, "hashtag_id", text)
+}
+
+func (c *IDs) AddUser(name string, id uint) {
+	c.users.Set(name, id)
+}
+
+func (c *IDs) AddHashtag(text string, id uint) {
+	c.hashtags.Set(text, id)
+}
+
+func lookupID(c *LRU, name, key string) (uint, bool) {
+	v, ok := c.Get(key)
+	metrics.CacheLookup(name, ok)
+	if !ok {
+		return 0, false
+	}
+	return v.(uint), true
+}
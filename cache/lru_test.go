@@ -0,0 +1,54 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLRU_Evicts(t *testing.T) {
+	c := NewLRU(2, 0)
+	c.Set("a", 1)
+	c.Set("b", 2)
+	// Reading a makes b least recently used.
+	_, ok := c.Get("a")
+	assert.True(t, ok)
+	c.Set("c", 3)
+
+	_, ok = c.Get("b")
+	assert.False(t, ok)
+	v, ok := c.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, v)
+	assert.Equal(t, 2, c.Len())
+
+	c.Delete("a")
+	_, ok = c.Get("a")
+	assert.False(t, ok)
+	c.Purge()
+	assert.Equal(t, 0, c.Len())
+}
+
+func TestLRU_Expires(t *testing.T) {
+	now := time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+	c := NewLRU(10, time.Minute)
+	c.now = func() time.Time { return now }
+	c.Set("a", 1)
+
+	now = now.Add(59 * time.Second)
+	_, ok := c.Get("a")
+	assert.True(t, ok)
+
+	now = now.Add(2 * time.Second)
+	_, ok = c.Get("a")
+	assert.False(t, ok)
+	assert.Equal(t, 0, c.Len())
+}
+
+func TestLRU_ZeroSizeDisables(t *testing.T) {
+	c := NewLRU(0, time.Minute)
+	c.Set("a", 1)
+	_, ok := c.Get("a")
+	assert.False(t, ok)
+}
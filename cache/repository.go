@@ -0,0 +1,182 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jozuenoon/dunder/metrics"
+	"github.com/jozuenoon/dunder/repository"
+)
+
+var _ repository.Service = (*Repository)(nil)
+
+type Config struct {
+	// MessageSize and MessageTTL bound cache of messages by ulid.
+	MessageSize int
+	MessageTTL  time.Duration
+	// TrendsSize and TrendsTTL bound cache of trends query results. Results
+	// are kept for whole aggregation periods while current period keeps
+	// changing, so TTL should be short.
+	TrendsSize int
+	TrendsTTL  time.Duration
+}
+
+// NewRepository wraps repository service with cache of messages and trends.
+func NewRepository(repo repository.Service, cfg *Config) *Repository {
+	return &Repository{
+		repo:     repo,
+		messages: NewLRU(cfg.MessageSize, cfg.MessageTTL),
+		trends:   NewLRU(cfg.TrendsSize, cfg.TrendsTTL),
+	}
+}
+
+// Repository caches messages by ulid and trends results. Edited and deleted
+// messages are dropped from cache, archiver deletes messages behind the cache
+// and should call Invalidate. Cached values are shared, callers must not
+// modify them.
+type Repository struct {
+	repo     repository.Service
+	messages *LRU
+	trends   *LRU
+}
+
+func (r *Repository) Message(ctx context.Context, ulid string) (*repository.Message, error) {
+	if v, ok := r.messages.Get(ulid); ok {
+		metrics.CacheLookup("message", true)
+		return v.(*repository.Message), nil
+	}
+	metrics.CacheLookup("message", false)
+	msg, err := r.repo.Message(ctx, ulid)
+	if err != nil {
+		return nil, err
+	}
+	r.messages.Set(ulid, msg)
+	return msg, nil
+}
+
+// Messages aren't cached as results of searches change with every new message.
+func (r *Repository) Messages(ctx context.Context, filter repository.Filter) ([]*repository.Message, error) {
+	return r.repo.Messages(ctx, filter)
+}
+
+// Trends results are cached for whole aggregation periods, so queries for
+// sliding ranges like last 24 hours share cache entry until period changes.
+// Partial periods at edges of range are widened to whole periods, as labelled
+// by their from and to dates. New messages show up in cached trends only after
+// TrendsTTL.
+func (r *Repository) Trends(ctx context.Context, filter repository.Filter) (*repository.MessagesAggregate, error) {
+	aligned, ok := alignPeriods(filter)
+	if !ok {
+		return r.repo.Trends(ctx, filter)
+	}
+	key := trendsKey(aligned)
+	if v, ok := r.trends.Get(key); ok {
+		metrics.CacheLookup("trends", true)
+		return v.(*repository.MessagesAggregate), nil
+	}
+	metrics.CacheLookup("trends", false)
+	trends, err := r.repo.Trends(ctx, aligned)
+	if err != nil {
+		return nil, err
+	}
+	r.trends.Set(key, trends)
+	return trends, nil
+}
+
+// periodsFilter overrides date range of trends query.
+type periodsFilter struct {
+	repository.Filter
+	from, to time.Time
+}
+
+func (f *periodsFilter) GetFromDate() time.Time {
+	return f.from
+}
+
+func (f *periodsFilter) GetToDate() time.Time {
+	return f.to
+}
+
+// alignPeriods widens date range of trends query to whole aggregation periods,
+// invalid queries are not cached. Store counts minute buckets strictly between
+// from and to, so from is placed minute before first period.
+func alignPeriods(filter repository.Filter) (*periodsFilter, bool) {
+	if !filter.IsAggregateQuery() || !filter.IsDateRangeQuery() {
+		return nil, false
+	}
+	size := int64(filter.GetAggregationPeriod() / time.Minute)
+	if size <= 0 {
+		return nil, false
+	}
+	from := filter.GetFromDate().Unix() / 60 / size * size
+	to := (filter.GetToDate().Unix()/60 + size - 1) / size * size
+	return &periodsFilter{
+		Filter: filter,
+		from:   time.Unix((from-1)*60, 0),
+		to:     time.Unix(to*60, 0),
+	}, true
+}
+
+// trendsKey identifies trends query.
+func trendsKey(filter repository.Filter) string {
+	var hashtag string
+	if filter.IsHashtagsQuery() {
+		hashtag = filter.GetHashtag()
+	}
+	return fmt.Sprintf("%d/%d/%s/%s/%s", filter.GetFromDate().Unix(), filter.GetToDate().Unix(),
+		filter.GetAggregationPeriod(), filter.GetConsistency(), hashtag)
+}
+
+func (r *Repository) CreateMessage(ctx context.Context, message *repository.CreateMessageRequest) (string, error) {
+	return r.repo.CreateMessage(ctx, message)
+}
+
+func (r *Repository) CreateMessages(ctx context.Context, messages []*repository.CreateMessageRequest) ([]*repository.CreateMessageResult, error) {
+	return r.repo.CreateMessages(ctx, messages)
+}
+
+func (r *Repository) IdempotentMessage(ctx context.Context, userName, key string) (string, error) {
+	return r.repo.IdempotentMessage(ctx, userName, key)
+}
+
+func (r *Repository) EditMessage(ctx context.Context, req *repository.EditMessageRequest) error {
+	err := r.repo.EditMessage(ctx, req)
+	// Message may be changed even when commit result is unknown.
+	r.Invalidate(req.Ulid)
+	return err
+}
+
+func (r *Repository) DeleteMessage(ctx context.Context, userName, ulid string) error {
+	err := r.repo.DeleteMessage(ctx, userName, ulid)
+	r.Invalidate(ulid)
+	return err
+}
+
+func (r *Repository) HealthCheck(ctx context.Context) (*repository.HealthStatus, error) {
+	return r.repo.HealthCheck(ctx)
+}
+
+// Invalidate drops cached messages, eg. after they were deleted.
+func (r *Repository) Invalidate(ulids ...string) {
+	for _, ulid := range ulids {
+		r.messages.Delete(ulid)
+	}
+}
+
+// InvalidateOnDelete wraps archive store so messages deleted from it are dropped from cache.
+func (r *Repository) InvalidateOnDelete(store repository.ArchiveStore) repository.ArchiveStore {
+	return &invalidatingStore{ArchiveStore: store, cache: r}
+}
+
+type invalidatingStore struct {
+	repository.ArchiveStore
+	cache *Repository
+}
+
+func (s *invalidatingStore) DeleteMessages(ctx context.Context, ulids []string) error {
+	err := s.ArchiveStore.DeleteMessages(ctx, ulids)
+	// Messages may be deleted even when commit result is unknown.
+	s.cache.Invalidate(ulids...)
+	return err
+}
@@ -0,0 +1,123 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/jozuenoon/dunder/model"
+	"github.com/jozuenoon/dunder/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingRepository counts reads reaching database.
+type countingRepository struct {
+	repository.Service
+	messages map[string]*repository.Message
+	reads    int
+	filter   repository.Filter
+}
+
+func (r *countingRepository) Message(ctx context.Context, ulid string) (*repository.Message, error) {
+	r.reads++
+	msg, ok := r.messages[ulid]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return msg, nil
+}
+
+func (r *countingRepository) Trends(ctx context.Context, filter repository.Filter) (*repository.MessagesAggregate, error) {
+	r.reads++
+	r.filter = filter
+	return &repository.MessagesAggregate{Trends: []*model.Trend{{Count: 1}}}, nil
+}
+
+func (r *countingRepository) DeleteMessages(ctx context.Context, ulids []string) error {
+	for _, id := range ulids {
+		delete(r.messages, id)
+	}
+	return nil
+}
+
+func (r *countingRepository) MessagesBefore(context.Context, time.Time, uint) ([]*repository.Message, error) {
+	return nil, nil
+}
+
+func TestRepository_Message(t *testing.T) {
+	id := "01DNQ8Y8BJM3XWK9FWX9A7R3VE"
+	repo := &countingRepository{messages: map[string]*repository.Message{id: {Text: "hello"}}}
+	c := NewRepository(repo, &Config{MessageSize: 10, MessageTTL: time.Minute})
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		msg, err := c.Message(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, "hello", msg.Text)
+	}
+	assert.Equal(t, 1, repo.reads)
+
+	// Missing messages are not cached.
+	for i := 0; i < 2; i++ {
+		_, err := c.Message(ctx, "01DNQ8Y8BJM3XWK9FWX9A7R3VF")
+		assert.Equal(t, gorm.ErrRecordNotFound, err)
+	}
+	assert.Equal(t, 3, repo.reads)
+
+	// Deleted message is dropped from cache.
+	require.NoError(t, c.InvalidateOnDelete(repo).DeleteMessages(ctx, []string{id}))
+	_, err := c.Message(ctx, id)
+	assert.Equal(t, gorm.ErrRecordNotFound, err)
+}
+
+func TestRepository_Trends(t *testing.T) {
+	repo := &countingRepository{}
+	c := NewRepository(repo, &Config{TrendsSize: 10, TrendsTTL: time.Minute})
+	ctx := context.Background()
+	from := time.Date(2019, 9, 22, 0, 0, 0, 0, time.UTC)
+	query := func(hashtag string, shift time.Duration) *repository.FilterImpl {
+		return &repository.FilterImpl{QueryRequest: model.QueryRequest{
+			FromDate: []time.Time{from.Add(shift)},
+			ToDate:   []time.Time{from.Add(24*time.Hour + shift)},
+			Rules:    model.QueryRules{Hashtag: []string{hashtag}, Aggregation: []time.Duration{time.Hour}},
+		}}
+	}
+
+	// Sliding ranges within same hour share whole hours.
+	for i := 0; i < 3; i++ {
+		_, err := c.Trends(ctx, query("go", time.Duration(i+1)*10*time.Minute))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 1, repo.reads)
+	assert.Equal(t, from.Add(-time.Minute), repo.filter.GetFromDate().UTC())
+	assert.Equal(t, from.Add(25*time.Hour), repo.filter.GetToDate().UTC())
+	_, err := c.Trends(ctx, query("rust", 0))
+	require.NoError(t, err)
+	assert.Equal(t, 2, repo.reads)
+	_, err = c.Trends(ctx, query("go", time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 3, repo.reads)
+
+	// Invalid queries are passed through to report errors.
+	_, err = c.Trends(ctx, &repository.FilterImpl{})
+	require.NoError(t, err)
+	_, err = c.Trends(ctx, &repository.FilterImpl{})
+	require.NoError(t, err)
+	assert.Equal(t, 5, repo.reads)
+}
+
+func TestIDs(t *testing.T) {
+	ids := NewIDs(10, time.Hour)
+	_, ok := ids.UserID("john")
+	assert.False(t, ok)
+	ids.AddUser("john", 7)
+	ids.AddHashtag("go", 3)
+	id, ok := ids.UserID("john")
+	assert.True(t, ok)
+	assert.Equal(t, uint(7), id)
+	id, ok = ids.HashtagID("go")
+	assert.True(t, ok)
+	assert.Equal(t, uint(3), id)
+}
@@ -0,0 +1,276 @@
+// Package client provides typed Go client for Dunder HTTP API.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jozuenoon/dunder/model"
+)
+
+const (
+	defaultRetries = 3
+	defaultBackoff = 200 * time.Millisecond
+	maxBackoff     = 10 * time.Second
+
+	idempotencyKeyHeader = "Idempotency-Key"
+)
+
+type Option func(*Client)
+
+// WithHTTPClient sets underlying http client, eg. with custom TLS config.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.http = hc
+	}
+}
+
+// WithUser authenticates requests as given user.
+func WithUser(name string) Option {
+	return WithToken(base64.StdEncoding.EncodeToString([]byte(name)))
+}
+
+// WithToken sets raw bearer token.
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// WithRetries sets number of retries and initial backoff which is doubled on each attempt.
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.retries = retries
+		c.backoff = backoff
+	}
+}
+
+// New creates client for Dunder API available at baseURL, eg. https://localhost:9000.
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid base url: %s", baseURL)
+	}
+	c := &Client{
+		baseURL: u,
+		http:    http.DefaultClient,
+		retries: defaultRetries,
+		backoff: defaultBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+type Client struct {
+	baseURL *url.URL
+	http    *http.Client
+	token   string
+	retries int
+	backoff time.Duration
+}
+
+// CreateMessage posts message as authenticated user. When request has
+// IdempotencyKey it's sent in Idempotency-Key header and request is retried
+// also on network and server errors, as server won't create duplicate.
+func (c *Client) CreateMessage(ctx context.Context, req *model.CreateMessageRequest) (*model.CreateMessageResponse, error) {
+	if c.token == "" {
+		return nil, fmt.Errorf("creating message requires user, see WithUser option")
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	header := http.Header{}
+	if req.IdempotencyKey != "" {
+		header.Set(idempotencyKeyHeader, req.IdempotencyKey)
+	}
+	var resp model.CreateMessageResponse
+	return &resp, c.do(ctx, http.MethodPost, "/message", nil, header, body, &resp)
+}
+
+// CreateMessages posts batch of messages as authenticated user, results are in
+// order of messages. Batch is retried only when it was rate limited, messages
+// with IdempotencyKey could be safely sent again when call failed otherwise.
+func (c *Client) CreateMessages(ctx context.Context, msgs []*model.BatchMessage) (*model.CreateMessagesResponse, error) {
+	if c.token == "" {
+		return nil, fmt.Errorf("creating messages requires user, see WithUser option")
+	}
+	body, err := json.Marshal(msgs)
+	if err != nil {
+		return nil, err
+	}
+	var resp model.CreateMessagesResponse
+	return &resp, c.do(ctx, http.MethodPost, "/messages:batch", nil, nil, body, &resp)
+}
+
+// GetMessage returns single message by its ulid.
+func (c *Client) GetMessage(ctx context.Context, id string) (*model.Message, error) {
+	var resp model.GetMessageResponse
+	if err := c.do(ctx, http.MethodGet, "/message/"+url.PathEscape(id), nil, nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Message, nil
+}
+
+// Messages returns single page of messages matching query. Use NextCursor of
+// response to fetch following page or Iterate helper.
+func (c *Client) Messages(ctx context.Context, q *model.QueryRequest) (*model.QueryResponse, error) {
+	var resp model.QueryResponse
+	return &resp, c.do(ctx, http.MethodGet, "/message", queryValues(q), nil, nil, &resp)
+}
+
+// Trends returns aggregated message statistics, query requires date range and aggregation period.
+func (c *Client) Trends(ctx context.Context, q *model.QueryRequest) ([]*model.Trend, error) {
+	var resp model.QueryResponse
+	if err := c.do(ctx, http.MethodGet, "/trend", queryValues(q), nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Trends, nil
+}
+
+// response mirrors transport.Response envelope.
+type response struct {
+	Error string          `json:"error,omitempty"`
+	Data  json.RawMessage `json:"data,omitempty"`
+}
+
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, header http.Header, body []byte, out interface{}) error {
+	u := *c.baseURL
+	u.Path += path
+	u.RawQuery = query.Encode()
+
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		err := c.roundTrip(ctx, method, u.String(), header, body, out)
+		if err == nil {
+			return nil
+		}
+		idempotent := method == http.MethodGet || header.Get(idempotencyKeyHeader) != ""
+		wait, retry := c.shouldRetry(idempotent, err, backoff)
+		if !retry || attempt >= c.retries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func (c *Client) roundTrip(ctx context.Context, method, u string, header http.Header, body []byte, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, u, reader)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return &transportError{err: err}
+	}
+	defer resp.Body.Close()
+
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return &transportError{err: err}
+	}
+	var envelope response
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &envelope); err != nil && resp.StatusCode < 300 {
+			return fmt.Errorf("failed to decode response: %v", err)
+		}
+	}
+	if resp.StatusCode >= 300 {
+		return newError(resp, envelope.Error)
+	}
+	if out == nil || len(envelope.Data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(envelope.Data, out)
+}
+
+// shouldRetry decides whether failed request may be repeated. Requests which
+// aren't idempotent are only retried when server certainly didn't process them.
+func (c *Client) shouldRetry(idempotent bool, err error, backoff time.Duration) (time.Duration, bool) {
+	switch e := err.(type) {
+	case *transportError:
+		return backoff, idempotent
+	case *Error:
+		switch {
+		case e.StatusCode == http.StatusTooManyRequests:
+			if e.RetryAfter > 0 {
+				return e.RetryAfter, true
+			}
+			return backoff, true
+		case e.StatusCode >= 500:
+			return backoff, idempotent
+		}
+	}
+	return 0, false
+}
+
+func queryValues(q *model.QueryRequest) url.Values {
+	vals := url.Values{}
+	if q == nil {
+		return vals
+	}
+	for _, t := range q.FromDate {
+		vals.Add("from_date", t.Format(time.RFC3339))
+	}
+	for _, t := range q.ToDate {
+		vals.Add("to_date", t.Format(time.RFC3339))
+	}
+	for _, l := range q.Limit {
+		vals.Add("limit", strconv.FormatUint(uint64(l), 10))
+	}
+	for _, c := range q.Cursor {
+		vals.Add("cursor", c)
+	}
+	for _, u := range q.Rules.UserName {
+		vals.Add("user_name", u)
+	}
+	for _, h := range q.Rules.Hashtag {
+		vals.Add("hashtag", h)
+	}
+	for _, a := range q.Rules.Aggregation {
+		vals.Add("aggregation", a.String())
+	}
+	for _, c := range q.Consistency {
+		vals.Add("consistency", c)
+	}
+	return vals
+}
@@ -0,0 +1,154 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jozuenoon/dunder/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type envelope struct {
+	Error string      `json:"error,omitempty"`
+	Data  interface{} `json:"data,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func TestClient_CreateAndGetMessage(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/message":
+			assert.Equal(t, "Bearer am9obkBleGFtcGxlLmNvbQ==", r.Header.Get("Authorization"))
+			writeJSON(w, http.StatusCreated, envelope{Data: model.CreateMessageResponse{ID: "01DQ"}})
+		case r.Method == http.MethodGet && r.URL.Path == "/message/01DQ":
+			writeJSON(w, http.StatusOK, envelope{Data: model.GetMessageResponse{Message: model.Message{ID: "01DQ", Text: "hello"}}})
+		default:
+			writeJSON(w, http.StatusNotFound, envelope{Error: "record not found"})
+		}
+	}))
+	defer srv.Close()
+
+	c, err := New(srv.URL, WithUser("john@example.com"))
+	require.NoError(t, err)
+
+	created, err := c.CreateMessage(context.Background(), &model.CreateMessageRequest{Text: "hello"})
+	require.NoError(t, err)
+	assert.Equal(t, "01DQ", created.ID)
+
+	msg, err := c.GetMessage(context.Background(), created.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", msg.Text)
+
+	_, err = c.GetMessage(context.Background(), "missing")
+	assert.True(t, IsNotFound(err), "expected not found error, got: %v", err)
+	assert.EqualError(t, err, "dunder: 404: record not found")
+}
+
+func TestClient_CreateMessages(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/messages:batch", r.URL.Path)
+		var msgs []*model.BatchMessage
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&msgs))
+		assert.Equal(t, "k1", msgs[1].IdempotencyKey)
+		writeJSON(w, http.StatusMultiStatus, envelope{Data: model.CreateMessagesResponse{
+			Results: []*model.BatchMessageResult{{ID: "01DQ"}, {Error: "failed"}},
+			Created: 1,
+			Failed:  1,
+		}})
+	}))
+	defer srv.Close()
+
+	c, err := New(srv.URL, WithUser("john@example.com"))
+	require.NoError(t, err)
+
+	resp, err := c.CreateMessages(context.Background(), []*model.BatchMessage{{Text: "a"}, {Text: "b", IdempotencyKey: "k1"}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, resp.Failed)
+	assert.Equal(t, "failed", resp.Results[1].Error)
+}
+
+func TestClient_Iterate(t *testing.T) {
+	pages := map[string]model.QueryResponse{
+		"":  {Messages: []*model.Message{{ID: "3"}, {ID: "2"}}, NextCursor: "2"},
+		"2": {Messages: []*model.Message{{ID: "1"}}, NextCursor: "1"},
+		"1": {},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "release", r.URL.Query().Get("hashtag"))
+		writeJSON(w, http.StatusOK, envelope{Data: pages[r.URL.Query().Get("cursor")]})
+	}))
+	defer srv.Close()
+
+	c, err := New(srv.URL)
+	require.NoError(t, err)
+
+	it := c.Iterate(context.Background(), &model.QueryRequest{Rules: model.QueryRules{Hashtag: []string{"release"}}})
+	var ids []string
+	for it.Next() {
+		ids = append(ids, it.Message().ID)
+	}
+	require.NoError(t, it.Err())
+	assert.Equal(t, []string{"3", "2", "1"}, ids)
+}
+
+func TestClient_Iterate_DateRange(t *testing.T) {
+	from := time.Date(2019, 9, 22, 12, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time { return from.Add(time.Duration(minutes) * time.Minute) }
+	// Server drops date range once cursor is set.
+	pages := map[string]model.QueryResponse{
+		"":  {Messages: []*model.Message{{ID: "4", CreatedAt: at(3)}, {ID: "3", CreatedAt: at(2)}}, NextCursor: "3"},
+		"3": {Messages: []*model.Message{{ID: "2", CreatedAt: at(1)}, {ID: "1", CreatedAt: at(0)}}, NextCursor: "1"},
+		"1": {Messages: []*model.Message{{ID: "0", CreatedAt: at(-1)}}, NextCursor: "0"},
+	}
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		writeJSON(w, http.StatusOK, envelope{Data: pages[r.URL.Query().Get("cursor")]})
+	}))
+	defer srv.Close()
+
+	c, err := New(srv.URL)
+	require.NoError(t, err)
+
+	it := c.Iterate(context.Background(), &model.QueryRequest{
+		FromDate: []time.Time{from.Add(30 * time.Second)},
+		ToDate:   []time.Time{at(10)},
+	})
+	var ids []string
+	for it.Next() {
+		ids = append(ids, it.Message().ID)
+	}
+	require.NoError(t, it.Err())
+	assert.Equal(t, []string{"4", "3", "2"}, ids)
+	assert.Equal(t, 2, requests, "iteration should stop at lower date bound")
+}
+
+func TestClient_Retry(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			writeJSON(w, http.StatusServiceUnavailable, envelope{Error: "unavailable"})
+			return
+		}
+		writeJSON(w, http.StatusOK, envelope{Data: model.QueryResponse{Trends: []*model.Trend{{Count: 5}}}})
+	}))
+	defer srv.Close()
+
+	c, err := New(srv.URL, WithRetries(3, time.Millisecond))
+	require.NoError(t, err)
+
+	trends, err := c.Trends(context.Background(), &model.QueryRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, 3, calls)
+	assert.Len(t, trends, 1)
+}
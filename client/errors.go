@@ -0,0 +1,63 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Error is returned when Dunder API responds with non successful status code.
+type Error struct {
+	StatusCode int
+	Message    string
+	// RetryAfter is set when request was rate limited.
+	RetryAfter time.Duration
+}
+
+func newError(resp *http.Response, msg string) *Error {
+	e := &Error{
+		StatusCode: resp.StatusCode,
+		Message:    msg,
+	}
+	if e.Message == "" {
+		e.Message = http.StatusText(resp.StatusCode)
+	}
+	if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		e.RetryAfter = time.Duration(s) * time.Second
+	}
+	return e
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("dunder: %d: %s", e.StatusCode, e.Message)
+}
+
+// IsNotFound reports whether err was caused by missing resource.
+func IsNotFound(err error) bool {
+	return hasStatus(err, http.StatusNotFound)
+}
+
+// IsUnauthorized reports whether err was caused by missing or invalid credentials.
+func IsUnauthorized(err error) bool {
+	return hasStatus(err, http.StatusUnauthorized)
+}
+
+// IsRateLimited reports whether err was caused by exceeding rate limit.
+func IsRateLimited(err error) bool {
+	return hasStatus(err, http.StatusTooManyRequests)
+}
+
+func hasStatus(err error, code int) bool {
+	e, ok := err.(*Error)
+	return ok && e.StatusCode == code
+}
+
+// transportError wraps network level failures.
+type transportError struct {
+	err error
+}
+
+func (e *transportError) Error() string {
+	return e.err.Error()
+}
@@ -0,0 +1,96 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/jozuenoon/dunder/model"
+)
+
+// Iterate returns iterator walking through all messages matching query by
+// following pagination cursors.
+//
+//	it := c.Iterate(ctx, &model.QueryRequest{Rules: model.QueryRules{Hashtag: []string{"release"}}})
+//	for it.Next() {
+//		fmt.Println(it.Message().Text)
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+func (c *Client) Iterate(ctx context.Context, q *model.QueryRequest) *MessageIterator {
+	query := model.QueryRequest{}
+	if q != nil {
+		query = *q
+	}
+	it := &MessageIterator{
+		client: c,
+		ctx:    ctx,
+		query:  query,
+	}
+	// Same date range as server uses, see repository.FilterImpl.
+	if len(query.FromDate) > 0 && len(query.ToDate) > 0 {
+		from, to := query.FromDate[0].Truncate(time.Minute), query.ToDate[0].Truncate(time.Minute)
+		if from.Before(to) {
+			it.fromDate = from
+		}
+	}
+	return it
+}
+
+type MessageIterator struct {
+	client *Client
+	ctx    context.Context
+	query  model.QueryRequest
+	// fromDate is lower bound of date range, cursor queries don't respect it.
+	fromDate time.Time
+
+	page    []*model.Message
+	current *model.Message
+	done    bool
+	err     error
+}
+
+// Next advances iterator, it returns false when there are no more messages or error occurred.
+func (it *MessageIterator) Next() bool {
+	for len(it.page) == 0 {
+		if it.done || it.err != nil {
+			return false
+		}
+		it.fetch()
+	}
+	it.current, it.page = it.page[0], it.page[1:]
+	return true
+}
+
+// Message returns current message.
+func (it *MessageIterator) Message() *model.Message {
+	return it.current
+}
+
+// Err returns first error encountered during iteration.
+func (it *MessageIterator) Err() error {
+	return it.err
+}
+
+func (it *MessageIterator) fetch() {
+	resp, err := it.client.Messages(it.ctx, &it.query)
+	if err != nil {
+		it.err = err
+		return
+	}
+	it.page = resp.Messages
+	// Messages are ordered newest first, so iteration ends at first one
+	// outside of date range.
+	for i, m := range it.page {
+		if !it.fromDate.IsZero() && !m.CreatedAt.After(it.fromDate) {
+			it.page = it.page[:i]
+			it.done = true
+			return
+		}
+	}
+	if resp.NextCursor == "" || len(resp.Messages) == 0 {
+		it.done = true
+		return
+	}
+	it.query.Cursor = []string{resp.NextCursor}
+}
@@ -1,14 +1,26 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
+	"github.com/jozuenoon/dunder/archive"
+	"github.com/jozuenoon/dunder/cache"
+	"github.com/jozuenoon/dunder/metrics"
+	"github.com/jozuenoon/dunder/outbox"
+	"github.com/jozuenoon/dunder/projection"
+	"github.com/jozuenoon/dunder/repository"
 	"github.com/jozuenoon/dunder/repository/cockroach"
 	"github.com/jozuenoon/dunder/service"
+	"github.com/jozuenoon/dunder/tracing"
 	"github.com/jozuenoon/dunder/transport"
+	"github.com/jozuenoon/dunder/webhook"
 	"github.com/rs/zerolog"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
 
 	"github.com/gorilla/mux"
 	"github.com/stevenroose/gonfig"
@@ -21,31 +33,189 @@ var config = struct {
 
 	LogLevel string `id:"log_level" desc:"Options: debug, info, warn, error, fatal, panic"`
 
+	BaseURL string `id:"base_url" desc:"Public URL of service used in feed links, empty uses host of request"`
+
 	CockroachDB *CockroachDBConfig `id:"cockroach"`
 
 	TlsConfig *TlsConfig `id:"tls"`
 
+	Tracing *TracingConfig `id:"tracing"`
+
+	Server *ServerConfig `id:"server"`
+
+	RateLimit *RateLimitConfig `id:"rate_limit"`
+
+	Webhook *WebhookConfig `id:"webhook"`
+
+	Outbox *OutboxConfig `id:"outbox"`
+
+	Query *QueryConfig `id:"query"`
+
+	Archive *ArchiveConfig `id:"archive"`
+
+	Cache *CacheConfig `id:"cache"`
+
+	Snapshot *SnapshotConfig `id:"snapshot"`
+
+	Importers []string `id:"importers" desc:"Users allowed to backfill messages with original created_at"`
+
 	ConfigFile string `id:"config_file" desc:"provide a config file path"`
 }{
 	Port:     9000,
 	LogLevel: "debug",
+	CockroachDB: &CockroachDBConfig{
+		Port:              26257,
+		SSLMode:           "disable",
+		MaxOpenConns:      25,
+		MaxIdleConns:      25,
+		ConnMaxLifetime:   newDuration(5 * time.Minute),
+		IdempotencyWindow: newDuration(24 * time.Hour),
+		ReadConsistency:   "strong",
+	},
+	Server: &ServerConfig{
+		ReadTimeout:     newDuration(15 * time.Second),
+		WriteTimeout:    newDuration(30 * time.Second),
+		IdleTimeout:     newDuration(2 * time.Minute),
+		ShutdownTimeout: newDuration(30 * time.Second),
+		HookTimeout:     newDuration(10 * time.Second),
+	},
+	RateLimit: &RateLimitConfig{
+		UserRate:  1,
+		UserBurst: 10,
+		IPRate:    20,
+		IPBurst:   40,
+	},
+	Webhook: &WebhookConfig{
+		Workers:        4,
+		QueueSize:      100,
+		MaxAttempts:    8,
+		InitialBackoff: newDuration(time.Second),
+		MaxBackoff:     newDuration(5 * time.Minute),
+		Timeout:        newDuration(10 * time.Second),
+		PollInterval:   newDuration(time.Second),
+	},
+	Outbox: &OutboxConfig{
+		PollInterval: newDuration(time.Second),
+		BatchSize:    100,
+	},
+	Query: &QueryConfig{
+		Store:        queryStoreCockroach,
+		RedisAddr:    "localhost:6379",
+		RedisPrefix:  "dunder:",
+		PollInterval: newDuration(500 * time.Millisecond),
+		BatchSize:    500,
+	},
+	Archive: &ArchiveConfig{
+		MaxAge:    newDuration(90 * 24 * time.Hour),
+		Interval:  newDuration(time.Hour),
+		BatchSize: 1000,
+	},
+	Cache: &CacheConfig{
+		MessageSize: 10000,
+		MessageTTL:  newDuration(10 * time.Minute),
+		TrendsSize:  1000,
+		TrendsTTL:   newDuration(10 * time.Second),
+		IDSize:      10000,
+		IDTTL:       newDuration(time.Hour),
+	},
+	Snapshot: &SnapshotConfig{
+		BatchSize: 500,
+	},
 }
 
+const commandRebuildProjection = "rebuild-projection"
+
 type TlsConfig struct {
 	CertFile string `id:"crt" desc:"TLS certificate file path"`
 	KeyFile  string `id:"key" desc:"TLS key file path"`
 }
 
+//go:generate gomodifytags -file dunder.go -struct RateLimitConfig -add-tags id -w
+type RateLimitConfig struct {
+	UserRate       float64  `id:"user_rate" desc:"Messages per second single user may post, 0 disables limit"`
+	UserBurst      int      `id:"user_burst" desc:"Maximum burst of messages posted by single user"`
+	IPRate         float64  `id:"ip_rate" desc:"Read requests per second from single client IP, 0 disables limit"`
+	IPBurst        int      `id:"ip_burst" desc:"Maximum burst of read requests from single client IP"`
+	TrustForwarded bool     `id:"trust_forwarded" desc:"Take client IP from X-Forwarded-For header"`
+	TrustedProxies []string `id:"trusted_proxies" desc:"Networks of proxies skipped in X-Forwarded-For, eg. 10.0.0.0/8"`
+}
+
+//go:generate gomodifytags -file dunder.go -struct WebhookConfig -add-tags id -w
+type WebhookConfig struct {
+	Workers        int       `id:"workers" desc:"Number of concurrent webhook deliveries" validate:"min=1"`
+	QueueSize      int       `id:"queue_size" desc:"Number of due deliveries claimed at once" validate:"min=1"`
+	MaxAttempts    int       `id:"max_attempts" desc:"Delivery attempts before event is moved to dead letters" validate:"min=1"`
+	InitialBackoff *Duration `id:"initial_backoff" desc:"Delay before first retry, doubled on each attempt"`
+	MaxBackoff     *Duration `id:"max_backoff" desc:"Maximum delay between retries"`
+	Timeout        *Duration `id:"timeout" desc:"Timeout of single delivery request"`
+	PollInterval   *Duration `id:"poll_interval" desc:"Delay between checks for due deliveries"`
+	// AllowedNetworks lets webhooks reach internal services, by default only public addresses are allowed.
+	AllowedNetworks []string `id:"allowed_networks" desc:"Internal networks webhooks may target, eg. 10.1.0.0/16"`
+}
+
+//go:generate gomodifytags -file dunder.go -struct OutboxConfig -add-tags id -w
+type OutboxConfig struct {
+	PollInterval *Duration `id:"poll_interval" desc:"Delay between checks for new events in outbox"`
+	BatchSize    uint      `id:"batch_size" desc:"Number of events relayed at once" validate:"min=1"`
+}
+
+//go:generate gomodifytags -file dunder.go -struct ArchiveConfig -add-tags id -w
+type ArchiveConfig struct {
+	Dir       string    `id:"dir" desc:"Archive directory, empty disables archive tier"`
+	Archiver  bool      `id:"archiver" desc:"Move old messages to archive, enable on single instance sharing archive directory"`
+	MaxAge    *Duration `id:"max_age" desc:"Age after which messages are moved to archive"`
+	Interval  *Duration `id:"interval" desc:"Delay between archiver runs"`
+	BatchSize uint      `id:"batch_size" desc:"Number of messages archived at once" validate:"min=1"`
+}
+
+//go:generate gomodifytags -file dunder.go -struct CacheConfig -add-tags id -w
+type CacheConfig struct {
+	MessageSize int       `id:"message_size" desc:"Number of messages cached by ulid, 0 disables cache" validate:"min=0"`
+	MessageTTL  *Duration `id:"message_ttl" desc:"How long messages are cached"`
+	TrendsSize  int       `id:"trends_size" desc:"Number of cached trends queries, 0 disables cache" validate:"min=0"`
+	TrendsTTL   *Duration `id:"trends_ttl" desc:"How long trends results are cached"`
+	IDSize      int       `id:"id_size" desc:"Number of cached user and hashtag ids, 0 disables cache" validate:"min=0"`
+	IDTTL       *Duration `id:"id_ttl" desc:"How long user and hashtag ids are cached"`
+}
+
+type TracingConfig struct {
+	Exporter string `id:"exporter" desc:"Trace exporter, options: none, stdout, file"`
+	File     string `id:"file" desc:"Trace output file path used by file exporter"`
+}
+
 //go:generate gomodifytags -file dunder.go -struct CockroachDBConfig -add-tags id -w
 type CockroachDBConfig struct {
-	Host          string `id:"host"`
-	ShouldMigrate bool   `id:"should_migrate"`
-	Debug         bool   `id:"debug"`
-	Database      string `id:"database"`
-	User          string `id:"user"`
+	Host            string    `id:"host" desc:"Database host"`
+	Port            int       `id:"port" desc:"Database port" validate:"min=1,max=65535"`
+	MigrateOnStart  bool      `id:"migrate_on_start" desc:"Apply pending schema migrations on start, replicas take turns"`
+	Debug           bool      `id:"debug" desc:"Log SQL statements"`
+	Database        string    `id:"database" desc:"Database name"`
+	User            string    `id:"user" desc:"Database user"`
+	Password        string    `id:"password" desc:"Database password, prefer password_file"`
+	PasswordFile    string    `id:"password_file" desc:"File containing database password"`
+	SSLMode         string    `id:"sslmode" desc:"Options: disable, require, verify-ca, verify-full" validate:"oneof=disable require verify-ca verify-full"`
+	SSLRootCert     string    `id:"sslrootcert" desc:"CA certificate file path used to verify server"`
+	SSLCert         string    `id:"sslcert" desc:"Client certificate file path"`
+	SSLKey          string    `id:"sslkey" desc:"Client key file path"`
+	DSN             string    `id:"dsn" desc:"Full connection string, overrides all connection options"`
+	ReadDSN         string    `id:"read_dsn" desc:"Connection string of read replica serving searches, falls back to primary when unreachable"`
+	MaxOpenConns    int       `id:"max_open_conns" desc:"Maximum number of open connections, 0 means unlimited" validate:"min=0"`
+	MaxIdleConns    int       `id:"max_idle_conns" desc:"Maximum number of idle connections" validate:"min=0"`
+	ConnMaxLifetime *Duration `id:"conn_max_lifetime" desc:"Maximum time connection may be reused, 0 means forever"`
+
+	IdempotencyWindow *Duration `id:"idempotency_window" desc:"How long idempotency keys of created messages are remembered"`
+	ReadConsistency   string    `id:"read_consistency" desc:"Default consistency of searches and trends, options: strong, stale" validate:"oneof=strong stale"`
+	ReadStaleness     *Duration `id:"read_staleness" desc:"Age of stale reads, 0 uses follower_read_timestamp()"`
 }
 
 func main() {
+	command := popCommand()
+	var commandArg string
+	switch command {
+	case commandMigrate, commandExport, commandImport:
+		commandArg = popCommand()
+	}
+
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
 	level, err := zerolog.ParseLevel(config.LogLevel)
 	if err != nil {
@@ -66,34 +236,220 @@ func main() {
 		log.Fatal().Err(err).Msg("config validation failed")
 	}
 
+	switch command {
+	case "", commandRebuildProjection, commandMigrate, commandExport, commandImport:
+	default:
+		log.Fatal().Str("command", command).Msg("unknown command")
+	}
+
+	shutdownTracing, err := tracing.Setup(&tracing.Config{
+		ServiceName: "dunder",
+		Exporter:    config.Tracing.Exporter,
+		File:        config.Tracing.File,
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to setup tracing")
+	}
 	repoSvc, err := cockroach.New(&cockroach.Config{
-		Host:          config.CockroachDB.Host,
-		ShouldMigrate: config.CockroachDB.ShouldMigrate,
-		Debug:         config.CockroachDB.Debug,
-		Database:      &config.CockroachDB.Database,
-		User:          &config.CockroachDB.User,
+		Host:           config.CockroachDB.Host,
+		Port:           config.CockroachDB.Port,
+		MigrateOnStart: config.CockroachDB.MigrateOnStart && command == "",
+		Debug:          config.CockroachDB.Debug,
+		Database:       &config.CockroachDB.Database,
+		User:           &config.CockroachDB.User,
+		Password:       config.CockroachDB.Password,
+		PasswordFile:   config.CockroachDB.PasswordFile,
+		SSLMode:        config.CockroachDB.SSLMode,
+		SSLRootCert:    config.CockroachDB.SSLRootCert,
+		SSLCert:        config.CockroachDB.SSLCert,
+		SSLKey:         config.CockroachDB.SSLKey,
+		DSN:            config.CockroachDB.DSN,
+		ReadDSN:        config.CockroachDB.ReadDSN,
+
+		MaxOpenConns:    config.CockroachDB.MaxOpenConns,
+		MaxIdleConns:    config.CockroachDB.MaxIdleConns,
+		ConnMaxLifetime: config.CockroachDB.ConnMaxLifetime.Value(),
+
+		IdempotencyWindow: config.CockroachDB.IdempotencyWindow.Value(),
+		ReadConsistency:   config.CockroachDB.ReadConsistency,
+		ReadStaleness:     config.CockroachDB.ReadStaleness.Value(),
+		IDs:               cache.NewIDs(config.Cache.IDSize, config.Cache.IDTTL.Value()),
 	})
 	if err != nil {
 		log.Fatal().Err(err).Msg("failed to create cockroach repo")
 	}
 
-	dunder := service.NewDunder(repoSvc, &log)
-	dunderSearch := service.NewDunderSearch(repoSvc, &log)
+	if command == commandMigrate {
+		err := runMigrate(repoSvc, commandArg, &log)
+		repoSvc.Close()
+		if err != nil {
+			log.Fatal().Err(err).Msg("migration failed")
+		}
+		return
+	}
+
+	if command == commandExport || command == commandImport {
+		if command == commandExport {
+			err = runExport(exportSource(repoSvc, config.Archive), commandArg, config.Snapshot, &log)
+		} else {
+			err = runImport(repoSvc, commandArg, config.Snapshot, &log)
+		}
+		repoSvc.Close()
+		if err != nil {
+			log.Fatal().Err(err).Str("command", command).Msg("snapshot failed")
+		}
+		return
+	}
+
+	if command == commandRebuildProjection {
+		err := rebuildProjection(repoSvc, config.Query, &log)
+		repoSvc.Close()
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to rebuild projection")
+		}
+		return
+	}
+
+	if err := metrics.RegisterDB("cockroach", repoSvc.DB.DB()); err != nil {
+		log.Fatal().Err(err).Msg("failed to register database metrics")
+	}
+	if replica := repoSvc.ReadReplica(); replica != nil {
+		if err := metrics.RegisterDB("cockroach_read", replica); err != nil {
+			log.Fatal().Err(err).Msg("failed to register read replica metrics")
+		}
+	}
+	repo := cache.NewRepository(metrics.NewRepository(repoSvc), &cache.Config{
+		MessageSize: config.Cache.MessageSize,
+		MessageTTL:  config.Cache.MessageTTL.Value(),
+		TrendsSize:  config.Cache.TrendsSize,
+		TrendsTTL:   config.Cache.TrendsTTL.Value(),
+	})
+
+	webhookNetworks, err := transport.ParseCIDRs(config.Webhook.AllowedNetworks)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to parse webhook allowed networks")
+	}
+	dispatcher := webhook.NewDispatcher(repoSvc, &webhook.Config{
+		Workers:         config.Webhook.Workers,
+		QueueSize:       config.Webhook.QueueSize,
+		MaxAttempts:     config.Webhook.MaxAttempts,
+		InitialBackoff:  config.Webhook.InitialBackoff.Value(),
+		MaxBackoff:      config.Webhook.MaxBackoff.Value(),
+		Timeout:         config.Webhook.Timeout.Value(),
+		PollInterval:    config.Webhook.PollInterval.Value(),
+		AllowedNetworks: webhookNetworks,
+	}, &log)
+
+	relay := outbox.NewRelay(repoSvc, []outbox.Sink{dispatcher}, &outbox.Config{
+		PollInterval: config.Outbox.PollInterval.Value(),
+		BatchSize:    config.Outbox.BatchSize,
+	}, &log)
+
+	var queryStore repository.QueryStore = repo
+	var archiver *archive.Archiver
+	if config.Archive.Dir != "" {
+		archiveDir := archive.New(config.Archive.Dir)
+		if config.Archive.Archiver {
+			archiver = archive.NewArchiver(repo.InvalidateOnDelete(repoSvc), archiveDir, &archive.Config{
+				MaxAge:    config.Archive.MaxAge.Value(),
+				Interval:  config.Archive.Interval.Value(),
+				BatchSize: config.Archive.BatchSize,
+			}, &log)
+			archiver.Start()
+		}
+		// Projections keep whole event log, so only live database needs archive tier.
+		if config.Query.Store == queryStoreCockroach {
+			queryStore = archive.NewTiered(repo, archiveDir, config.Archive.MaxAge.Value())
+		}
+	}
+	var projector *projection.Projector
+	closeQueryStore := func() error { return nil }
+	if config.Query.Store != queryStoreCockroach {
+		store, closeStore, err := newQueryStore(config.Query)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to create query store")
+		}
+		projector = newProjector(repoSvc, store, config.Query, &log)
+		if err := projector.CatchUp(context.Background()); err != nil {
+			log.Fatal().Err(err).Msg("failed to project event log")
+		}
+		projector.Start()
+		queryStore, closeQueryStore = store, closeStore
+	}
 
-	dunderHttp := transport.NewHttp(dunder, dunderSearch, &log)
+	dunder := service.NewDunder(repo, &service.DunderConfig{
+		Importers: config.Importers,
+	}, &log)
+	dunderSearch := service.NewDunderSearch(queryStore, &log)
+
+	health := service.NewHealth(repo, &log)
+	webhooks := service.NewWebhooks(repoSvc, webhook.NewGuard(webhookNetworks), &log)
+
+	dunderHttp := transport.NewHttp(dunder, dunderSearch, health, webhooks, &log)
+	dunderHttp.SetBaseURL(config.BaseURL)
 
 	r := mux.NewRouter()
-	r.HandleFunc("/message", dunderHttp.CreateMessage).Methods(http.MethodPost)
-	r.HandleFunc("/message", dunderHttp.MessageQuery).Methods(http.MethodGet)
-	r.HandleFunc("/message/{ulid}", dunderHttp.MessageQuery).Methods(http.MethodGet)
-	r.HandleFunc("/trend", dunderHttp.Trends).Methods(http.MethodGet)
+	r.Use(otelmux.Middleware("dunder"))
+	r.Use(metrics.Middleware)
+	r.Handle("/metrics", metrics.Handler()).Methods(http.MethodGet)
 
-	if config.TLS {
-		if err := http.ListenAndServeTLS(fmt.Sprintf(":%d", config.Port), config.TlsConfig.CertFile, config.TlsConfig.KeyFile, r); err != nil {
-			log.Fatal().Err(err).Msg("server failed")
+	limits := map[transport.RateLimit]func(http.Handler) http.Handler{
+		transport.NoLimit:   noLimit,
+		transport.UserLimit: noLimit,
+		transport.IPLimit:   noLimit,
+	}
+	if config.RateLimit.UserRate > 0 {
+		limits[transport.UserLimit] = dunderHttp.UserRateLimit(transport.NewRateLimiter(config.RateLimit.UserRate, config.RateLimit.UserBurst))
+	}
+	if config.RateLimit.IPRate > 0 {
+		proxies, err := transport.ParseCIDRs(config.RateLimit.TrustedProxies)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to parse trusted proxies")
 		}
+		limits[transport.IPLimit] = dunderHttp.IPRateLimit(transport.NewRateLimiter(config.RateLimit.IPRate, config.RateLimit.IPBurst),
+			config.RateLimit.TrustForwarded, proxies)
 	}
-	if err := http.ListenAndServe(fmt.Sprintf(":%d", config.Port), r); err != nil {
+	for _, route := range dunderHttp.Routes() {
+		r.Handle(route.Path, limits[route.RateLimit](route.Handler)).Methods(route.Method)
+	}
+
+	var tlsConfig *TlsConfig
+	if config.TLS {
+		tlsConfig = config.TlsConfig
+	}
+	srv := newServer(fmt.Sprintf(":%d", config.Port), r, config.Server, tlsConfig, health, &log)
+	srv.onShutdown("repository", func(context.Context) error {
+		return repoSvc.Close()
+	})
+	srv.onShutdown("tracing", shutdownTracing)
+	srv.onShutdown("webhooks", dispatcher.Close)
+	srv.onShutdown("outbox", relay.Close)
+	srv.onShutdown("query store", func(context.Context) error {
+		return closeQueryStore()
+	})
+	if projector != nil {
+		srv.onShutdown("projection", projector.Close)
+	}
+	if archiver != nil {
+		srv.onShutdown("archiver", archiver.Close)
+	}
+
+	if err := srv.run(); err != nil {
 		log.Fatal().Err(err).Msg("server failed")
 	}
 }
+
+// popCommand removes optional command preceding flags from arguments, called
+// again it removes command argument like `up` in `dunder migrate up`.
+func popCommand() string {
+	if len(os.Args) < 2 || strings.HasPrefix(os.Args[1], "-") {
+		return ""
+	}
+	command := os.Args[1]
+	os.Args = append(os.Args[:1], os.Args[2:]...)
+	return command
+}
+
+func noLimit(next http.Handler) http.Handler {
+	return next
+}
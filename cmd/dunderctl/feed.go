@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/araddon/dateparse"
+	"github.com/jozuenoon/dunder/client"
+	"github.com/jozuenoon/dunder/model"
+)
+
+// tail prints latest messages oldest first and with -f polls for new ones.
+func tail(ctx context.Context, c *client.Client, args []string) error {
+	fs := flag.NewFlagSet("tail", flag.ExitOnError)
+	n := fs.Uint("n", 10, "number of latest messages to print")
+	follow := fs.Bool("f", false, "follow new messages")
+	interval := fs.Duration("interval", 5*time.Second, "poll interval used with -f")
+	hashtag := fs.String("hashtag", "", "only messages with hashtag")
+	user := fs.String("user", "", "only messages of user")
+	_ = fs.Parse(args)
+
+	query := &model.QueryRequest{
+		Limit: []uint{*n},
+		Rules: rules(*user, *hashtag),
+	}
+
+	var last string
+	for {
+		resp, err := c.Messages(ctx, query)
+		if err != nil {
+			return err
+		}
+		// Messages are returned newest first.
+		for i := len(resp.Messages) - 1; i >= 0; i-- {
+			m := resp.Messages[i]
+			if m.ID > last {
+				printMessage(m)
+				last = m.ID
+			}
+		}
+		if !*follow {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(*interval):
+		}
+	}
+}
+
+// search prints all messages matching filters following pagination cursor.
+func search(ctx context.Context, c *client.Client, args []string) error {
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+	hashtag := fs.String("hashtag", "", "only messages with hashtag")
+	user := fs.String("user", "", "only messages of user")
+	from := fs.String("from", "", "from date, eg. 2019-09-22 or 2019-09-22T10:00:00Z")
+	to := fs.String("to", "", "to date, defaults to now")
+	limit := fs.Int("limit", 100, "maximum number of messages, 0 prints all")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: dunderctl search [flags]")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	query := &model.QueryRequest{Rules: rules(*user, *hashtag)}
+	if *from != "" {
+		fromDate, toDate, err := dateRange(*from, *to)
+		if err != nil {
+			return err
+		}
+		query.FromDate = []time.Time{fromDate}
+		query.ToDate = []time.Time{toDate}
+	}
+
+	it := c.Iterate(ctx, query)
+	for count := 0; (*limit == 0 || count < *limit) && it.Next(); count++ {
+		printMessage(it.Message())
+	}
+	return it.Err()
+}
+
+func rules(user, hashtag string) model.QueryRules {
+	var r model.QueryRules
+	if user != "" {
+		r.UserName = []string{user}
+	}
+	if hashtag = strings.TrimPrefix(hashtag, "#"); hashtag != "" {
+		r.Hashtag = []string{hashtag}
+	}
+	return r
+}
+
+func dateRange(from, to string) (time.Time, time.Time, error) {
+	fromDate, err := dateparse.ParseAny(from)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid from date: %v", err)
+	}
+	toDate := time.Now()
+	if to != "" {
+		toDate, err = dateparse.ParseAny(to)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid to date: %v", err)
+		}
+	}
+	return fromDate, toDate, nil
+}
+
+func printMessage(m *model.Message) {
+	var tags []string
+	for _, h := range m.Hashtags {
+		tags = append(tags, "#"+h)
+	}
+	fmt.Printf("%s  %-20s %s %s\n",
+		m.CreatedAt.Local().Format("2006-01-02 15:04:05"),
+		m.User.Name,
+		m.Text,
+		strings.Join(tags, " "))
+}
@@ -0,0 +1,103 @@
+// Command dunderctl shares and browses Dunder messages from terminal.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/jozuenoon/dunder/client"
+)
+
+const usage = `Usage: dunderctl [global flags] <command> [flags]
+
+Commands:
+  post     post message, text is read from -m flag or stdin
+  tail     print latest messages, optionally following new ones
+  search   search messages by user, hashtag and date range
+  trends   print hashtag trend sparkline
+
+Examples:
+  make test 2>&1 | dunderctl post ci
+  dunderctl tail -f -hashtag release
+  dunderctl trends -hashtag release -from 2019-09-22 -aggregation 1h
+
+Global flags:
+`
+
+type command func(ctx context.Context, c *client.Client, args []string) error
+
+var commands = map[string]command{
+	"post":   post,
+	"tail":   tail,
+	"search": search,
+	"trends": trends,
+}
+
+func main() {
+	global := flag.NewFlagSet("dunderctl", flag.ExitOnError)
+	addr := global.String("url", envOr("DUNDER_URL", "https://localhost:9000"), "Dunder API address, env DUNDER_URL")
+	user := global.String("user", envOr("DUNDER_USER", os.Getenv("USER")), "user name messages are posted as, env DUNDER_USER")
+	insecure := global.Bool("insecure", false, "skip TLS certificate verification")
+	global.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		global.PrintDefaults()
+	}
+	_ = global.Parse(os.Args[1:])
+
+	if global.NArg() == 0 {
+		global.Usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[global.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n\n", global.Arg(0))
+		global.Usage()
+		os.Exit(2)
+	}
+
+	httpClient := &http.Client{}
+	if *insecure {
+		httpClient.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, // nolint: gosec
+		}
+	}
+	opts := []client.Option{client.WithHTTPClient(httpClient)}
+	if *user != "" {
+		opts = append(opts, client.WithUser(*user))
+	}
+	c, err := client.New(*addr, opts...)
+	if err != nil {
+		fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	if err := cmd(ctx, c, global.Args()[1:]); err != nil && ctx.Err() == nil {
+		fatal(err)
+	}
+}
+
+func envOr(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return def
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "dunderctl: %s\n", err)
+	os.Exit(1)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/jozuenoon/dunder/client"
+	"github.com/jozuenoon/dunder/model"
+)
+
+// post sends message, positional arguments are hashtags with optional `#` prefix.
+// Note that shell treats unquoted `#tag` as comment, so use `ci` or `'#ci'`.
+func post(ctx context.Context, c *client.Client, args []string) error {
+	fs := flag.NewFlagSet("post", flag.ExitOnError)
+	text := fs.String("m", "", "message text, read from stdin if empty")
+	key := fs.String("key", "", "idempotency key, eg. CI build id, so repeated posts create single message; random if empty")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: dunderctl post [-m text] [-key key] [hashtag ...]")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	msg := *text
+	if msg == "" {
+		if !isPiped(os.Stdin) {
+			return errors.New("no message text, use -m flag or pipe text to stdin")
+		}
+		b, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		msg = strings.TrimRight(string(b), "\n")
+	}
+	if msg == "" {
+		return errors.New("message text is empty")
+	}
+
+	var hashtags []string
+	for _, arg := range fs.Args() {
+		if tag := strings.TrimPrefix(arg, "#"); tag != "" {
+			hashtags = append(hashtags, tag)
+		}
+	}
+
+	// Random key still makes retries of this invocation safe.
+	if *key == "" {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		*key = hex.EncodeToString(b)
+	}
+
+	resp, err := c.CreateMessage(ctx, &model.CreateMessageRequest{
+		Text:           msg,
+		Hashtags:       hashtags,
+		IdempotencyKey: *key,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println(resp.ID)
+	return nil
+}
+
+func isPiped(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice == 0
+}
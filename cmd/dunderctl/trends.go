@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jozuenoon/dunder/client"
+	"github.com/jozuenoon/dunder/model"
+)
+
+var sparks = []rune("▁▂▃▄▅▆▇█")
+
+// trends prints hashtag occurrence over time as sparkline.
+func trends(ctx context.Context, c *client.Client, args []string) error {
+	fs := flag.NewFlagSet("trends", flag.ExitOnError)
+	hashtag := fs.String("hashtag", "", "hashtag to show trend for, all hashtags if empty")
+	from := fs.String("from", "", "from date, defaults to 24 hours ago")
+	to := fs.String("to", "", "to date, defaults to now")
+	aggregation := fs.Duration("aggregation", time.Hour, "bucket size, at least 1m")
+	_ = fs.Parse(args)
+
+	if *aggregation < time.Minute {
+		return errors.New("aggregation must be at least 1m")
+	}
+	if *from == "" {
+		*from = time.Now().Add(-24 * time.Hour).Format(time.RFC3339)
+	}
+	fromDate, toDate, err := dateRange(*from, *to)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.Trends(ctx, &model.QueryRequest{
+		FromDate: []time.Time{fromDate},
+		ToDate:   []time.Time{toDate},
+		Rules: model.QueryRules{
+			Hashtag:     rules("", *hashtag).Hashtag,
+			Aggregation: []time.Duration{*aggregation},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	counts := fillBuckets(resp, fromDate.Truncate(*aggregation), toDate, *aggregation)
+	var total, max uint
+	for _, c := range counts {
+		total += c
+		if c > max {
+			max = c
+		}
+	}
+	label := "all hashtags"
+	if tag := rules("", *hashtag).Hashtag; len(tag) > 0 {
+		label = "#" + tag[0]
+	}
+	fmt.Printf("%s %s\n", sparkline(counts), label)
+	fmt.Printf("%s .. %s  total: %d  max: %d per %s\n",
+		fromDate.Local().Format("2006-01-02 15:04"), toDate.Local().Format("2006-01-02 15:04"), total, max, *aggregation)
+	return nil
+}
+
+// fillBuckets lays out trend counts on continuous timeline, as API skips empty buckets.
+func fillBuckets(trends []*model.Trend, from, to time.Time, step time.Duration) []uint {
+	var counts []uint
+	for t := from; t.Before(to); t = t.Add(step) {
+		counts = append(counts, 0)
+	}
+	for _, tr := range trends {
+		i := int(tr.FromDate.Sub(from) / step)
+		if i >= 0 && i < len(counts) {
+			counts[i] += tr.Count
+		}
+	}
+	return counts
+}
+
+func sparkline(counts []uint) string {
+	var max uint
+	for _, c := range counts {
+		if c > max {
+			max = c
+		}
+	}
+	var b strings.Builder
+	for _, c := range counts {
+		if max == 0 {
+			b.WriteRune(sparks[0])
+			continue
+		}
+		b.WriteRune(sparks[int(c*uint(len(sparks)-1)/max)])
+	}
+	return b.String()
+}
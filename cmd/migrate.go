@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/jozuenoon/dunder/repository/cockroach"
+	"github.com/rs/zerolog"
+)
+
+const (
+	commandMigrate = "migrate"
+
+	migrateUp     = "up"
+	migrateDown   = "down"
+	migrateStatus = "status"
+)
+
+// runMigrate executes `dunder migrate up|down|status`.
+func runMigrate(repo *cockroach.ServiceImpl, action string, log *zerolog.Logger) error {
+	ctx := context.Background()
+	switch action {
+	case migrateUp:
+		applied, err := repo.MigrateUp(ctx)
+		for _, m := range applied {
+			log.Info().Uint("version", m.Version).Str("name", m.Name).Msg("migration applied")
+		}
+		if err == nil && len(applied) == 0 {
+			log.Info().Msg("schema is up to date")
+		}
+		return err
+	case migrateDown:
+		reverted, err := repo.MigrateDown(ctx)
+		if err != nil {
+			return err
+		}
+		if reverted == nil {
+			log.Info().Msg("no migration to revert")
+			return nil
+		}
+		log.Info().Uint("version", reverted.Version).Str("name", reverted.Name).Msg("migration reverted")
+		return nil
+	case migrateStatus:
+		statuses, err := repo.Migrations(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+		for _, m := range statuses {
+			applied := "pending"
+			if m.AppliedAt != nil {
+				applied = m.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", m.Version, m.Name, applied)
+		}
+		return w.Flush()
+	}
+	return fmt.Errorf("unknown migrate action %q, options: up, down, status", action)
+}
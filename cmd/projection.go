@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/jozuenoon/dunder/projection"
+	"github.com/rs/zerolog"
+)
+
+const (
+	queryStoreCockroach = "cockroach"
+	queryStoreMemory    = "memory"
+	queryStoreRedis     = "redis"
+)
+
+//go:generate gomodifytags -file projection.go -struct QueryConfig -add-tags id -w
+type QueryConfig struct {
+	Store        string    `id:"store" desc:"Store serving searches, options: cockroach, memory, redis" validate:"oneof=cockroach memory redis"`
+	RedisAddr    string    `id:"redis_addr" desc:"Address of Redis compatible server used by redis store"`
+	RedisPrefix  string    `id:"redis_prefix" desc:"Prefix of keys used by redis store"`
+	PollInterval *Duration `id:"poll_interval" desc:"Delay between checks for new events to project"`
+	BatchSize    uint      `id:"batch_size" desc:"Number of events projected at once" validate:"min=1"`
+}
+
+// newQueryStore creates projection store selected in config, returned function releases its connections.
+func newQueryStore(cfg *QueryConfig) (projection.Store, func() error, error) {
+	switch cfg.Store {
+	case queryStoreMemory:
+		return projection.NewMemory(), func() error { return nil }, nil
+	case queryStoreRedis:
+		client := redis.NewClient(&redis.Options{Addr: cfg.RedisAddr})
+		if err := client.Ping(context.Background()).Err(); err != nil {
+			client.Close()
+			return nil, nil, err
+		}
+		return projection.NewRedis(client, cfg.RedisPrefix), client.Close, nil
+	}
+	return nil, nil, fmt.Errorf("store %q is not a projection", cfg.Store)
+}
+
+func newProjector(events projection.EventLog, store projection.Store, cfg *QueryConfig, log *zerolog.Logger) *projection.Projector {
+	return projection.NewProjector(events, store, &projection.Config{
+		PollInterval: cfg.PollInterval.Value(),
+		BatchSize:    cfg.BatchSize,
+	}, log)
+}
+
+// rebuildProjection drops persistent read model and projects whole event log again.
+func rebuildProjection(events projection.EventLog, cfg *QueryConfig, log *zerolog.Logger) error {
+	if cfg.Store != queryStoreRedis {
+		return fmt.Errorf("%s query store is not persistent, nothing to rebuild", cfg.Store)
+	}
+	store, closeStore, err := newQueryStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	ctx := context.Background()
+	start := time.Now()
+	if err := newProjector(events, store, cfg, log).Rebuild(ctx); err != nil {
+		return err
+	}
+	position, err := store.Position(ctx)
+	if err != nil {
+		return err
+	}
+	log.Info().Str("position", position).Dur("took", time.Since(start)).Msg("projection rebuilt")
+	return nil
+}
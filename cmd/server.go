@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/jozuenoon/dunder/service"
+	"github.com/rs/zerolog"
+)
+
+//go:generate gomodifytags -file server.go -struct ServerConfig -add-tags id -w
+type ServerConfig struct {
+	ReadTimeout     *Duration `id:"read_timeout" desc:"Maximum duration for reading entire request"`
+	WriteTimeout    *Duration `id:"write_timeout" desc:"Maximum duration before timing out writes of response"`
+	IdleTimeout     *Duration `id:"idle_timeout" desc:"Maximum amount of time to wait for next request on keep-alive connection"`
+	ShutdownTimeout *Duration `id:"shutdown_timeout" desc:"Maximum duration for draining in-flight requests on shutdown"`
+	HookTimeout     *Duration `id:"hook_timeout" desc:"Maximum duration for each background worker to stop on shutdown"`
+}
+
+// Duration allows passing time.Duration config values in `1m30s` format.
+type Duration time.Duration
+
+func newDuration(d time.Duration) *Duration {
+	v := Duration(d)
+	return &v
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Value returns duration or zero if not set.
+func (d *Duration) Value() time.Duration {
+	if d == nil {
+		return 0
+	}
+	return time.Duration(*d)
+}
+
+// shutdownHook is run on server shutdown, eg. to flush background worker or close repository.
+type shutdownHook struct {
+	name string
+	fn   func(context.Context) error
+}
+
+// server manages http server lifecycle, from serving to draining requests and
+// releasing resources on termination signal.
+type server struct {
+	http        *http.Server
+	tls         *TlsConfig
+	health      *service.HealthImpl
+	timeout     time.Duration
+	hookTimeout time.Duration
+	hooks       []shutdownHook
+	log         *zerolog.Logger
+	signals     chan os.Signal
+	serveErr    chan error
+}
+
+func newServer(addr string, handler http.Handler, cfg *ServerConfig, tls *TlsConfig, health *service.HealthImpl, log *zerolog.Logger) *server {
+	return &server{
+		http: &http.Server{
+			Addr:         addr,
+			Handler:      handler,
+			ReadTimeout:  cfg.ReadTimeout.Value(),
+			WriteTimeout: cfg.WriteTimeout.Value(),
+			IdleTimeout:  cfg.IdleTimeout.Value(),
+		},
+		tls:         tls,
+		health:      health,
+		timeout:     cfg.ShutdownTimeout.Value(),
+		hookTimeout: cfg.HookTimeout.Value(),
+		log:         log,
+		signals:     make(chan os.Signal, 1),
+		serveErr:    make(chan error, 1),
+	}
+}
+
+// onShutdown registers hook run after server stopped accepting requests.
+// Hooks are executed in reverse registration order, each with own deadline so
+// slow draining of requests doesn't leave them expired context.
+func (s *server) onShutdown(name string, fn func(context.Context) error) {
+	s.hooks = append(s.hooks, shutdownHook{name: name, fn: fn})
+}
+
+// run serves requests until termination signal or server failure and then
+// gracefully shuts down.
+func (s *server) run() error {
+	if s.tls != nil && (s.tls.CertFile == "" || s.tls.KeyFile == "") {
+		return errors.New("TLS enabled but certificate or key file not provided, set tls.crt and tls.key")
+	}
+
+	signal.Notify(s.signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(s.signals)
+
+	go func() {
+		s.log.Info().Str("addr", s.http.Addr).Bool("tls", s.tls != nil).Msg("server listening")
+		var err error
+		if s.tls != nil {
+			err = s.http.ListenAndServeTLS(s.tls.CertFile, s.tls.KeyFile)
+		} else {
+			err = s.http.ListenAndServe()
+		}
+		s.serveErr <- err
+	}()
+	s.health.SetState(service.StateServing)
+
+	var serveErr error
+	select {
+	case sig := <-s.signals:
+		s.log.Info().Str("signal", sig.String()).Msg("shutting down")
+	case err := <-s.serveErr:
+		if err != http.ErrServerClosed {
+			serveErr = fmt.Errorf("server failed: %v", err)
+		}
+	}
+
+	return s.shutdown(serveErr)
+}
+
+func (s *server) shutdown(serveErr error) error {
+	s.health.SetState(service.StateStopping)
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	if err := s.http.Shutdown(ctx); err != nil {
+		s.log.Error().Err(err).Msg("failed to drain in-flight requests")
+	}
+
+	for i := len(s.hooks) - 1; i >= 0; i-- {
+		s.runHook(s.hooks[i])
+	}
+	s.log.Info().Msg("server stopped")
+	return serveErr
+}
+
+func (s *server) runHook(hook shutdownHook) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.hookTimeout)
+	defer cancel()
+	if err := hook.fn(ctx); err != nil {
+		s.log.Error().Err(err).Str("hook", hook.name).Msg("shutdown hook failed")
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/jozuenoon/dunder/archive"
+	"github.com/jozuenoon/dunder/repository"
+	"github.com/jozuenoon/dunder/repository/cockroach"
+	"github.com/jozuenoon/dunder/snapshot"
+	"github.com/rs/zerolog"
+)
+
+const (
+	commandExport = "export"
+	commandImport = "import"
+)
+
+//go:generate gomodifytags -file snapshot.go -struct SnapshotConfig -add-tags id -w
+type SnapshotConfig struct {
+	BatchSize       uint `id:"batch_size" desc:"Number of rows exported or imported at once" validate:"min=1"`
+	RecomputeTrends bool `id:"recompute_trends" desc:"Import counts trends from messages instead of restoring archived trends"`
+}
+
+// snapshotSource reads messages through archive tier and other rows from database.
+type snapshotSource struct {
+	repository.QueryStore
+	repository.SnapshotStore
+}
+
+// exportSource exports archived messages together with live ones when archive is configured.
+func exportSource(repoSvc *cockroach.ServiceImpl, cfg *ArchiveConfig) snapshot.Source {
+	if cfg.Dir == "" {
+		return repoSvc
+	}
+	return &snapshotSource{
+		QueryStore:    archive.NewTiered(repoSvc, archive.New(cfg.Dir), cfg.MaxAge.Value()),
+		SnapshotStore: repoSvc,
+	}
+}
+
+// runExport executes `dunder export <file>`.
+func runExport(src snapshot.Source, path string, cfg *SnapshotConfig, log *zerolog.Logger) (err error) {
+	if path == "" {
+		return fmt.Errorf("missing archive path, usage: dunder export <file>")
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(path)
+		}
+	}()
+	counts, err := snapshot.Export(context.Background(), src, f, &snapshot.Config{BatchSize: cfg.BatchSize})
+	if err != nil {
+		return err
+	}
+	logCounts(log.Info(), counts).Str("file", path).Msg("exported")
+	return nil
+}
+
+// runImport executes `dunder import <file>`.
+func runImport(dst repository.Service, path string, cfg *SnapshotConfig, log *zerolog.Logger) error {
+	if path == "" {
+		return fmt.Errorf("missing archive path, usage: dunder import <file>")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	counts, err := snapshot.Import(context.Background(), dst, f, &snapshot.Config{
+		BatchSize:       cfg.BatchSize,
+		RecomputeTrends: cfg.RecomputeTrends,
+	})
+	if err != nil {
+		return err
+	}
+	logCounts(log.Info(), counts).Str("file", path).Msg("imported")
+	return nil
+}
+
+func logCounts(e *zerolog.Event, counts *snapshot.Counts) *zerolog.Event {
+	return e.Uint("users", counts.Users).
+		Uint("hashtags", counts.Hashtags).
+		Uint("messages", counts.Messages).
+		Uint("message_hashtags", counts.MessageHashtags).
+		Uint("trends", counts.Trends)
+}
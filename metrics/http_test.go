@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware(t *testing.T) {
+	r := mux.NewRouter()
+	r.Use(Middleware)
+	r.HandleFunc("/message/{ulid}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.WriteHeader(http.StatusOK)
+	}).Methods(http.MethodGet)
+	r.HandleFunc("/trend", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("{}"))
+	}).Methods(http.MethodGet)
+
+	notFound := httpRequests.WithLabelValues("/message/{ulid}", http.MethodGet, "404")
+	ok := httpRequests.WithLabelValues("/trend", http.MethodGet, "200")
+	beforeNotFound, beforeOK := testutil.ToFloat64(notFound), testutil.ToFloat64(ok)
+	beforeLatency := sampleCount(t, "/message/{ulid}")
+
+	for _, path := range []string{"/message/01DNQ8Y8BJM3XWK9FWX9A7R3VE", "/message/01DNQ8Y8BJM3XWK9FWX9A7R3VF", "/trend"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	// Route label is path template and status is the first one written.
+	assert.Equal(t, beforeNotFound+2, testutil.ToFloat64(notFound))
+	assert.Equal(t, beforeOK+1, testutil.ToFloat64(ok))
+	assert.Equal(t, beforeLatency+2, sampleCount(t, "/message/{ulid}"))
+}
+
+func TestMiddleware_Flush(t *testing.T) {
+	rec := httptest.NewRecorder()
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, ok := w.(http.Flusher)
+		require.True(t, ok, "writer should implement http.Flusher")
+		_, _ = w.Write([]byte("chunk"))
+		f.Flush()
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		require.True(t, ok, "writer should be unwrappable")
+		assert.Equal(t, rec, u.Unwrap())
+	}))
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/message", nil))
+	assert.True(t, rec.Flushed)
+	assert.Equal(t, "chunk", rec.Body.String())
+}
+
+func sampleCount(t *testing.T, route string) uint64 {
+	var m dto.Metric
+	require.NoError(t, httpDuration.WithLabelValues(route, http.MethodGet).(prometheus.Histogram).Write(&m))
+	return m.GetHistogram().GetSampleCount()
+}
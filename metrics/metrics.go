@@ -0,0 +1,130 @@
+// Package metrics exposes Prometheus instrumentation for Dunder, covering
+// the HTTP router, repository calls, database pool and business counters.
+package metrics
+
+import (
+	"database/sql"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "dunder"
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "Number of HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "HTTP request latencies by route and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	repositoryCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "repository",
+		Name:      "calls_total",
+		Help:      "Number of repository calls by method and result.",
+	}, []string{"method", "result"})
+
+	repositoryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "repository",
+		Name:      "call_duration_seconds",
+		Help:      "Repository call latencies by method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+
+	messagesCreated = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_created_total",
+		Help:      "Number of messages created.",
+	})
+
+	hashtagsCreated = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "hashtags_created_total",
+		Help:      "Number of new hashtags created.",
+	})
+
+	messagesArchived = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_archived_total",
+		Help:      "Number of messages moved to archive.",
+	})
+
+	txRetries = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "repository",
+		Name:      "tx_retries_total",
+		Help:      "Number of database transactions retried after serialization error.",
+	})
+
+	readFallbacks = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "repository",
+		Name:      "read_fallbacks_total",
+		Help:      "Number of times unreachable read replica was replaced by primary.",
+	})
+
+	cacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "cache",
+		Name:      "lookups_total",
+		Help:      "Number of cache lookups by cache and result.",
+	}, []string{"cache", "result"})
+
+	outboxEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "outbox",
+		Name:      "events_published_total",
+		Help:      "Number of outbox events published to sinks by event type.",
+	}, []string{"type"})
+)
+
+// RegisterDB exposes connection pool statistics of given database.
+func RegisterDB(name string, db *sql.DB) error {
+	return prometheus.Register(collectors.NewDBStatsCollector(db, name))
+}
+
+// HashtagsCreated records number of newly created hashtags.
+func HashtagsCreated(n int) {
+	hashtagsCreated.Add(float64(n))
+}
+
+// MessagesArchived records number of messages moved to archive.
+func MessagesArchived(n int) {
+	messagesArchived.Add(float64(n))
+}
+
+// EventPublished records outbox event delivered to all sinks.
+func EventPublished(eventType string) {
+	outboxEvents.WithLabelValues(eventType).Inc()
+}
+
+// TxRetried records database transaction restarted after serialization error.
+func TxRetried() {
+	txRetries.Inc()
+}
+
+// ReadFallback records reads moved from unreachable replica to primary.
+func ReadFallback() {
+	readFallbacks.Inc()
+}
+
+// CacheLookup records hit or miss of named cache.
+func CacheLookup(cache string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	cacheLookups.WithLabelValues(cache, result).Inc()
+}
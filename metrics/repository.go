@@ -0,0 +1,102 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/jozuenoon/dunder/repository"
+)
+
+var _ repository.Service = (*Repository)(nil)
+
+// NewRepository wraps repository service with call latency and result metrics.
+func NewRepository(repo repository.Service) *Repository {
+	return &Repository{repo: repo}
+}
+
+type Repository struct {
+	repo repository.Service
+}
+
+func (r *Repository) Message(ctx context.Context, ulid string) (*repository.Message, error) {
+	defer observe("Message", time.Now())
+	msg, err := r.repo.Message(ctx, ulid)
+	record("Message", err)
+	return msg, err
+}
+
+func (r *Repository) CreateMessage(ctx context.Context, message *repository.CreateMessageRequest) (string, error) {
+	defer observe("CreateMessage", time.Now())
+	id, err := r.repo.CreateMessage(ctx, message)
+	record("CreateMessage", err)
+	if err == nil {
+		messagesCreated.Inc()
+	}
+	return id, err
+}
+
+func (r *Repository) CreateMessages(ctx context.Context, messages []*repository.CreateMessageRequest) ([]*repository.CreateMessageResult, error) {
+	defer observe("CreateMessages", time.Now())
+	results, err := r.repo.CreateMessages(ctx, messages)
+	record("CreateMessages", err)
+	for _, res := range results {
+		if !res.Replayed {
+			messagesCreated.Inc()
+		}
+	}
+	return results, err
+}
+
+func (r *Repository) IdempotentMessage(ctx context.Context, userName, key string) (string, error) {
+	defer observe("IdempotentMessage", time.Now())
+	id, err := r.repo.IdempotentMessage(ctx, userName, key)
+	record("IdempotentMessage", err)
+	return id, err
+}
+
+func (r *Repository) EditMessage(ctx context.Context, req *repository.EditMessageRequest) error {
+	defer observe("EditMessage", time.Now())
+	err := r.repo.EditMessage(ctx, req)
+	record("EditMessage", err)
+	return err
+}
+
+func (r *Repository) DeleteMessage(ctx context.Context, userName, ulid string) error {
+	defer observe("DeleteMessage", time.Now())
+	err := r.repo.DeleteMessage(ctx, userName, ulid)
+	record("DeleteMessage", err)
+	return err
+}
+
+func (r *Repository) Messages(ctx context.Context, filter repository.Filter) ([]*repository.Message, error) {
+	defer observe("Messages", time.Now())
+	msgs, err := r.repo.Messages(ctx, filter)
+	record("Messages", err)
+	return msgs, err
+}
+
+func (r *Repository) Trends(ctx context.Context, filter repository.Filter) (*repository.MessagesAggregate, error) {
+	defer observe("Trends", time.Now())
+	trends, err := r.repo.Trends(ctx, filter)
+	record("Trends", err)
+	return trends, err
+}
+
+func (r *Repository) HealthCheck(ctx context.Context) (*repository.HealthStatus, error) {
+	defer observe("HealthCheck", time.Now())
+	status, err := r.repo.HealthCheck(ctx)
+	record("HealthCheck", err)
+	return status, err
+}
+
+func observe(method string, start time.Time) {
+	repositoryDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+}
+
+func record(method string, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	repositoryCalls.WithLabelValues(method, result).Inc()
+}
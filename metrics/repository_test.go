@@ -0,0 +1,52 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jozuenoon/dunder/repository"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeRepository struct {
+	repository.Service
+	err error
+}
+
+func (r *fakeRepository) CreateMessage(ctx context.Context, message *repository.CreateMessageRequest) (string, error) {
+	return "01DNQ8Y8BJM3XWK9FWX9A7R3VE", r.err
+}
+
+func (r *fakeRepository) CreateMessages(ctx context.Context, messages []*repository.CreateMessageRequest) ([]*repository.CreateMessageResult, error) {
+	return []*repository.CreateMessageResult{{Ulid: "01DNQ8Y8BJM3XWK9FWX9A7R3VE"}, {Ulid: "01DNQ8Y8BJM3XWK9FWX9A7R3VE", Replayed: true}}, r.err
+}
+
+func TestRepository(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeRepository{}
+	repo := NewRepository(fake)
+
+	ok := repositoryCalls.WithLabelValues("CreateMessage", "ok")
+	failed := repositoryCalls.WithLabelValues("CreateMessage", "error")
+	beforeOK, beforeFailed := testutil.ToFloat64(ok), testutil.ToFloat64(failed)
+	beforeCreated := testutil.ToFloat64(messagesCreated)
+
+	_, err := repo.CreateMessage(ctx, &repository.CreateMessageRequest{})
+	require.NoError(t, err)
+	fake.err = errors.New("connection refused")
+	_, err = repo.CreateMessage(ctx, &repository.CreateMessageRequest{})
+	assert.Equal(t, fake.err, err)
+
+	assert.Equal(t, beforeOK+1, testutil.ToFloat64(ok))
+	assert.Equal(t, beforeFailed+1, testutil.ToFloat64(failed))
+	assert.Equal(t, beforeCreated+1, testutil.ToFloat64(messagesCreated), "failed calls should not count messages")
+
+	// Replayed messages of batch are not created again.
+	fake.err = nil
+	_, err = repo.CreateMessages(ctx, []*repository.CreateMessageRequest{{}, {}})
+	require.NoError(t, err)
+	assert.Equal(t, beforeCreated+2, testutil.ToFloat64(messagesCreated))
+}
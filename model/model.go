@@ -18,11 +18,48 @@ type User struct {
 type CreateMessageRequest struct {
 	Text     string   `json:"text,omitempty"`
 	Hashtags []string `json:"hashtags,omitempty"`
+	// IdempotencyKey is passed in Idempotency-Key header, retries with the same key return original message.
+	IdempotencyKey string `json:"-"`
+}
+
+//go:generate gomodifytags -file model.go -struct EditMessageRequest -add-tags json -add-options json=omitempty -w
+type EditMessageRequest struct {
+	// Text replaces text of message, hashtags are kept.
+	Text string `json:"text,omitempty"`
 }
 
 //go:generate gomodifytags -file model.go -struct CreateMessageResponse -add-tags json -add-options json=omitempty -w
 type CreateMessageResponse struct {
 	ID string `json:"id,omitempty"`
+	// Replayed is set when message was created earlier by request with the same idempotency key.
+	Replayed bool `json:"-"`
+}
+
+//go:generate gomodifytags -file model.go -struct BatchMessage -add-tags json -add-options json=omitempty -w
+type BatchMessage struct {
+	Text     string   `json:"text,omitempty"`
+	Hashtags []string `json:"hashtags,omitempty"`
+	// IdempotencyKey makes message safe to send again in next batch.
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	// CreatedAt backfills message with its original time, only importers may set it.
+	CreatedAt time.Time `json:"created_at,omitempty"`
+}
+
+//go:generate gomodifytags -file model.go -struct BatchMessageResult -add-tags json -add-options json=omitempty -w
+type BatchMessageResult struct {
+	// Either ID or Error is set.
+	ID string `json:"id,omitempty"`
+	// Replayed is set when message was created earlier with the same idempotency key.
+	Replayed bool   `json:"replayed,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+//go:generate gomodifytags -file model.go -struct CreateMessagesResponse -add-tags json -add-options json=omitempty -w
+type CreateMessagesResponse struct {
+	// Results are in order of messages in request.
+	Results []*BatchMessageResult `json:"results,omitempty"`
+	Created int                   `json:"created,omitempty"`
+	Failed  int                   `json:"failed,omitempty"`
 }
 
 //go:generate gomodifytags -file model.go -struct GetMessageRequest -add-tags json -add-options json=omitempty -w
@@ -51,8 +88,17 @@ type QueryRequest struct {
 	Limit    []uint      `json:"limit,omitempty"`
 	Cursor   []string    `json:"cursor,omitempty"`
 	Rules    QueryRules  `json:"rules,omitempty"`
+	// Consistency is ConsistencyStrong or ConsistencyStale, empty uses server default.
+	Consistency []string `json:"consistency,omitempty"`
 }
 
+const (
+	// ConsistencyStrong reads latest committed data.
+	ConsistencyStrong = "strong"
+	// ConsistencyStale reads few seconds old data, which may be served by nearest replica.
+	ConsistencyStale = "stale"
+)
+
 //go:generate gomodifytags -file model.go -struct QueryRules -add-tags json -add-options json=omitempty -w
 type QueryRules struct {
 	UserName    []string        `json:"user_name,omitempty"`
@@ -73,3 +119,69 @@ type Trend struct {
 	ToDate   time.Time `json:"to_date,omitempty"`
 	Count    uint      `json:"count,omitempty"`
 }
+
+//go:generate gomodifytags -file model.go -struct HealthResponse -add-tags json -add-options json=omitempty -w
+type HealthResponse struct {
+	Status    string `json:"status,omitempty"`
+	Database  string `json:"database,omitempty"`
+	Migration string `json:"migration,omitempty"`
+}
+
+const (
+	EventMessageCreated = "message.created"
+	EventMessageEdited  = "message.edited"
+	EventMessageDeleted = "message.deleted"
+	// EventMessageArchived means message was moved out of live database, it's
+	// still readable from archive.
+	EventMessageArchived = "message.archived"
+	// EventMessageRestored means message was imported from snapshot, it's
+	// projected as created one but subscribers aren't notified.
+	EventMessageRestored = "message.restored"
+	EventUserCreated     = "user.created"
+	EventHashtagCreated  = "hashtag.created"
+)
+
+//go:generate gomodifytags -file model.go -struct Event -add-tags json -add-options json=omitempty -w
+type Event struct {
+	ID        string    `json:"id,omitempty"`
+	Type      string    `json:"type,omitempty"`
+	Message   *Message  `json:"message,omitempty"`
+	User      *User     `json:"user,omitempty"`
+	Hashtag   string    `json:"hashtag,omitempty"`
+	CreatedAt time.Time `json:"created_at,omitempty"`
+}
+
+//go:generate gomodifytags -file model.go -struct CreateWebhookRequest -add-tags json -add-options json=omitempty -w
+type CreateWebhookRequest struct {
+	URL      string `json:"url,omitempty"`
+	Hashtag  string `json:"hashtag,omitempty"`
+	UserName string `json:"user_name,omitempty"`
+	Secret   string `json:"secret,omitempty"`
+}
+
+//go:generate gomodifytags -file model.go -struct Webhook -add-tags json -add-options json=omitempty -w
+type Webhook struct {
+	ID        string    `json:"id,omitempty"`
+	URL       string    `json:"url,omitempty"`
+	Hashtag   string    `json:"hashtag,omitempty"`
+	UserName  string    `json:"user_name,omitempty"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at,omitempty"`
+}
+
+//go:generate gomodifytags -file model.go -struct WebhookDelivery -add-tags json -add-options json=omitempty -w
+type WebhookDelivery struct {
+	EventID    string    `json:"event_id,omitempty"`
+	EventType  string    `json:"event_type,omitempty"`
+	Attempt    int       `json:"attempt,omitempty"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Dead       bool      `json:"dead,omitempty"`
+	CreatedAt  time.Time `json:"created_at,omitempty"`
+}
+
+//go:generate gomodifytags -file model.go -struct WebhooksResponse -add-tags json -add-options json=omitempty -w
+type WebhooksResponse struct {
+	Webhooks   []*Webhook         `json:"webhooks,omitempty"`
+	Deliveries []*WebhookDelivery `json:"deliveries,omitempty"`
+}
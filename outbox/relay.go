@@ -0,0 +1,132 @@
+// Package outbox relays events recorded in outbox table to sinks such as webhooks.
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jozuenoon/dunder/metrics"
+	"github.com/jozuenoon/dunder/model"
+	"github.com/jozuenoon/dunder/repository"
+	"github.com/rs/zerolog"
+)
+
+// Sink receives published events. Events are delivered at least once and in
+// order of event log, sinks should deduplicate by event ID.
+type Sink interface {
+	Publish(context.Context, *model.Event) error
+}
+
+// SinkFunc adapts function to Sink.
+type SinkFunc func(context.Context, *model.Event) error
+
+func (f SinkFunc) Publish(ctx context.Context, event *model.Event) error {
+	return f(ctx, event)
+}
+
+type Config struct {
+	// PollInterval is delay between checks for new events when outbox is drained.
+	PollInterval time.Duration
+	BatchSize    uint
+}
+
+func NewRelay(store repository.OutboxStore, sinks []Sink, cfg *Config, log *zerolog.Logger) *Relay {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &Relay{
+		store:  store,
+		sinks:  sinks,
+		cfg:    *cfg,
+		log:    log,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go r.run(ctx)
+	return r
+}
+
+// Relay polls outbox for pending events and publishes them to all sinks. Event
+// is marked published only when every sink accepted it, failed event is retried
+// on next poll and blocks events behind it to preserve order.
+type Relay struct {
+	store repository.OutboxStore
+	sinks []Sink
+	cfg   Config
+	log   *zerolog.Logger
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Close stops relay and waits for batch in progress. Unpublished events stay in
+// outbox and are relayed after restart.
+func (r *Relay) Close(ctx context.Context) error {
+	r.cancel()
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *Relay) run(ctx context.Context) {
+	defer close(r.done)
+	ticker := time.NewTicker(r.cfg.PollInterval)
+	defer ticker.Stop()
+	for {
+		for {
+			n, err := r.relay(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					r.log.Error().Err(err).Msg("outbox: relay failed")
+				}
+				break
+			}
+			if n < int(r.cfg.BatchSize) {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// relay publishes single batch of pending events and returns number of published ones.
+func (r *Relay) relay(ctx context.Context) (int, error) {
+	events, err := r.store.PendingEvents(ctx, r.cfg.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	var published []uint
+	for _, e := range events {
+		if err = r.publish(ctx, e); err != nil {
+			break
+		}
+		published = append(published, e.ID)
+	}
+	// Progress is saved even when relay is stopping, so published events aren't repeated.
+	if markErr := r.store.MarkPublished(context.Background(), published); markErr != nil {
+		return 0, markErr
+	}
+	return len(published), err
+}
+
+func (r *Relay) publish(ctx context.Context, e *repository.OutboxEvent) error {
+	var event model.Event
+	if err := json.Unmarshal([]byte(e.Payload), &event); err != nil {
+		// Malformed payload would block outbox forever, so it's skipped.
+		r.log.Error().Err(err).Uint("id", e.ID).Msg("outbox: dropping malformed event")
+		return nil
+	}
+	for _, sink := range r.sinks {
+		if err := sink.Publish(ctx, &event); err != nil {
+			return err
+		}
+	}
+	metrics.EventPublished(event.Type)
+	return nil
+}
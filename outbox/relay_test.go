@@ -0,0 +1,133 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jozuenoon/dunder/model"
+	"github.com/jozuenoon/dunder/repository"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memoryOutbox struct {
+	repository.OutboxStore
+
+	mu     sync.Mutex
+	events []*repository.OutboxEvent
+}
+
+func (m *memoryOutbox) add(t *testing.T, id uint, eventType string) {
+	payload, err := json.Marshal(&model.Event{ID: string(rune('A' + id)), Type: eventType})
+	require.NoError(t, err)
+	m.events = append(m.events, &repository.OutboxEvent{ID: id, Type: eventType, Payload: string(payload)})
+}
+
+func (m *memoryOutbox) PendingEvents(ctx context.Context, limit uint) ([]*repository.OutboxEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var pending []*repository.OutboxEvent
+	for _, e := range m.events {
+		if e.PublishedAt == nil && uint(len(pending)) < limit {
+			pending = append(pending, e)
+		}
+	}
+	return pending, nil
+}
+
+func (m *memoryOutbox) MarkPublished(ctx context.Context, ids []uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for _, id := range ids {
+		for _, e := range m.events {
+			if e.ID == id {
+				e.PublishedAt = &now
+			}
+		}
+	}
+	return nil
+}
+
+func (m *memoryOutbox) pending() int {
+	events, _ := m.PendingEvents(context.Background(), 100)
+	return len(events)
+}
+
+type recordingSink struct {
+	mu      sync.Mutex
+	events  []string
+	failing bool
+}
+
+func (s *recordingSink) Publish(ctx context.Context, event *model.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failing && event.Type == model.EventMessageCreated {
+		return errors.New("sink unavailable")
+	}
+	s.events = append(s.events, event.ID)
+	return nil
+}
+
+func (s *recordingSink) published() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.events...)
+}
+
+func newTestRelay(store repository.OutboxStore, sinks ...Sink) *Relay {
+	log := zerolog.Nop()
+	return NewRelay(store, sinks, &Config{PollInterval: time.Millisecond, BatchSize: 2}, &log)
+}
+
+func TestRelay_PublishesInOrder(t *testing.T) {
+	store := &memoryOutbox{}
+	store.add(t, 1, model.EventUserCreated)
+	store.add(t, 2, model.EventHashtagCreated)
+	store.add(t, 3, model.EventMessageCreated)
+	first, second := &recordingSink{}, &recordingSink{}
+
+	r := newTestRelay(store, first, second)
+	assert.Eventually(t, func() bool { return store.pending() == 0 }, time.Second, time.Millisecond)
+	require.NoError(t, r.Close(context.Background()))
+
+	assert.Equal(t, []string{"B", "C", "D"}, first.published())
+	assert.Equal(t, []string{"B", "C", "D"}, second.published())
+}
+
+func TestRelay_RetriesFailedEvent(t *testing.T) {
+	store := &memoryOutbox{}
+	store.add(t, 1, model.EventUserCreated)
+	store.add(t, 2, model.EventMessageCreated)
+	store.add(t, 3, model.EventUserCreated)
+	sink := &recordingSink{failing: true}
+
+	r := newTestRelay(store, sink)
+	assert.Eventually(t, func() bool { return store.pending() == 2 }, time.Second, time.Millisecond)
+	// Events behind failed one wait to keep order.
+	assert.Equal(t, []string{"B"}, sink.published())
+
+	sink.mu.Lock()
+	sink.failing = false
+	sink.mu.Unlock()
+	assert.Eventually(t, func() bool { return store.pending() == 0 }, time.Second, time.Millisecond)
+	require.NoError(t, r.Close(context.Background()))
+	assert.Equal(t, []string{"B", "C", "D"}, sink.published())
+}
+
+func TestRelay_SkipsMalformedEvent(t *testing.T) {
+	store := &memoryOutbox{events: []*repository.OutboxEvent{{ID: 1, Payload: "{"}}}
+	store.add(t, 2, model.EventUserCreated)
+	sink := &recordingSink{}
+
+	r := newTestRelay(store, sink)
+	assert.Eventually(t, func() bool { return store.pending() == 0 }, time.Second, time.Millisecond)
+	require.NoError(t, r.Close(context.Background()))
+	assert.Equal(t, []string{"C"}, sink.published())
+}
@@ -0,0 +1,218 @@
+package projection
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/jinzhu/gorm"
+	"github.com/jozuenoon/dunder/model"
+	"github.com/jozuenoon/dunder/repository"
+)
+
+var _ Store = (*Memory)(nil)
+
+func NewMemory() *Memory {
+	m := &Memory{}
+	m.reset()
+	return m
+}
+
+// Memory keeps read model in process memory, it has to be rebuilt on every start.
+type Memory struct {
+	mu       sync.RWMutex
+	position string
+	// applied holds ascending IDs of events applied within lagWindow behind position.
+	applied  []string
+	messages map[string]*repository.Message
+	// Indexes hold message ulids in ascending order.
+	all       []string
+	byUser    map[string][]string
+	byHashtag map[string][]string
+	// trends counts messages per minute bucket by hashtag, empty hashtag sums all.
+	trends map[string]map[int64]uint
+}
+
+func (m *Memory) reset() {
+	m.position = ""
+	m.applied = nil
+	m.messages = make(map[string]*repository.Message)
+	m.all = nil
+	m.byUser = make(map[string][]string)
+	m.byHashtag = make(map[string][]string)
+	m.trends = make(map[string]map[int64]uint)
+}
+
+func (m *Memory) Reset(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reset()
+	return nil
+}
+
+func (m *Memory) Position(ctx context.Context) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.position, nil
+}
+
+func (m *Memory) Apply(ctx context.Context, event *model.Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if event.ID < horizon(m.position) || containsSorted(m.applied, event.ID) {
+		return nil
+	}
+	if event.ID > m.position {
+		m.position = event.ID
+	}
+	m.applied = insertSorted(m.applied, event.ID)
+	m.applied = m.applied[sort.SearchStrings(m.applied, horizon(m.position)):]
+	if event.Message == nil {
+		return nil
+	}
+	switch event.Type {
+	case model.EventMessageCreated, model.EventMessageRestored:
+		m.create(messageFromEvent(event.Message))
+	case model.EventMessageEdited:
+		// Cached messages are shared with readers, so edit replaces them.
+		if old, ok := m.messages[event.Message.ID]; ok {
+			msg := *old
+			msg.Text = event.Message.Text
+			m.messages[event.Message.ID] = &msg
+		}
+	case model.EventMessageDeleted:
+		m.delete(event.Message.ID)
+	}
+	return nil
+}
+
+func (m *Memory) create(msg *repository.Message) {
+	if _, ok := m.messages[*msg.Ulid]; ok {
+		return
+	}
+	m.messages[*msg.Ulid] = msg
+	m.all = insertSorted(m.all, *msg.Ulid)
+	m.byUser[*msg.User.Name] = insertSorted(m.byUser[*msg.User.Name], *msg.Ulid)
+	bucket := bucketOf(msg.CreatedAt)
+	for _, h := range msg.Hashtags {
+		m.byHashtag[*h.Text] = insertSorted(m.byHashtag[*h.Text], *msg.Ulid)
+		m.count(*h.Text, bucket, 1)
+		m.count("", bucket, 1)
+	}
+}
+
+func (m *Memory) delete(ulid string) {
+	msg, ok := m.messages[ulid]
+	if !ok {
+		return
+	}
+	delete(m.messages, ulid)
+	m.all = removeSorted(m.all, ulid)
+	m.byUser[*msg.User.Name] = removeSorted(m.byUser[*msg.User.Name], ulid)
+	bucket := bucketOf(msg.CreatedAt)
+	for _, h := range msg.Hashtags {
+		m.byHashtag[*h.Text] = removeSorted(m.byHashtag[*h.Text], ulid)
+		m.count(*h.Text, bucket, -1)
+		m.count("", bucket, -1)
+	}
+}
+
+func (m *Memory) count(hashtag string, bucket int64, delta int) {
+	buckets, ok := m.trends[hashtag]
+	if !ok {
+		buckets = make(map[int64]uint)
+		m.trends[hashtag] = buckets
+	}
+	buckets[bucket] = uint(int(buckets[bucket]) + delta)
+	if buckets[bucket] == 0 {
+		delete(buckets, bucket)
+	}
+}
+
+func insertSorted(ids []string, id string) []string {
+	i := sort.SearchStrings(ids, id)
+	if i == len(ids) {
+		return append(ids, id)
+	}
+	ids = append(ids, "")
+	copy(ids[i+1:], ids[i:])
+	ids[i] = id
+	return ids
+}
+
+func containsSorted(ids []string, id string) bool {
+	i := sort.SearchStrings(ids, id)
+	return i < len(ids) && ids[i] == id
+}
+
+func removeSorted(ids []string, id string) []string {
+	i := sort.SearchStrings(ids, id)
+	if i == len(ids) || ids[i] != id {
+		return ids
+	}
+	return append(ids[:i], ids[i+1:]...)
+}
+
+func (m *Memory) Message(ctx context.Context, ulid string) (*repository.Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	msg, ok := m.messages[ulid]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return msg, nil
+}
+
+func (m *Memory) Messages(ctx context.Context, filter repository.Filter) ([]*repository.Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	index := m.all
+	switch {
+	case filter.IsUserQuery():
+		index = m.byUser[filter.GetUserName()]
+	case filter.IsHashtagsQuery():
+		index = m.byHashtag[filter.GetHashtag()]
+	}
+
+	// Walk index from upper bound down, newest messages first.
+	start := len(index)
+	switch {
+	case filter.IsCursorQuery():
+		start = sort.SearchStrings(index, filter.GetCursor())
+	case filter.IsDateRangeQuery():
+		start = sort.SearchStrings(index, timeBound(filter.GetToDate()))
+	}
+
+	var resp []*repository.Message
+	for i := start - 1; i >= 0 && uint(len(resp)) < filter.GetLimit(); i-- {
+		msg := m.messages[index[i]]
+		if !filter.IsCursorQuery() && filter.IsDateRangeQuery() {
+			if msg.CreatedAt.Before(filter.GetFromDate()) {
+				break
+			}
+			if !msg.CreatedAt.After(filter.GetFromDate()) || !msg.CreatedAt.Before(filter.GetToDate()) {
+				continue
+			}
+		}
+		if filter.IsHashtagsQuery() && !hasHashtag(msg, filter.GetHashtag()) {
+			continue
+		}
+		resp = append(resp, msg)
+	}
+	return resp, nil
+}
+
+func (m *Memory) Trends(ctx context.Context, filter repository.Filter) (*repository.MessagesAggregate, error) {
+	if err := validateTrendsFilter(filter); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	hashtag := ""
+	if filter.IsHashtagsQuery() {
+		hashtag = filter.GetHashtag()
+	}
+	return aggregateTrends(filter, m.trends[hashtag]), nil
+}
@@ -0,0 +1,235 @@
+// Package projection maintains denormalized read models of messages and trends
+// built from event log, so searches don't load the source of truth database.
+package projection
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jozuenoon/dunder/model"
+	"github.com/jozuenoon/dunder/repository"
+	"github.com/oklog/ulid"
+	"github.com/rs/zerolog"
+)
+
+// lagWindow is how long after its ID is assigned event may be committed. Event
+// IDs are assigned before commit, so concurrent transactions may commit out of
+// ID order. Projector reads again events within lagWindow behind position and
+// stores remember which of them were applied.
+const lagWindow = time.Minute
+
+// Store is read model serving queries. It remembers position in event log, so
+// projection could be resumed.
+type Store interface {
+	repository.QueryStore
+	// Apply updates read model with event. Applied events within lagWindow
+	// behind position and all events before it are ignored.
+	Apply(ctx context.Context, event *model.Event) error
+	// Position returns ID of newest applied event, empty for fresh store.
+	Position(ctx context.Context) (string, error)
+	// Reset drops whole read model.
+	Reset(ctx context.Context) error
+}
+
+// EventLog is ordered log of all state changes.
+type EventLog interface {
+	Events(ctx context.Context, after string, limit uint) ([]*repository.OutboxEvent, error)
+}
+
+type Config struct {
+	// PollInterval is delay between checks for new events when projection caught up.
+	PollInterval time.Duration
+	BatchSize    uint
+}
+
+func NewProjector(events EventLog, store Store, cfg *Config, log *zerolog.Logger) *Projector {
+	return &Projector{
+		events: events,
+		store:  store,
+		cfg:    *cfg,
+		log:    log,
+		done:   make(chan struct{}),
+	}
+}
+
+// Projector tails event log and applies events to store.
+type Projector struct {
+	events EventLog
+	store  Store
+	cfg    Config
+	log    *zerolog.Logger
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// CatchUp applies all events after store position, including ones committed
+// late within lagWindow behind it.
+func (p *Projector) CatchUp(ctx context.Context) error {
+	position, err := p.store.Position(ctx)
+	if err != nil {
+		return err
+	}
+	position = horizon(position)
+	for {
+		events, err := p.events.Events(ctx, position, p.cfg.BatchSize)
+		if err != nil {
+			return err
+		}
+		for _, e := range events {
+			var event model.Event
+			if err := json.Unmarshal([]byte(e.Payload), &event); err != nil {
+				return fmt.Errorf("event %s: %v", *e.Ulid, err)
+			}
+			if err := p.store.Apply(ctx, &event); err != nil {
+				return err
+			}
+			position = *e.Ulid
+		}
+		if uint(len(events)) < p.cfg.BatchSize {
+			return nil
+		}
+	}
+}
+
+// Rebuild drops read model and projects whole event log from scratch.
+func (p *Projector) Rebuild(ctx context.Context) error {
+	if err := p.store.Reset(ctx); err != nil {
+		return err
+	}
+	return p.CatchUp(ctx)
+}
+
+// Start follows event log in background until Close is called.
+func (p *Projector) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	go p.run(ctx)
+}
+
+// Close stops following event log.
+func (p *Projector) Close(ctx context.Context) error {
+	if p.cancel == nil {
+		return nil
+	}
+	p.cancel()
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *Projector) run(ctx context.Context) {
+	defer close(p.done)
+	ticker := time.NewTicker(p.cfg.PollInterval)
+	defer ticker.Stop()
+	for {
+		if err := p.CatchUp(ctx); err != nil && ctx.Err() == nil {
+			p.log.Error().Err(err).Msg("projection: failed to apply events")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// messageFromEvent converts event payload to repository representation returned by queries.
+func messageFromEvent(m *model.Message) *repository.Message {
+	id, name := m.ID, m.User.Name
+	hashtags := make([]*repository.Hashtag, 0, len(m.Hashtags))
+	for _, h := range m.Hashtags {
+		text := h
+		hashtags = append(hashtags, &repository.Hashtag{Text: &text})
+	}
+	return &repository.Message{
+		CreatedAt: m.CreatedAt,
+		UpdatedAt: m.CreatedAt,
+		Ulid:      &id,
+		User:      repository.User{ID: m.User.ID, Name: &name},
+		UserRef:   m.User.ID,
+		Text:      m.Text,
+		Hashtags:  hashtags,
+	}
+}
+
+func hasHashtag(m *repository.Message, hashtag string) bool {
+	for _, h := range m.Hashtags {
+		if *h.Text == hashtag {
+			return true
+		}
+	}
+	return false
+}
+
+// timeBound returns smallest ulid with given timestamp, all ulids generated
+// earlier sort before it.
+func timeBound(t time.Time) string {
+	var id ulid.ULID
+	_ = id.SetTime(ulid.Timestamp(t))
+	return id.String()
+}
+
+// horizon returns bound of lagWindow behind position, events before it are
+// considered applied.
+func horizon(position string) string {
+	id, err := ulid.Parse(position)
+	if err != nil {
+		return ""
+	}
+	return timeBound(ulid.Time(id.Time()).Add(-lagWindow))
+}
+
+func bucketOf(t time.Time) int64 {
+	return t.Unix() / minute
+}
+
+const minute = 60
+
+// validateTrendsFilter mirrors cockroach store requirements for aggregate queries.
+func validateTrendsFilter(filter repository.Filter) error {
+	if !filter.IsAggregateQuery() {
+		return fmt.Errorf("expected aggregate filter query, possibly missing `aggregate` query option")
+	}
+	if !filter.IsDateRangeQuery() {
+		return fmt.Errorf("aggregated query requires valid date range")
+	}
+	return nil
+}
+
+// aggregateTrends sums minute buckets within filter date range into buckets of
+// aggregation period, the same way cockroach store does.
+func aggregateTrends(filter repository.Filter, buckets map[int64]uint) *repository.MessagesAggregate {
+	bucketSize := int64(filter.GetAggregationPeriod().Seconds()) / minute
+	fromBoundary := filter.GetFromDate().Unix() / minute
+	toBoundary := filter.GetToDate().Unix() / minute
+
+	sums := make(map[int64]uint)
+	for bucket, count := range buckets {
+		if bucket > fromBoundary && bucket < toBoundary {
+			sums[bucket/bucketSize] += count
+		}
+	}
+	keys := make([]int64, 0, len(sums))
+	for k := range sums {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	var trends []*model.Trend
+	for _, k := range keys {
+		fromDate := time.Unix(k*bucketSize*minute, 0)
+		trends = append(trends, &model.Trend{
+			FromDate: fromDate,
+			ToDate:   fromDate.Add(time.Second * time.Duration(bucketSize*minute)),
+			Count:    sums[k],
+		})
+	}
+	return &repository.MessagesAggregate{Trends: trends}
+}
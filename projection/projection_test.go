@@ -0,0 +1,335 @@
+package projection
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/jinzhu/gorm"
+	"github.com/jozuenoon/dunder/model"
+	"github.com/jozuenoon/dunder/repository"
+	"github.com/oklog/ulid"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var base = time.Date(2019, 9, 22, 10, 0, 0, 0, time.UTC)
+
+type memoryLog struct {
+	mu     sync.Mutex
+	events []*repository.OutboxEvent
+}
+
+func (l *memoryLog) add(t *testing.T, event *model.Event) {
+	payload, err := json.Marshal(event)
+	require.NoError(t, err)
+	id := event.ID
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// Log is read in ID order, events committed late land in the middle.
+	i := sort.Search(len(l.events), func(i int) bool { return *l.events[i].Ulid > id })
+	l.events = append(l.events, nil)
+	copy(l.events[i+1:], l.events[i:])
+	l.events[i] = &repository.OutboxEvent{Ulid: &id, Type: event.Type, Payload: string(payload)}
+}
+
+func (l *memoryLog) Events(ctx context.Context, after string, limit uint) ([]*repository.OutboxEvent, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var events []*repository.OutboxEvent
+	for _, e := range l.events {
+		if *e.Ulid > after && uint(len(events)) < limit {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+func newID(t time.Time, n byte) string {
+	var id ulid.ULID
+	_ = id.SetTime(ulid.Timestamp(t))
+	id[15] = n
+	return id.String()
+}
+
+// testLog holds user and hashtag events followed by messages posted one per minute.
+func testLog(t *testing.T) *memoryLog {
+	l := &memoryLog{}
+	l.add(t, &model.Event{ID: newID(base, 0), Type: model.EventUserCreated, User: &model.User{ID: 1, Name: "john"}})
+	msgs := []struct {
+		user     string
+		hashtags []string
+	}{
+		{"john", []string{"go", "release"}},
+		{"ala", []string{"go"}},
+		{"john", []string{"release"}},
+		{"ala", []string{"go", "release"}},
+	}
+	for i, m := range msgs {
+		created := base.Add(time.Duration(i+1) * time.Minute)
+		l.add(t, &model.Event{
+			ID:   newID(created, 1),
+			Type: model.EventMessageCreated,
+			Message: &model.Message{
+				ID:        newID(created, 0),
+				User:      model.User{Name: m.user},
+				Text:      "text",
+				Hashtags:  m.hashtags,
+				CreatedAt: created,
+			},
+		})
+	}
+	return l
+}
+
+func ids(msgs []*repository.Message) []string {
+	var out []string
+	for _, m := range msgs {
+		out = append(out, *m.Ulid)
+	}
+	return out
+}
+
+func filter(req model.QueryRequest) repository.Filter {
+	return &repository.FilterImpl{QueryRequest: req}
+}
+
+func testStore(t *testing.T, store Store) {
+	ctx := context.Background()
+	log := zerolog.Nop()
+	events := testLog(t)
+	p := NewProjector(events, store, &Config{PollInterval: time.Millisecond, BatchSize: 2}, &log)
+	require.NoError(t, p.CatchUp(ctx))
+
+	position, err := store.Position(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, *events.events[4].Ulid, position)
+
+	msg := func(i int) string {
+		return newID(base.Add(time.Duration(i)*time.Minute), 0)
+	}
+
+	t.Run("message", func(t *testing.T) {
+		m, err := store.Message(ctx, msg(2))
+		require.NoError(t, err)
+		assert.Equal(t, "ala", *m.User.Name)
+		_, err = store.Message(ctx, "missing")
+		assert.Equal(t, gorm.ErrRecordNotFound, err)
+	})
+
+	t.Run("latest", func(t *testing.T) {
+		msgs, err := store.Messages(ctx, filter(model.QueryRequest{Limit: []uint{3}}))
+		require.NoError(t, err)
+		assert.Equal(t, []string{msg(4), msg(3), msg(2)}, ids(msgs))
+	})
+
+	t.Run("cursor", func(t *testing.T) {
+		msgs, err := store.Messages(ctx, filter(model.QueryRequest{Cursor: []string{msg(3)}}))
+		require.NoError(t, err)
+		assert.Equal(t, []string{msg(2), msg(1)}, ids(msgs))
+	})
+
+	t.Run("date range", func(t *testing.T) {
+		msgs, err := store.Messages(ctx, filter(model.QueryRequest{
+			FromDate: []time.Time{base.Add(time.Minute)},
+			ToDate:   []time.Time{base.Add(4 * time.Minute)},
+		}))
+		require.NoError(t, err)
+		assert.Equal(t, []string{msg(3), msg(2)}, ids(msgs))
+	})
+
+	t.Run("user and hashtag", func(t *testing.T) {
+		msgs, err := store.Messages(ctx, filter(model.QueryRequest{Rules: model.QueryRules{
+			UserName: []string{"john"},
+			Hashtag:  []string{"go"},
+		}}))
+		require.NoError(t, err)
+		assert.Equal(t, []string{msg(1)}, ids(msgs))
+	})
+
+	t.Run("hashtag page", func(t *testing.T) {
+		msgs, err := store.Messages(ctx, filter(model.QueryRequest{
+			Limit: []uint{1},
+			Rules: model.QueryRules{UserName: []string{"john"}, Hashtag: []string{"go"}},
+		}))
+		require.NoError(t, err)
+		assert.Equal(t, []string{msg(1)}, ids(msgs))
+	})
+
+	t.Run("trends", func(t *testing.T) {
+		resp, err := store.Trends(ctx, filter(model.QueryRequest{
+			FromDate: []time.Time{base},
+			ToDate:   []time.Time{base.Add(10 * time.Minute)},
+			Rules:    model.QueryRules{Aggregation: []time.Duration{2 * time.Minute}},
+		}))
+		require.NoError(t, err)
+		var counts []uint
+		for _, tr := range resp.Trends {
+			counts = append(counts, tr.Count)
+		}
+		// Minutes 1-4 hold 2, 1, 1, 2 hashtags.
+		assert.Equal(t, []uint{2, 2, 2}, counts)
+		assert.Equal(t, base, resp.Trends[0].FromDate.UTC())
+
+		resp, err = store.Trends(ctx, filter(model.QueryRequest{
+			FromDate: []time.Time{base},
+			ToDate:   []time.Time{base.Add(10 * time.Minute)},
+			Rules:    model.QueryRules{Aggregation: []time.Duration{time.Hour}, Hashtag: []string{"release"}},
+		}))
+		require.NoError(t, err)
+		require.Len(t, resp.Trends, 1)
+		assert.Equal(t, uint(3), resp.Trends[0].Count)
+	})
+
+	t.Run("replayed events are ignored", func(t *testing.T) {
+		for _, e := range events.events {
+			var event model.Event
+			require.NoError(t, json.Unmarshal([]byte(e.Payload), &event))
+			require.NoError(t, store.Apply(ctx, &event))
+		}
+		msgs, err := store.Messages(ctx, filter(model.QueryRequest{}))
+		require.NoError(t, err)
+		assert.Len(t, msgs, 4)
+	})
+
+	t.Run("rebuild", func(t *testing.T) {
+		require.NoError(t, p.Rebuild(ctx))
+		msgs, err := store.Messages(ctx, filter(model.QueryRequest{}))
+		require.NoError(t, err)
+		assert.Len(t, msgs, 4)
+	})
+
+	t.Run("edit and delete", func(t *testing.T) {
+		changed := base.Add(10 * time.Minute)
+		events.add(t, &model.Event{ID: newID(changed, 1), Type: model.EventMessageEdited,
+			Message: &model.Message{ID: msg(1), Text: "edited"}})
+		events.add(t, &model.Event{ID: newID(changed, 2), Type: model.EventMessageDeleted,
+			Message: &model.Message{ID: msg(4)}})
+		require.NoError(t, p.CatchUp(ctx))
+
+		m, err := store.Message(ctx, msg(1))
+		require.NoError(t, err)
+		assert.Equal(t, "edited", m.Text)
+		assert.Equal(t, []string{"go", "release"}, []string{*m.Hashtags[0].Text, *m.Hashtags[1].Text})
+		_, err = store.Message(ctx, msg(4))
+		assert.Equal(t, gorm.ErrRecordNotFound, err)
+
+		msgs, err := store.Messages(ctx, filter(model.QueryRequest{}))
+		require.NoError(t, err)
+		assert.Equal(t, []string{msg(3), msg(2), msg(1)}, ids(msgs))
+		msgs, err = store.Messages(ctx, filter(model.QueryRequest{Rules: model.QueryRules{Hashtag: []string{"release"}}}))
+		require.NoError(t, err)
+		assert.Equal(t, []string{msg(3), msg(1)}, ids(msgs))
+
+		resp, err := store.Trends(ctx, filter(model.QueryRequest{
+			FromDate: []time.Time{base},
+			ToDate:   []time.Time{base.Add(10 * time.Minute)},
+			Rules:    model.QueryRules{Aggregation: []time.Duration{time.Minute}, Hashtag: []string{"release"}},
+		}))
+		require.NoError(t, err)
+		var counts []uint
+		for _, tr := range resp.Trends {
+			counts = append(counts, tr.Count)
+		}
+		// Bucket of deleted message at minute 4 is gone.
+		assert.Equal(t, []uint{1, 1}, counts)
+	})
+
+	t.Run("events committed out of order", func(t *testing.T) {
+		created := base.Add(20 * time.Minute)
+		late := func(n byte) *model.Event {
+			return &model.Event{ID: newID(created, n), Type: model.EventMessageCreated, Message: &model.Message{
+				ID:        newID(created, n+10),
+				User:      model.User{Name: "ola"},
+				Hashtags:  []string{"late"},
+				CreatedAt: created,
+			}}
+		}
+		// Event with greater ID commits first, the other one is seen on next poll.
+		events.add(t, late(2))
+		require.NoError(t, p.CatchUp(ctx))
+		events.add(t, late(1))
+		require.NoError(t, p.CatchUp(ctx))
+		require.NoError(t, p.CatchUp(ctx))
+
+		position, err := store.Position(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, newID(created, 2), position)
+		msgs, err := store.Messages(ctx, filter(model.QueryRequest{Rules: model.QueryRules{UserName: []string{"ola"}}}))
+		require.NoError(t, err)
+		assert.Equal(t, []string{newID(created, 12), newID(created, 11)}, ids(msgs))
+
+		// Events read again within lag window are applied once.
+		resp, err := store.Trends(ctx, filter(model.QueryRequest{
+			FromDate: []time.Time{base},
+			ToDate:   []time.Time{created.Add(time.Hour)},
+			Rules:    model.QueryRules{Aggregation: []time.Duration{time.Hour}, Hashtag: []string{"late"}},
+		}))
+		require.NoError(t, err)
+		require.Len(t, resp.Trends, 1)
+		assert.Equal(t, uint(2), resp.Trends[0].Count)
+	})
+
+	t.Run("restored", func(t *testing.T) {
+		restoredAt := base.Add(30 * time.Minute)
+		// Imported message keeps its original time and id.
+		created := base.Add(-24 * time.Hour)
+		events.add(t, &model.Event{ID: newID(restoredAt, 1), Type: model.EventMessageRestored, Message: &model.Message{
+			ID:        newID(created, 1),
+			User:      model.User{Name: "imported"},
+			Hashtags:  []string{"history"},
+			CreatedAt: created,
+		}})
+		require.NoError(t, p.CatchUp(ctx))
+
+		msgs, err := store.Messages(ctx, filter(model.QueryRequest{Rules: model.QueryRules{UserName: []string{"imported"}}}))
+		require.NoError(t, err)
+		assert.Equal(t, []string{newID(created, 1)}, ids(msgs))
+		resp, err := store.Trends(ctx, filter(model.QueryRequest{
+			FromDate: []time.Time{created.Add(-time.Hour)},
+			ToDate:   []time.Time{created.Add(time.Hour)},
+			Rules:    model.QueryRules{Aggregation: []time.Duration{time.Hour}, Hashtag: []string{"history"}},
+		}))
+		require.NoError(t, err)
+		require.Len(t, resp.Trends, 1)
+		assert.Equal(t, uint(1), resp.Trends[0].Count)
+	})
+}
+
+func TestMemory(t *testing.T) {
+	testStore(t, NewMemory())
+}
+
+func TestRedis(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+	client := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+	defer client.Close()
+
+	testStore(t, NewRedis(client, "dunder:"))
+}
+
+func TestProjector_Follows(t *testing.T) {
+	events := &memoryLog{}
+	store := NewMemory()
+	log := zerolog.Nop()
+	p := NewProjector(events, store, &Config{PollInterval: time.Millisecond, BatchSize: 10}, &log)
+	p.Start()
+	defer func() { require.NoError(t, p.Close(context.Background())) }()
+
+	full := testLog(t)
+	events.mu.Lock()
+	events.events = full.events[:3]
+	events.mu.Unlock()
+	assert.Eventually(t, func() bool {
+		position, _ := store.Position(context.Background())
+		return position == *full.events[2].Ulid
+	}, time.Second, time.Millisecond)
+}
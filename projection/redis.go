@@ -0,0 +1,304 @@
+package projection
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/jinzhu/gorm"
+	"github.com/jozuenoon/dunder/model"
+	"github.com/jozuenoon/dunder/repository"
+)
+
+var _ Store = (*Redis)(nil)
+
+// NewRedis creates store keeping read model under given key prefix of Redis
+// compatible server.
+func NewRedis(client *redis.Client, prefix string) *Redis {
+	return &Redis{
+		client: client,
+		prefix: prefix,
+	}
+}
+
+// Redis keeps read model in Redis. Messages are stored as JSON and indexed by
+// sorted sets of ulids with equal scores, so lexicographical ranges follow
+// message order. Applied events within lagWindow are kept the same way. Position
+// is guarded with optimistic transaction, so several projectors could share
+// one store.
+type Redis struct {
+	client *redis.Client
+	prefix string
+}
+
+func (r *Redis) key(parts ...string) string {
+	k := r.prefix
+	for _, p := range parts {
+		k += p
+	}
+	return k
+}
+
+func (r *Redis) Reset(ctx context.Context) error {
+	iter := r.client.Scan(ctx, 0, r.prefix+"*", 1000).Iterator()
+	var keys []string
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+		if len(keys) == 1000 {
+			if err := r.client.Del(ctx, keys...).Err(); err != nil {
+				return err
+			}
+			keys = keys[:0]
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	return r.client.Del(ctx, keys...).Err()
+}
+
+func (r *Redis) Position(ctx context.Context) (string, error) {
+	position, err := r.client.Get(ctx, r.key("position")).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return position, err
+}
+
+func (r *Redis) Apply(ctx context.Context, event *model.Event) error {
+	positionKey, appliedKey := r.key("position"), r.key("applied")
+	for {
+		err := r.client.Watch(ctx, func(tx *redis.Tx) error {
+			position, err := tx.Get(ctx, positionKey).Result()
+			if err != nil && err != redis.Nil {
+				return err
+			}
+			if event.ID < horizon(position) {
+				return nil
+			}
+			err = tx.ZScore(ctx, appliedKey, event.ID).Err()
+			if err == nil {
+				return nil
+			}
+			if err != redis.Nil {
+				return err
+			}
+			if event.ID > position {
+				position = event.ID
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Set(ctx, positionKey, position, 0)
+				pipe.ZAdd(ctx, appliedKey, &redis.Z{Member: event.ID})
+				pipe.ZRemRangeByLex(ctx, appliedKey, "-", "("+horizon(position))
+				if event.Message == nil {
+					return nil
+				}
+				switch event.Type {
+				case model.EventMessageCreated, model.EventMessageRestored:
+					return r.project(ctx, pipe, event.Message)
+				case model.EventMessageEdited:
+					return r.edit(ctx, tx, pipe, event.Message)
+				case model.EventMessageDeleted:
+					return r.remove(ctx, tx, pipe, event.Message.ID)
+				}
+				return nil
+			})
+			return err
+		}, positionKey, appliedKey)
+		// Other projector moved position meanwhile, check it again.
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+}
+
+func (r *Redis) project(ctx context.Context, pipe redis.Pipeliner, msg *model.Message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	member := &redis.Z{Member: msg.ID}
+	pipe.Set(ctx, r.key("message:", msg.ID), body, 0)
+	pipe.ZAdd(ctx, r.key("messages"), member)
+	pipe.ZAdd(ctx, r.key("user:", msg.User.Name), member)
+
+	bucket := bucketOf(msg.CreatedAt)
+	field := strconv.FormatInt(bucket, 10)
+	for _, h := range msg.Hashtags {
+		pipe.ZAdd(ctx, r.key("hashtag:", h), member)
+		for _, tag := range []string{h, ""} {
+			pipe.HIncrBy(ctx, r.key("trends:", tag), field, 1)
+			pipe.ZAdd(ctx, r.key("trend_buckets:", tag), &redis.Z{Score: float64(bucket), Member: field})
+		}
+	}
+	return nil
+}
+
+// edit replaces text of stored message, keeping the rest of it as projected.
+func (r *Redis) edit(ctx context.Context, tx *redis.Tx, pipe redis.Pipeliner, edited *model.Message) error {
+	msg, err := r.stored(ctx, tx, edited.ID)
+	if msg == nil || err != nil {
+		return err
+	}
+	msg.Text = edited.Text
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	pipe.Set(ctx, r.key("message:", msg.ID), body, 0)
+	return nil
+}
+
+// remove drops stored message from indexes and trends, reverting project.
+func (r *Redis) remove(ctx context.Context, tx *redis.Tx, pipe redis.Pipeliner, ulid string) error {
+	msg, err := r.stored(ctx, tx, ulid)
+	if msg == nil || err != nil {
+		return err
+	}
+	pipe.Del(ctx, r.key("message:", msg.ID))
+	pipe.ZRem(ctx, r.key("messages"), msg.ID)
+	pipe.ZRem(ctx, r.key("user:", msg.User.Name), msg.ID)
+
+	field := strconv.FormatInt(bucketOf(msg.CreatedAt), 10)
+	for _, h := range msg.Hashtags {
+		pipe.ZRem(ctx, r.key("hashtag:", h), msg.ID)
+		for _, tag := range []string{h, ""} {
+			pipe.HIncrBy(ctx, r.key("trends:", tag), field, -1)
+		}
+	}
+	return nil
+}
+
+// stored reads message as projected, nil when it's missing.
+func (r *Redis) stored(ctx context.Context, tx *redis.Tx, ulid string) (*model.Message, error) {
+	body, err := tx.Get(ctx, r.key("message:", ulid)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var msg model.Message
+	return &msg, json.Unmarshal([]byte(body), &msg)
+}
+
+func (r *Redis) load(ctx context.Context, ids []string) ([]*repository.Message, error) {
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, r.key("message:", id))
+	}
+	values, err := r.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	msgs := make([]*repository.Message, 0, len(values))
+	for _, v := range values {
+		body, ok := v.(string)
+		if !ok {
+			continue
+		}
+		var msg model.Message
+		if err := json.Unmarshal([]byte(body), &msg); err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, messageFromEvent(&msg))
+	}
+	return msgs, nil
+}
+
+func (r *Redis) Message(ctx context.Context, ulid string) (*repository.Message, error) {
+	msgs, err := r.load(ctx, []string{ulid})
+	if err != nil {
+		return nil, err
+	}
+	if len(msgs) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return msgs[0], nil
+}
+
+func (r *Redis) Messages(ctx context.Context, filter repository.Filter) ([]*repository.Message, error) {
+	key := r.key("messages")
+	switch {
+	case filter.IsUserQuery():
+		key = r.key("user:", filter.GetUserName())
+	case filter.IsHashtagsQuery():
+		key = r.key("hashtag:", filter.GetHashtag())
+	}
+
+	dateRange := !filter.IsCursorQuery() && filter.IsDateRangeQuery()
+	rng := &redis.ZRangeBy{Min: "-", Max: "+", Count: int64(filter.GetLimit())}
+	switch {
+	case filter.IsCursorQuery():
+		rng.Max = "(" + filter.GetCursor()
+	case dateRange:
+		rng.Max = "(" + timeBound(filter.GetToDate())
+		rng.Min = "[" + timeBound(filter.GetFromDate())
+	}
+
+	var resp []*repository.Message
+	for uint(len(resp)) < filter.GetLimit() {
+		ids, err := r.client.ZRevRangeByLex(ctx, key, rng).Result()
+		if err != nil {
+			return nil, err
+		}
+		msgs, err := r.load(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range msgs {
+			if dateRange && (!msg.CreatedAt.After(filter.GetFromDate()) || !msg.CreatedAt.Before(filter.GetToDate())) {
+				continue
+			}
+			if filter.IsHashtagsQuery() && !hasHashtag(msg, filter.GetHashtag()) {
+				continue
+			}
+			if uint(len(resp)) < filter.GetLimit() {
+				resp = append(resp, msg)
+			}
+		}
+		if int64(len(ids)) < rng.Count {
+			break
+		}
+		rng.Max = "(" + ids[len(ids)-1]
+	}
+	return resp, nil
+}
+
+func (r *Redis) Trends(ctx context.Context, filter repository.Filter) (*repository.MessagesAggregate, error) {
+	if err := validateTrendsFilter(filter); err != nil {
+		return nil, err
+	}
+	hashtag := ""
+	if filter.IsHashtagsQuery() {
+		hashtag = filter.GetHashtag()
+	}
+	fields, err := r.client.ZRangeByScore(ctx, r.key("trend_buckets:", hashtag), &redis.ZRangeBy{
+		Min: "(" + strconv.FormatInt(bucketOf(filter.GetFromDate()), 10),
+		Max: "(" + strconv.FormatInt(bucketOf(filter.GetToDate()), 10),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	buckets := make(map[int64]uint, len(fields))
+	if len(fields) > 0 {
+		counts, err := r.client.HMGet(ctx, r.key("trends:", hashtag), fields...).Result()
+		if err != nil {
+			return nil, err
+		}
+		for i, field := range fields {
+			bucket, _ := strconv.ParseInt(field, 10, 64)
+			value, _ := counts[i].(string)
+			count, _ := strconv.ParseUint(value, 10, 64)
+			// Deleted messages may leave empty buckets behind.
+			if count > 0 {
+				buckets[bucket] = uint(count)
+			}
+		}
+	}
+	return aggregateTrends(filter, buckets), nil
+}
@@ -0,0 +1,15 @@
+package repository
+
+import (
+	"context"
+	"time"
+)
+
+// ArchiveStore lets archiver move old messages out of live database.
+type ArchiveStore interface {
+	// MessagesBefore returns oldest messages created before given time, in ulid order.
+	MessagesBefore(ctx context.Context, before time.Time, limit uint) ([]*Message, error)
+	// DeleteMessages permanently removes messages, trends are kept. Each removed
+	// message is recorded in event log as archived.
+	DeleteMessages(ctx context.Context, ulids []string) error
+}
@@ -0,0 +1,19 @@
+package repository
+
+// IDCache remembers ids of users and hashtags by their names, so they are not
+// looked up on every created message. Users and hashtags are never deleted,
+// so cached ids don't go stale.
+type IDCache interface {
+	UserID(name string) (uint, bool)
+	HashtagID(text string) (uint, bool)
+	AddUser(name string, id uint)
+	AddHashtag(text string, id uint)
+}
+
+// NopIDCache doesn't remember anything.
+type NopIDCache struct{}
+
+func (NopIDCache) UserID(string) (uint, bool)    { return 0, false }
+func (NopIDCache) HashtagID(string) (uint, bool) { return 0, false }
+func (NopIDCache) AddUser(string, uint)          {}
+func (NopIDCache) AddHashtag(string, uint)       {}
@@ -0,0 +1,54 @@
+package cockroach
+
+import (
+	"context"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/jozuenoon/dunder/model"
+	"github.com/jozuenoon/dunder/repository"
+	"github.com/jozuenoon/dunder/tracing"
+)
+
+var _ repository.ArchiveStore = (*ServiceImpl)(nil)
+
+func (s *ServiceImpl) MessagesBefore(ctx context.Context, before time.Time, limit uint) (_ []*repository.Message, err error) {
+	ctx, span := tracer.Start(ctx, "cockroach.MessagesBefore")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	db := withContext(ctx, s.DB)
+	return findMessages(db, messagesQuery(db).Where("messages.created_at < ?", before).Order("messages.ulid").Limit(limit))
+}
+
+func (s *ServiceImpl) DeleteMessages(ctx context.Context, ulids []string) (err error) {
+	ctx, span := tracer.Start(ctx, "cockroach.DeleteMessages")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	if len(ulids) == 0 {
+		return nil
+	}
+	now := time.Now()
+	return s.runInTx(ctx, func(tx *gorm.DB) error {
+		msgs, err := findMessages(tx, messagesQuery(tx).Where("messages.ulid IN (?)", ulids))
+		if err != nil {
+			return err
+		}
+		// Archived messages stay readable, so event log records them as
+		// archived rather than deleted.
+		rows := make([][]interface{}, 0, len(msgs))
+		for _, m := range msgs {
+			e, err := s.outboxEvent(now, model.EventMessageArchived, *m.Ulid, &model.Event{Message: eventMessage(m)})
+			if err != nil {
+				return err
+			}
+			rows = append(rows, []interface{}{e.CreatedAt, *e.Ulid, e.Type, e.AggregateID, e.Payload})
+		}
+		if err := insertRows(tx, "outbox_events", []string{"created_at", "ulid", "type", "aggregate_id", "payload"}, rows, ""); err != nil {
+			return err
+		}
+		if err := execRaw(tx, "DELETE FROM message_hashtags WHERE message_id IN (SELECT id FROM messages WHERE ulid IN (?))", ulids).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Where("ulid IN (?)", ulids).Delete(&repository.Message{}).Error
+	})
+}
@@ -0,0 +1,297 @@
+package cockroach
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/jozuenoon/dunder/metrics"
+	"github.com/jozuenoon/dunder/model"
+	"github.com/jozuenoon/dunder/repository"
+	"github.com/jozuenoon/dunder/tracing"
+)
+
+// CreateMessages creates messages with constant number of statements, users and
+// hashtags of whole batch are resolved at once and rows are inserted with
+// multi-row statements.
+func (s *ServiceImpl) CreateMessages(ctx context.Context, reqs []*repository.CreateMessageRequest) (_ []*repository.CreateMessageResult, err error) {
+	ctx, span := tracer.Start(ctx, "cockroach.CreateMessages")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	return s.createMessages(ctx, reqs, model.EventMessageCreated)
+}
+
+// createMessages inserts batch of messages, each message is recorded in outbox
+// as event of messageEvent type.
+func (s *ServiceImpl) createMessages(ctx context.Context, reqs []*repository.CreateMessageRequest, messageEvent string) ([]*repository.CreateMessageResult, error) {
+	if len(reqs) == 0 {
+		return nil, nil
+	}
+	// As in CreateMessage only messages are backdated by CreatedAt and keep Ulid.
+	t := time.Now()
+	times := make([]time.Time, len(reqs))
+	ulids := make([]string, len(reqs))
+	for i, req := range reqs {
+		times[i] = t
+		if !req.CreatedAt.IsZero() {
+			times[i] = req.CreatedAt
+		}
+		if req.Ulid != "" {
+			ulids[i] = req.Ulid
+			continue
+		}
+		u, err := s.newULID(times[i])
+		if err != nil {
+			return nil, err
+		}
+		ulids[i] = u.String()
+	}
+
+	var (
+		results  []*repository.CreateMessageResult
+		users    map[string]*repository.User
+		hashtags map[string]*repository.Hashtag
+		created  []*repository.Hashtag
+	)
+	err := s.runInTx(ctx, func(tx *gorm.DB) error {
+		var err error
+		results, err = s.replayedMessages(tx, t, reqs, ulids)
+		if err != nil {
+			return err
+		}
+		var pending []int
+		var names, texts []string
+		for i, res := range results {
+			if res != nil {
+				continue
+			}
+			results[i] = &repository.CreateMessageResult{Ulid: ulids[i]}
+			pending = append(pending, i)
+			names = append(names, reqs[i].UserName)
+			texts = append(texts, reqs[i].Hashtags...)
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+
+		var events []*repository.OutboxEvent
+		appendEvent := func(eventType, aggregateID string, event *model.Event) error {
+			e, err := s.outboxEvent(t, eventType, aggregateID, event)
+			if err != nil {
+				return err
+			}
+			events = append(events, e)
+			return nil
+		}
+
+		var createdUsers []*repository.User
+		users, createdUsers, err = s.getUsersByName(tx, unique(names))
+		if err != nil {
+			return err
+		}
+		for _, user := range createdUsers {
+			if err := appendEvent(model.EventUserCreated, *user.Name, &model.Event{
+				User: &model.User{ID: user.ID, Name: *user.Name},
+			}); err != nil {
+				return err
+			}
+		}
+
+		var tags []*repository.Hashtag
+		tags, created, err = s.getHashtagsByText(tx, unique(texts))
+		if err != nil {
+			return err
+		}
+		hashtags = make(map[string]*repository.Hashtag, len(tags))
+		for _, tag := range tags {
+			hashtags[*tag.Text] = tag
+		}
+		for _, tag := range created {
+			if err := appendEvent(model.EventHashtagCreated, *tag.Text, &model.Event{
+				Hashtag: *tag.Text,
+			}); err != nil {
+				return err
+			}
+		}
+
+		messages := make([][]interface{}, 0, len(pending))
+		var keys [][]interface{}
+		var keyUsers []string
+		for _, i := range pending {
+			req := reqs[i]
+			user := users[req.UserName]
+			messages = append(messages, []interface{}{times[i], times[i], ulids[i], user.ID, req.Text})
+			if req.IdempotencyKey != "" {
+				keys = append(keys, []interface{}{req.UserName, req.IdempotencyKey, t, ulids[i]})
+				keyUsers = append(keyUsers, req.UserName)
+			}
+			if err := appendEvent(messageEvent, ulids[i], &model.Event{
+				Message: &model.Message{
+					ID:        ulids[i],
+					User:      model.User{ID: user.ID, Name: *user.Name},
+					Text:      req.Text,
+					Hashtags:  unique(req.Hashtags),
+					CreatedAt: times[i],
+				},
+			}); err != nil {
+				return err
+			}
+		}
+
+		ids, err := insertMessages(tx, messages)
+		if err != nil {
+			return err
+		}
+		var messageHashtags [][]interface{}
+		trends := make(map[trendKey]uint)
+		for _, i := range pending {
+			for _, txt := range unique(reqs[i].Hashtags) {
+				tag := hashtags[txt]
+				messageHashtags = append(messageHashtags, []interface{}{ids[ulids[i]], tag.ID})
+				trends[trendKey{bucket: bucketOf(times[i]), hashtagRef: tag.ID}]++
+			}
+		}
+		if err := insertRows(tx, "message_hashtags", []string{"message_id", "hashtag_id"}, messageHashtags, ""); err != nil {
+			return err
+		}
+		if err := trendsBatchUpdate(tx, trends); err != nil {
+			return err
+		}
+
+		if len(keys) > 0 {
+			if err := tx.Where("user_name IN (?) AND created_at <= ?", unique(keyUsers), t.Add(-s.idempotencyWindow)).
+				Delete(&repository.IdempotencyKey{}).Error; err != nil {
+				return err
+			}
+			if err := insertRows(tx, "idempotency_keys",
+				[]string{"user_name", "idempotency_key", "created_at", "message_ulid"}, keys, ""); err != nil {
+				return err
+			}
+		}
+
+		rows := make([][]interface{}, 0, len(events))
+		for _, e := range events {
+			rows = append(rows, []interface{}{e.CreatedAt, *e.Ulid, e.Type, e.AggregateID, e.Payload})
+		}
+		return insertRows(tx, "outbox_events", []string{"created_at", "ulid", "type", "aggregate_id", "payload"}, rows, "")
+	})
+	if err != nil {
+		return nil, err
+	}
+	for name, user := range users {
+		s.ids.AddUser(name, user.ID)
+	}
+	for txt, tag := range hashtags {
+		s.ids.AddHashtag(txt, tag.ID)
+	}
+	metrics.HashtagsCreated(len(created))
+	return results, nil
+}
+
+// replayedMessages returns results of requests whose idempotency key was used
+// already, results of other requests are nil. ulids are ulids of new messages.
+func (s *ServiceImpl) replayedMessages(tx *gorm.DB, t time.Time, reqs []*repository.CreateMessageRequest, ulids []string) ([]*repository.CreateMessageResult, error) {
+	results := make([]*repository.CreateMessageResult, len(reqs))
+	var conds []string
+	var args []interface{}
+	for _, req := range reqs {
+		if req.IdempotencyKey != "" {
+			conds = append(conds, "(?, ?)")
+			args = append(args, req.UserName, req.IdempotencyKey)
+		}
+	}
+	if len(conds) == 0 {
+		return results, nil
+	}
+
+	stored := make(map[string]string)
+	rows, err := tx.Raw("SELECT user_name, idempotency_key, message_ulid FROM idempotency_keys WHERE created_at > ? AND (user_name, idempotency_key) IN ("+
+		strings.Join(conds, ", ")+")", append([]interface{}{t.Add(-s.idempotencyWindow)}, args...)...).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var userName, key, messageUlid string
+		if err := rows.Scan(&userName, &key, &messageUlid); err != nil {
+			return nil, err
+		}
+		stored[userName+"\x00"+key] = messageUlid
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Repeated key within batch replays its first message.
+	first := make(map[string]int)
+	for i, req := range reqs {
+		if req.IdempotencyKey == "" {
+			continue
+		}
+		k := req.UserName + "\x00" + req.IdempotencyKey
+		if messageUlid, ok := stored[k]; ok {
+			results[i] = &repository.CreateMessageResult{Ulid: messageUlid, Replayed: true}
+			continue
+		}
+		if j, ok := first[k]; ok {
+			results[i] = &repository.CreateMessageResult{Ulid: ulids[j], Replayed: true}
+			continue
+		}
+		first[k] = i
+	}
+	return results, nil
+}
+
+// insertMessages inserts message rows and returns their ids by ulid.
+func insertMessages(tx *gorm.DB, rows [][]interface{}) (map[string]uint, error) {
+	query, args := valuesQuery("messages", []string{"created_at", "updated_at", "ulid", "user_ref", "text"}, rows)
+	result, err := tx.Raw(query+" RETURNING id, ulid", args...).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+	ids := make(map[string]uint, len(rows))
+	for result.Next() {
+		var id uint
+		var ulid string
+		if err := result.Scan(&id, &ulid); err != nil {
+			return nil, err
+		}
+		ids[ulid] = id
+	}
+	return ids, result.Err()
+}
+
+// insertRows inserts rows with single statement, suffix is appended to it, eg. ON CONFLICT clause.
+func insertRows(tx *gorm.DB, table string, columns []string, rows [][]interface{}, suffix string) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	query, args := valuesQuery(table, columns, rows)
+	return execRaw(tx, query+suffix, args...).Error
+}
+
+func valuesQuery(table string, columns []string, rows [][]interface{}) (string, []interface{}) {
+	placeholders := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ") + ")"
+	values := make([]string, 0, len(rows))
+	args := make([]interface{}, 0, len(rows)*len(columns))
+	for _, row := range rows {
+		values = append(values, placeholders)
+		args = append(args, row...)
+	}
+	return "INSERT INTO " + table + " (" + strings.Join(columns, ", ") + ") VALUES " + strings.Join(values, ", "), args
+}
+
+// unique returns values without repetitions keeping their order.
+func unique(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	out := make([]string, 0, len(values))
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,111 @@
+// +build integration
+
+package cockroach
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/jozuenoon/dunder/model"
+	"github.com/jozuenoon/dunder/repository"
+)
+
+// preloadMessages is former implementation of Messages, resolving user and
+// hashtag with separate statements and loading associations with preloads.
+// It's kept to compare against joined query.
+func preloadMessages(db *gorm.DB, filter repository.Filter) ([]*repository.Message, error) {
+	var resp []*repository.Message
+	query := db.Limit(filter.GetLimit()).Order("ulid desc")
+	if filter.IsUserQuery() {
+		var user repository.User
+		db.Where("name = ?", filter.GetUserName()).First(&user)
+		query = query.Where("user_ref = ?", user.ID)
+	}
+	if filter.IsHashtagsQuery() {
+		var tag repository.Hashtag
+		db.Where("text = ?", filter.GetHashtag()).Find(&tag)
+		query = query.Joins("JOIN message_hashtags on message_hashtags.message_id = messages.id").
+			Where("message_hashtags.hashtag_id = ?", tag.ID)
+	}
+	return resp, query.Preload("User").Preload("Hashtags").Find(&resp).Error
+}
+
+// countStatements registers callbacks counting executed SQL statements.
+func countStatements(db *gorm.DB) *int64 {
+	var n int64
+	count := func(*gorm.Scope) { atomic.AddInt64(&n, 1) }
+	db.Callback().Query().After("gorm:query").Register("bench:count_query", count)
+	db.Callback().RowQuery().After("gorm:row_query").Register("bench:count_row_query", count)
+	return &n
+}
+
+func BenchmarkMessages(b *testing.B) {
+	database := fmt.Sprintf("bench_%d", rand.Intn(1000))
+	if err := createDb(database); err != nil {
+		b.Fatalf("failed to create database: %s", err)
+	}
+	defer func() {
+		if err := executeDb("dropdb", database); err != nil {
+			b.Error(err)
+		}
+	}()
+	user := "root"
+	svc, err := New(&Config{
+		Host:           getDBHost(),
+		MigrateOnStart: true,
+		Database:       &database,
+		User:           &user,
+	})
+	if err != nil {
+		b.Fatal("failed to create service")
+	}
+	defer svc.Close()
+
+	ctx := context.Background()
+	for i := 0; i < 500; i++ {
+		if _, err := svc.CreateMessage(ctx, &repository.CreateMessageRequest{
+			UserName: fmt.Sprintf("user%d@example.com", i%10),
+			Text:     fmt.Sprintf("message %d", i),
+			Hashtags: []string{fmt.Sprintf("tag%d", i%20), fmt.Sprintf("tag%d", i%7)},
+		}); err != nil {
+			b.Fatal(err)
+		}
+	}
+	statements := countStatements(svc.DB)
+
+	filters := map[string]model.QueryRequest{
+		"latest":       {},
+		"user":         {Rules: model.QueryRules{UserName: []string{"user3@example.com"}}},
+		"hashtag":      {Rules: model.QueryRules{Hashtag: []string{"tag5"}}},
+		"user_hashtag": {Rules: model.QueryRules{UserName: []string{"user3@example.com"}, Hashtag: []string{"tag3"}}},
+		"unknown_user": {Rules: model.QueryRules{UserName: []string{"nobody@example.com"}}},
+	}
+	impls := map[string]func(filter repository.Filter) ([]*repository.Message, error){
+		"joined": func(filter repository.Filter) ([]*repository.Message, error) {
+			return svc.Messages(ctx, filter)
+		},
+		"preload": func(filter repository.Filter) ([]*repository.Message, error) {
+			return preloadMessages(withContext(ctx, svc.DB), filter)
+		},
+	}
+	for name, query := range filters {
+		filter := &repository.FilterImpl{QueryRequest: query}
+		for impl, messages := range impls {
+			b.Run(name+"/"+impl, func(b *testing.B) {
+				atomic.StoreInt64(statements, 0)
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := messages(filter); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(atomic.LoadInt64(statements))/float64(b.N), "stmts/op")
+			})
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package cockroach
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/jozuenoon/dunder/model"
+)
+
+var sslModes = map[string]bool{
+	"disable":     true,
+	"require":     true,
+	"verify-ca":   true,
+	"verify-full": true,
+}
+
+// validate checks connection options which can't be used together. Options
+// overridden by DSN are not checked.
+func (cfg *Config) validate() error {
+	if cfg.ReadConsistency != "" && cfg.ReadConsistency != model.ConsistencyStrong && cfg.ReadConsistency != model.ConsistencyStale {
+		return fmt.Errorf("invalid read consistency %q, options: strong, stale", cfg.ReadConsistency)
+	}
+	if cfg.ReadStaleness < 0 {
+		return errors.New("read staleness can't be negative")
+	}
+	if cfg.MaxOpenConns < 0 || cfg.MaxIdleConns < 0 || cfg.ConnMaxLifetime < 0 {
+		return errors.New("connection pool limits can't be negative")
+	}
+	if cfg.MaxOpenConns > 0 && cfg.MaxIdleConns > cfg.MaxOpenConns {
+		return errors.New("max idle connections exceed max open connections")
+	}
+	if cfg.DSN != "" {
+		return nil
+	}
+	if cfg.Port < 0 || cfg.Port > 65535 {
+		return fmt.Errorf("invalid port %d", cfg.Port)
+	}
+	if cfg.Password != "" && cfg.PasswordFile != "" {
+		return errors.New("password and password file are mutually exclusive")
+	}
+	if cfg.SSLMode != "" && !sslModes[cfg.SSLMode] {
+		return fmt.Errorf("invalid sslmode %q, options: disable, require, verify-ca, verify-full", cfg.SSLMode)
+	}
+	if (cfg.SSLCert == "") != (cfg.SSLKey == "") {
+		return errors.New("client certificate and key must be set together")
+	}
+	if cfg.SSLMode == "disable" || cfg.SSLMode == "" {
+		if cfg.SSLRootCert != "" || cfg.SSLCert != "" {
+			return errors.New("certificates require sslmode other than disable")
+		}
+	}
+	if (cfg.SSLMode == "verify-ca" || cfg.SSLMode == "verify-full") && cfg.SSLRootCert == "" {
+		return fmt.Errorf("sslmode %s requires root certificate", cfg.SSLMode)
+	}
+	return nil
+}
+
+// dsn builds PostgreSQL connection string from config.
+func (cfg *Config) dsn() (string, error) {
+	if err := cfg.validate(); err != nil {
+		return "", fmt.Errorf("cockroach config: %w", err)
+	}
+	if cfg.DSN != "" {
+		return cfg.DSN, nil
+	}
+
+	database := defaultDatabase
+	if cfg.Database != nil && *cfg.Database != "" {
+		database = *cfg.Database
+	}
+	user := url.User(defaultUser)
+	if cfg.User != nil && *cfg.User != "" {
+		user = url.User(*cfg.User)
+	}
+	password := cfg.Password
+	if cfg.PasswordFile != "" {
+		b, err := ioutil.ReadFile(cfg.PasswordFile)
+		if err != nil {
+			return "", fmt.Errorf("cockroach config: %w", err)
+		}
+		password = strings.TrimSpace(string(b))
+	}
+	if password != "" {
+		user = url.UserPassword(user.Username(), password)
+	}
+	port := cfg.Port
+	if port == 0 {
+		port = defaultPort
+	}
+	sslMode := cfg.SSLMode
+	if sslMode == "" {
+		sslMode = defaultSSLMode
+	}
+
+	query := url.Values{"sslmode": {sslMode}}
+	for key, value := range map[string]string{
+		"sslrootcert": cfg.SSLRootCert,
+		"sslcert":     cfg.SSLCert,
+		"sslkey":      cfg.SSLKey,
+	} {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	u := url.URL{
+		Scheme:   "postgresql",
+		User:     user,
+		Host:     net.JoinHostPort(cfg.Host, strconv.Itoa(port)),
+		Path:     "/" + database,
+		RawQuery: query.Encode(),
+	}
+	return u.String(), nil
+}
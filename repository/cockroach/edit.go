@@ -0,0 +1,89 @@
+package cockroach
+
+import (
+	"context"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/jozuenoon/dunder/model"
+	"github.com/jozuenoon/dunder/repository"
+	"github.com/jozuenoon/dunder/tracing"
+)
+
+func (s *ServiceImpl) EditMessage(ctx context.Context, req *repository.EditMessageRequest) (err error) {
+	ctx, span := tracer.Start(ctx, "cockroach.EditMessage")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	now := time.Now()
+	return s.runInTx(ctx, func(tx *gorm.DB) error {
+		msg, err := authoredMessage(tx, req.UserName, req.Ulid)
+		if err != nil {
+			return err
+		}
+		if err := execRaw(tx, "UPDATE messages SET text = ?, updated_at = ? WHERE id = ?", req.Text, now, msg.ID).Error; err != nil {
+			return err
+		}
+		msg.Text = req.Text
+		return s.appendEvent(tx, now, model.EventMessageEdited, req.Ulid, &model.Event{
+			Message: eventMessage(msg),
+		})
+	})
+}
+
+func (s *ServiceImpl) DeleteMessage(ctx context.Context, userName, ulid string) (err error) {
+	ctx, span := tracer.Start(ctx, "cockroach.DeleteMessage")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	now := time.Now()
+	return s.runInTx(ctx, func(tx *gorm.DB) error {
+		msg, err := authoredMessage(tx, userName, ulid)
+		if err != nil {
+			return err
+		}
+		if err := execRaw(tx, "UPDATE messages SET deleted_at = ? WHERE id = ?", now, msg.ID).Error; err != nil {
+			return err
+		}
+		if err := trendsRemove(tx, msg.CreatedAt, msg.Hashtags); err != nil {
+			return err
+		}
+		return s.appendEvent(tx, now, model.EventMessageDeleted, ulid, &model.Event{
+			Message: eventMessage(msg),
+		})
+	})
+}
+
+// authoredMessage loads message of user, messages of other users are not found.
+func authoredMessage(tx *gorm.DB, userName, ulid string) (*repository.Message, error) {
+	msgs, err := findMessages(tx, messagesQuery(tx).Where("messages.ulid = ?", ulid).Where("users.name = ?", userName))
+	if err != nil {
+		return nil, err
+	}
+	if len(msgs) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return msgs[0], nil
+}
+
+// trendsRemove takes message out of its trend bucket, reverting trendsUpdate.
+func trendsRemove(tx *gorm.DB, t time.Time, tags []*repository.Hashtag) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	ids := make([]uint, 0, len(tags))
+	for _, tag := range tags {
+		ids = append(ids, tag.ID)
+	}
+	return execRaw(tx, "UPDATE trends SET count = count - 1 WHERE bucket = ? AND hashtag_ref IN (?) AND count > 0",
+		bucketOf(t), ids).Error
+}
+
+// eventMessage converts message to payload of its events.
+func eventMessage(m *repository.Message) *model.Message {
+	return &model.Message{
+		ID:        *m.Ulid,
+		User:      model.User{ID: m.User.ID, Name: *m.User.Name},
+		Text:      m.Text,
+		Hashtags:  hashtagTexts(m.Hashtags),
+		CreatedAt: m.CreatedAt,
+	}
+}
@@ -0,0 +1,39 @@
+package cockroach
+
+import (
+	"context"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/jozuenoon/dunder/repository"
+	"github.com/jozuenoon/dunder/tracing"
+)
+
+func (s *ServiceImpl) IdempotentMessage(ctx context.Context, userName, key string) (_ string, err error) {
+	ctx, span := tracer.Start(ctx, "cockroach.IdempotentMessage")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	var k repository.IdempotencyKey
+	if err := withContext(ctx, s.DB).
+		Where("user_name = ? AND idempotency_key = ? AND created_at > ?", userName, key, time.Now().Add(-s.idempotencyWindow)).
+		First(&k).Error; err != nil {
+		return "", err
+	}
+	return k.MessageUlid, nil
+}
+
+// storeIdempotencyKey records key in message transaction. Expired keys of user are
+// dropped first, so the key could be reused. Concurrent request with the same
+// key fails on primary key conflict.
+func (s *ServiceImpl) storeIdempotencyKey(tx *gorm.DB, t time.Time, userName, key, messageUlid string) error {
+	if err := tx.Where("user_name = ? AND created_at <= ?", userName, t.Add(-s.idempotencyWindow)).
+		Delete(&repository.IdempotencyKey{}).Error; err != nil {
+		return err
+	}
+	return tx.Create(&repository.IdempotencyKey{
+		UserName:    userName,
+		Key:         key,
+		CreatedAt:   t,
+		MessageUlid: messageUlid,
+	}).Error
+}
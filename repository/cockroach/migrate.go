@@ -0,0 +1,267 @@
+package cockroach
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/jozuenoon/dunder/tracing"
+)
+
+const (
+	// migrationLockTTL bounds how long crashed migrator blocks others, lease
+	// of running migrator is renewed well before it expires.
+	migrationLockTTL           = 5 * time.Minute
+	migrationLockRenewInterval = migrationLockTTL / 5
+	migrationLockPollInterval  = time.Second
+)
+
+// errMigrationLockLost is returned when lease expired and other migrator may hold the lock.
+var errMigrationLockLost = errors.New("migration lock lost")
+
+// MigrationStatus describes schema migration, AppliedAt is nil for pending ones.
+type MigrationStatus struct {
+	Version   uint
+	Name      string
+	AppliedAt *time.Time
+}
+
+// MigrateUp applies pending migrations in order and returns applied ones.
+// Replicas migrating at once take turns holding migration lock, so each
+// migration is applied exactly once.
+func (s *ServiceImpl) MigrateUp(ctx context.Context) (_ []*MigrationStatus, err error) {
+	ctx, span := tracer.Start(ctx, "cockroach.MigrateUp")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	lock, err := s.lockMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.release()
+
+	applied, err := s.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var resp []*MigrationStatus
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		if err := s.runMigration(ctx, lock, m, m.Up, true); err != nil {
+			return resp, fmt.Errorf("migration %d %q failed: %w", m.Version, m.Name, err)
+		}
+		now := time.Now()
+		resp = append(resp, &MigrationStatus{Version: m.Version, Name: m.Name, AppliedAt: &now})
+	}
+	return resp, nil
+}
+
+// MigrateDown reverts latest applied migration, it returns nil when there is nothing to revert.
+func (s *ServiceImpl) MigrateDown(ctx context.Context) (_ *MigrationStatus, err error) {
+	ctx, span := tracer.Start(ctx, "cockroach.MigrateDown")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	lock, err := s.lockMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.release()
+
+	applied, err := s.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if err := s.runMigration(ctx, lock, m, m.Down, false); err != nil {
+			return nil, fmt.Errorf("migration %d %q revert failed: %w", m.Version, m.Name, err)
+		}
+		return &MigrationStatus{Version: m.Version, Name: m.Name}, nil
+	}
+	return nil, nil
+}
+
+// Migrations lists all known migrations with time they were applied.
+func (s *ServiceImpl) Migrations(ctx context.Context) (_ []*MigrationStatus, err error) {
+	ctx, span := tracer.Start(ctx, "cockroach.Migrations")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	if err := s.createMigrationTables(ctx); err != nil {
+		return nil, err
+	}
+	applied, err := s.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp := make([]*MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		status := &MigrationStatus{Version: m.Version, Name: m.Name}
+		if t, ok := applied[m.Version]; ok {
+			status.AppliedAt = &t
+		}
+		resp = append(resp, status)
+	}
+	return resp, nil
+}
+
+// schemaVersion returns version of latest applied migration.
+func (s *ServiceImpl) schemaVersion(ctx context.Context) (uint, error) {
+	var version uint
+	row := withContext(ctx, s.DB).Raw("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Row()
+	return version, row.Scan(&version)
+}
+
+func latestVersion() uint {
+	return migrations[len(migrations)-1].Version
+}
+
+func (s *ServiceImpl) createMigrationTables(ctx context.Context) error {
+	db := withContext(ctx, s.DB)
+	if err := execRaw(db, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INT8 NOT NULL PRIMARY KEY,
+		name STRING NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`).Error; err != nil {
+		return err
+	}
+	return execRaw(db, `CREATE TABLE IF NOT EXISTS schema_migrations_lock (
+		id INT8 NOT NULL PRIMARY KEY,
+		holder STRING NOT NULL,
+		expires_at TIMESTAMPTZ NOT NULL
+	)`).Error
+}
+
+// migrationLock is lease on running migrations, it's renewed in background
+// until released.
+type migrationLock struct {
+	s      *ServiceImpl
+	holder string
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// lockMigrations waits until migration lock is taken. Lock is lease expiring
+// after migrationLockTTL, so migrator which crashed doesn't block others forever.
+func (s *ServiceImpl) lockMigrations(ctx context.Context) (*migrationLock, error) {
+	if err := s.createMigrationTables(ctx); err != nil {
+		return nil, err
+	}
+	u, err := s.newULID(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	holder := u.String()
+	for {
+		now := time.Now()
+		result := execRaw(withContext(ctx, s.DB), `INSERT INTO schema_migrations_lock (id, holder, expires_at) VALUES (1, ?, ?)
+			ON CONFLICT (id) DO UPDATE SET holder = excluded.holder, expires_at = excluded.expires_at
+			WHERE schema_migrations_lock.expires_at < ?`, holder, now.Add(migrationLockTTL), now)
+		if result.Error != nil && !isRetryable(result.Error) {
+			return nil, result.Error
+		}
+		if result.Error == nil && result.RowsAffected == 1 {
+			lock := &migrationLock{s: s, holder: holder, stop: make(chan struct{}), done: make(chan struct{})}
+			go lock.renew()
+			return lock, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(migrationLockPollInterval):
+		}
+	}
+}
+
+// renew extends lease every migrationLockRenewInterval until lock is released.
+// Failed renewal is retried on next tick, migrations check the lease anyway.
+func (l *migrationLock) renew() {
+	defer close(l.done)
+	ticker := time.NewTicker(migrationLockRenewInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			_ = l.extend()
+		}
+	}
+}
+
+// extend moves lease expiration, errMigrationLockLost means other migrator took it over.
+func (l *migrationLock) extend() error {
+	result := execRaw(l.s.DB, "UPDATE schema_migrations_lock SET expires_at = ? WHERE id = 1 AND holder = ?",
+		time.Now().Add(migrationLockTTL), l.holder)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errMigrationLockLost
+	}
+	return nil
+}
+
+// check verifies within migration transaction that lease is still held, so
+// migration isn't recorded after other migrator took the lock over.
+func (l *migrationLock) check(tx *gorm.DB) error {
+	var held int
+	if err := tx.Raw("SELECT count(*) FROM schema_migrations_lock WHERE id = 1 AND holder = ? AND expires_at > ?",
+		l.holder, time.Now()).Row().Scan(&held); err != nil {
+		return err
+	}
+	if held == 0 {
+		return errMigrationLockLost
+	}
+	return nil
+}
+
+func (l *migrationLock) release() {
+	close(l.stop)
+	<-l.done
+	execRaw(l.s.DB, "DELETE FROM schema_migrations_lock WHERE id = 1 AND holder = ?", l.holder)
+}
+
+func (s *ServiceImpl) appliedMigrations(ctx context.Context) (map[uint]time.Time, error) {
+	rows, err := withContext(ctx, s.DB).Raw("SELECT version, applied_at FROM schema_migrations").Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := make(map[uint]time.Time)
+	for rows.Next() {
+		var version uint
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// runMigration executes statements and records migration state in one
+// transaction, which fails unless lock is still held.
+func (s *ServiceImpl) runMigration(ctx context.Context, lock *migrationLock, m migration, statements []string, up bool) error {
+	return s.runInTx(ctx, func(tx *gorm.DB) error {
+		if err := lock.check(tx); err != nil {
+			return err
+		}
+		for _, stmt := range statements {
+			if err := execRaw(tx, stmt).Error; err != nil {
+				return err
+			}
+		}
+		if up {
+			return execRaw(tx, "INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+				m.Version, m.Name, time.Now()).Error
+		}
+		return execRaw(tx, "DELETE FROM schema_migrations WHERE version = ?", m.Version).Error
+	})
+}
@@ -0,0 +1,175 @@
+package cockroach
+
+// migration is versioned schema change. Statements of migration are applied in
+// single transaction along with its record in schema_migrations table.
+type migration struct {
+	Version uint
+	Name    string
+	Up      []string
+	Down    []string
+}
+
+// migrations must be only appended to, versions are applied in order. Tables
+// are created with IF NOT EXISTS, so databases created by gorm AutoMigrate are
+// adopted by the first migrations.
+var migrations = []migration{
+	{
+		Version: 1,
+		Name:    "create messages",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS users (
+				id INT8 NOT NULL DEFAULT unique_rowid() PRIMARY KEY,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				deleted_at TIMESTAMPTZ,
+				name STRING NOT NULL UNIQUE,
+				screen_name STRING,
+				location STRING,
+				url STRING,
+				description STRING
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users (deleted_at)`,
+			`CREATE TABLE IF NOT EXISTS messages (
+				id INT8 NOT NULL DEFAULT unique_rowid() PRIMARY KEY,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				deleted_at TIMESTAMPTZ,
+				ulid STRING NOT NULL UNIQUE,
+				user_ref INT8,
+				text STRING
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_messages_deleted_at ON messages (deleted_at)`,
+			`CREATE TABLE IF NOT EXISTS hashtags (
+				id INT8 NOT NULL DEFAULT unique_rowid() PRIMARY KEY,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				deleted_at TIMESTAMPTZ,
+				text STRING NOT NULL UNIQUE
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_hashtags_deleted_at ON hashtags (deleted_at)`,
+			`CREATE TABLE IF NOT EXISTS message_hashtags (
+				message_id INT8 NOT NULL,
+				hashtag_id INT8 NOT NULL,
+				PRIMARY KEY (message_id, hashtag_id)
+			)`,
+			`CREATE TABLE IF NOT EXISTS trends (
+				bucket INT8 NOT NULL,
+				hashtag_ref INT8 NOT NULL,
+				count INT8,
+				PRIMARY KEY (bucket, hashtag_ref)
+			)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS trends`,
+			`DROP TABLE IF EXISTS message_hashtags`,
+			`DROP TABLE IF EXISTS hashtags`,
+			`DROP TABLE IF EXISTS messages`,
+			`DROP TABLE IF EXISTS users`,
+		},
+	},
+	{
+		Version: 2,
+		Name:    "create webhooks",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+				id INT8 NOT NULL DEFAULT unique_rowid() PRIMARY KEY,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				deleted_at TIMESTAMPTZ,
+				ulid STRING NOT NULL UNIQUE,
+				owner_ref INT8,
+				url STRING NOT NULL,
+				hashtag STRING,
+				user_name STRING,
+				secret STRING NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_webhook_subscriptions_deleted_at ON webhook_subscriptions (deleted_at)`,
+			`CREATE INDEX IF NOT EXISTS idx_webhook_subscriptions_owner_ref ON webhook_subscriptions (owner_ref)`,
+			`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+				id INT8 NOT NULL DEFAULT unique_rowid() PRIMARY KEY,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				subscription_ref INT8,
+				event_id STRING,
+				event_type STRING,
+				attempt INT8,
+				status_code INT8,
+				error STRING,
+				dead BOOL
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_subscription_ref ON webhook_deliveries (subscription_ref)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS webhook_deliveries`,
+			`DROP TABLE IF EXISTS webhook_subscriptions`,
+		},
+	},
+	{
+		Version: 3,
+		Name:    "create outbox",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS outbox_events (
+				id INT8 NOT NULL DEFAULT unique_rowid() PRIMARY KEY,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				ulid STRING NOT NULL UNIQUE,
+				type STRING NOT NULL,
+				aggregate_id STRING NOT NULL,
+				payload JSONB NOT NULL,
+				published_at TIMESTAMPTZ
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_outbox_events_published_at ON outbox_events (published_at)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS outbox_events`,
+		},
+	},
+	{
+		Version: 4,
+		Name:    "create idempotency keys",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS idempotency_keys (
+				user_name STRING NOT NULL,
+				idempotency_key STRING NOT NULL,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				message_ulid STRING NOT NULL,
+				PRIMARY KEY (user_name, idempotency_key)
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_idempotency_keys_created_at ON idempotency_keys (created_at)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS idempotency_keys`,
+		},
+	},
+	{
+		Version: 5,
+		Name:    "index message search",
+		Up: []string{
+			`CREATE INDEX IF NOT EXISTS idx_messages_created_at ON messages (created_at)`,
+			`CREATE INDEX IF NOT EXISTS idx_message_hashtags_hashtag_id ON message_hashtags (hashtag_id)`,
+		},
+		Down: []string{
+			`DROP INDEX IF EXISTS messages@idx_messages_created_at`,
+			`DROP INDEX IF EXISTS message_hashtags@idx_message_hashtags_hashtag_id`,
+		},
+	},
+	{
+		Version: 6,
+		Name:    "create webhook jobs",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS webhook_jobs (
+				id INT8 NOT NULL DEFAULT unique_rowid() PRIMARY KEY,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				subscription_ref INT8 NOT NULL,
+				event_id STRING NOT NULL,
+				event_type STRING NOT NULL,
+				payload STRING NOT NULL,
+				attempt INT8 NOT NULL DEFAULT 0,
+				next_attempt_at TIMESTAMPTZ NOT NULL,
+				UNIQUE (subscription_ref, event_id)
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_webhook_jobs_next_attempt_at ON webhook_jobs (next_attempt_at)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS webhook_jobs`,
+		},
+	},
+}
@@ -0,0 +1,17 @@
+package cockroach
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrations_Versions(t *testing.T) {
+	for i, m := range migrations {
+		assert.Equal(t, uint(i+1), m.Version, "versions must be sequential")
+		assert.NotEmpty(t, m.Name)
+		assert.NotEmpty(t, m.Up, "migration %d has no up statements", m.Version)
+		assert.NotEmpty(t, m.Down, "migration %d has no down statements", m.Version)
+	}
+	assert.Equal(t, uint(len(migrations)), latestVersion())
+}
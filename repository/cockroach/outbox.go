@@ -0,0 +1,78 @@
+package cockroach
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/jozuenoon/dunder/model"
+	"github.com/jozuenoon/dunder/repository"
+	"github.com/jozuenoon/dunder/tracing"
+)
+
+var _ repository.OutboxStore = (*ServiceImpl)(nil)
+
+// appendEvent writes event to outbox, it must use transaction of the state change it describes.
+func (s *ServiceImpl) appendEvent(tx *gorm.DB, t time.Time, eventType, aggregateID string, event *model.Event) error {
+	e, err := s.outboxEvent(t, eventType, aggregateID, event)
+	if err != nil {
+		return err
+	}
+	return tx.Create(e).Error
+}
+
+// outboxEvent prepares outbox row of event.
+func (s *ServiceImpl) outboxEvent(t time.Time, eventType, aggregateID string, event *model.Event) (*repository.OutboxEvent, error) {
+	u, err := s.newULID(t)
+	if err != nil {
+		return nil, err
+	}
+	us := u.String()
+	event.ID = us
+	event.Type = eventType
+	event.CreatedAt = t
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	return &repository.OutboxEvent{
+		CreatedAt:   t,
+		Ulid:        &us,
+		Type:        eventType,
+		AggregateID: aggregateID,
+		Payload:     string(payload),
+	}, nil
+}
+
+func (s *ServiceImpl) PendingEvents(ctx context.Context, limit uint) (_ []*repository.OutboxEvent, err error) {
+	ctx, span := tracer.Start(ctx, "cockroach.PendingEvents")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	var events []*repository.OutboxEvent
+	return events, withContext(ctx, s.DB).Where("published_at IS NULL").
+		Order("ulid").Limit(limit).Find(&events).Error
+}
+
+func (s *ServiceImpl) MarkPublished(ctx context.Context, ids []uint) (err error) {
+	ctx, span := tracer.Start(ctx, "cockroach.MarkPublished")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	if len(ids) == 0 {
+		return nil
+	}
+	return withContext(ctx, s.DB).Model(&repository.OutboxEvent{}).
+		Where("id IN (?)", ids).Update("published_at", time.Now()).Error
+}
+
+func (s *ServiceImpl) Events(ctx context.Context, after string, limit uint) (_ []*repository.OutboxEvent, err error) {
+	ctx, span := tracer.Start(ctx, "cockroach.Events")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	query := withContext(ctx, s.DB).Order("ulid").Limit(limit)
+	if after != "" {
+		query = query.Where("ulid > ?", after)
+	}
+	var events []*repository.OutboxEvent
+	return events, query.Find(&events).Error
+}
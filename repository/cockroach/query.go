@@ -0,0 +1,90 @@
+package cockroach
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/jozuenoon/dunder/repository"
+)
+
+// messagesQuery selects messages along with names of their authors. Filters on
+// user and hashtag names are resolved by joins within the same statement, so
+// unknown user or hashtag yields no messages.
+func messagesQuery(db *gorm.DB) *gorm.DB {
+	return db.Table("messages").
+		Select("messages.id, messages.created_at, messages.updated_at, messages.ulid, messages.user_ref, messages.text, users.name").
+		Joins("JOIN users ON users.id = messages.user_ref").
+		Where("messages.deleted_at IS NULL")
+}
+
+// withHashtag limits messagesQuery to messages tagged with hashtag.
+func withHashtag(query *gorm.DB, hashtag string) *gorm.DB {
+	return query.
+		Joins("JOIN message_hashtags ON message_hashtags.message_id = messages.id").
+		Joins("JOIN hashtags ON hashtags.id = message_hashtags.hashtag_id").
+		Where("hashtags.text = ?", hashtag)
+}
+
+// findMessages runs messagesQuery and loads hashtags of all found messages
+// with one more statement.
+func findMessages(db, query *gorm.DB) ([]*repository.Message, error) {
+	rows, err := query.Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var msgs []*repository.Message
+	for rows.Next() {
+		var (
+			m    repository.Message
+			ulid string
+			name string
+		)
+		if err := rows.Scan(&m.ID, &m.CreatedAt, &m.UpdatedAt, &ulid, &m.UserRef, &m.Text, &name); err != nil {
+			return nil, err
+		}
+		m.Ulid = &ulid
+		m.User = repository.User{ID: m.UserRef, Name: &name}
+		msgs = append(msgs, &m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return msgs, loadHashtags(db, msgs)
+}
+
+func loadHashtags(db *gorm.DB, msgs []*repository.Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	ids := make([]uint, 0, len(msgs))
+	byID := make(map[uint]*repository.Message, len(msgs))
+	for _, m := range msgs {
+		ids = append(ids, m.ID)
+		byID[m.ID] = m
+	}
+
+	rows, err := db.Table("message_hashtags").
+		Select("message_hashtags.message_id, hashtags.id, hashtags.text").
+		Joins("JOIN hashtags ON hashtags.id = message_hashtags.hashtag_id").
+		Where("message_hashtags.message_id IN (?)", ids).
+		Where("hashtags.deleted_at IS NULL").
+		Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			messageID uint
+			tag       repository.Hashtag
+			text      string
+		)
+		if err := rows.Scan(&messageID, &tag.ID, &text); err != nil {
+			return err
+		}
+		tag.Text = &text
+		m := byID[messageID]
+		m.Hashtags = append(m.Hashtags, &tag)
+	}
+	return rows.Err()
+}
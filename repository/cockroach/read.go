@@ -0,0 +1,113 @@
+package cockroach
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/jozuenoon/dunder/metrics"
+	"github.com/jozuenoon/dunder/model"
+	"github.com/lib/pq"
+)
+
+// replicaRetryInterval is how long reads stay on primary after replica failed.
+const replicaRetryInterval = 10 * time.Second
+
+// replica is read pool used while it's healthy.
+type replica struct {
+	db *gorm.DB
+
+	mu        sync.Mutex
+	downUntil time.Time
+}
+
+func (r *replica) healthy() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return time.Now().After(r.downUntil)
+}
+
+func (r *replica) markDown() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.downUntil = time.Now().Add(replicaRetryInterval)
+}
+
+// read runs fn with database handle for reads of given consistency, empty
+// consistency uses store default. Reads go to read replica when configured,
+// when it can't be reached fn is run again on primary and replica is skipped
+// for replicaRetryInterval. Replica lags behind primary, so strong reads of
+// records it doesn't have yet are retried on primary too.
+func (s *ServiceImpl) read(ctx context.Context, consistency string, fn func(db *gorm.DB) error) error {
+	if consistency == "" {
+		consistency = s.readConsistency
+	}
+	if s.replica == nil || !s.replica.healthy() {
+		return s.readFrom(ctx, s.DB, consistency, fn)
+	}
+	err := s.readFrom(ctx, s.replica.db, consistency, fn)
+	if err == gorm.ErrRecordNotFound && consistency == model.ConsistencyStrong {
+		return s.readFrom(ctx, s.DB, consistency, fn)
+	}
+	if err == nil || !isConnectionError(err) {
+		return err
+	}
+	s.replica.markDown()
+	metrics.ReadFallback()
+	return s.readFrom(ctx, s.DB, consistency, fn)
+}
+
+// readFrom runs fn on db. Stale reads run in transaction reading at past
+// timestamp, which lets CockroachDB serve them from follower replica nearest
+// to the service.
+func (s *ServiceImpl) readFrom(ctx context.Context, db *gorm.DB, consistency string, fn func(db *gorm.DB) error) error {
+	db = withContext(ctx, db)
+	switch consistency {
+	case model.ConsistencyStrong:
+		return fn(db)
+	case model.ConsistencyStale:
+	default:
+		return fmt.Errorf("unknown read consistency %q", consistency)
+	}
+
+	tx := db.Begin()
+	if err := tx.Error; err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := execRaw(tx, "SET TRANSACTION AS OF SYSTEM TIME "+s.staleTimestamp()).Error; err != nil {
+		return err
+	}
+	return fn(tx)
+}
+
+// staleTimestamp is AS OF SYSTEM TIME expression of stale reads.
+func (s *ServiceImpl) staleTimestamp() string {
+	if s.readStaleness > 0 {
+		return fmt.Sprintf("'-%dms'", s.readStaleness.Milliseconds())
+	}
+	return "follower_read_timestamp()"
+}
+
+// isConnectionError tells whether database couldn't be reached, as opposed to
+// failed query.
+func isConnectionError(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// Class 08 is connection exception, 57P0x are server shutting down or starting.
+		return pqErr.Code.Class() == "08" || pqErr.Code == "57P01" || pqErr.Code == "57P02" || pqErr.Code == "57P03"
+	}
+	return false
+}
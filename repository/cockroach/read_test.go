@@ -0,0 +1,88 @@
+package cockroach
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/jozuenoon/dunder/model"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// unreachable opens pool which is never connected, queries are faked by tests.
+func unreachable(t *testing.T) *gorm.DB {
+	pool, err := sql.Open("postgres", "postgresql://root@127.0.0.1:1/dunder?sslmode=disable")
+	require.NoError(t, err)
+	db, _ := gorm.Open("postgres", pool)
+	return db
+}
+
+func TestService_ReadFallback(t *testing.T) {
+	primary, replicaDB := unreachable(t), unreachable(t)
+	defer primary.Close()
+	defer replicaDB.Close()
+	s := &ServiceImpl{DB: primary, replica: &replica{db: replicaDB}, readConsistency: model.ConsistencyStrong}
+
+	var used []*sql.DB
+	read := func(fail error) error {
+		return s.read(context.Background(), "", func(db *gorm.DB) error {
+			used = append(used, db.DB())
+			if db.DB() == replicaDB.DB() {
+				return fail
+			}
+			return nil
+		})
+	}
+
+	// Query errors are returned from replica.
+	queryErr := &pq.Error{Code: "42P01"}
+	assert.Equal(t, queryErr, read(queryErr))
+	assert.Equal(t, []*sql.DB{replicaDB.DB()}, used)
+
+	// Unreachable replica is replaced by primary and skipped by next reads.
+	used = nil
+	assert.NoError(t, read(&net.OpError{Op: "dial", Err: errors.New("connection refused")}))
+	assert.Equal(t, []*sql.DB{replicaDB.DB(), primary.DB()}, used)
+	used = nil
+	assert.NoError(t, read(nil))
+	assert.Equal(t, []*sql.DB{primary.DB()}, used)
+}
+
+func TestService_ReadNotFoundOnReplica(t *testing.T) {
+	primary, replicaDB := unreachable(t), unreachable(t)
+	defer primary.Close()
+	defer replicaDB.Close()
+	s := &ServiceImpl{DB: primary, replica: &replica{db: replicaDB}, readConsistency: model.ConsistencyStrong}
+
+	var used []*sql.DB
+	err := s.read(context.Background(), model.ConsistencyStrong, func(db *gorm.DB) error {
+		used = append(used, db.DB())
+		if db.DB() == replicaDB.DB() {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+	// Message created just now is found on primary.
+	assert.NoError(t, err)
+	assert.Equal(t, []*sql.DB{replicaDB.DB(), primary.DB()}, used)
+	assert.True(t, s.replica.healthy(), "lagging replica is still used")
+}
+
+func TestIsConnectionError(t *testing.T) {
+	assert.True(t, isConnectionError(&net.OpError{Op: "dial", Err: errors.New("connection refused")}))
+	assert.True(t, isConnectionError(&pq.Error{Code: "08006"}))
+	assert.True(t, isConnectionError(&pq.Error{Code: "57P01"}))
+	assert.False(t, isConnectionError(&pq.Error{Code: "40001"}))
+	assert.False(t, isConnectionError(gorm.ErrRecordNotFound))
+}
+
+func TestService_StaleTimestamp(t *testing.T) {
+	assert.Equal(t, "follower_read_timestamp()", (&ServiceImpl{}).staleTimestamp())
+	assert.Equal(t, "'-10000ms'", (&ServiceImpl{readStaleness: 10 * time.Second}).staleTimestamp())
+}
@@ -2,73 +2,160 @@ package cockroach
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"io"
 	"math/rand"
+	"sync"
 	"time"
 
+	"github.com/jozuenoon/dunder/metrics"
 	"github.com/jozuenoon/dunder/model"
 
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/postgres"
 	"github.com/jozuenoon/dunder/repository"
+	"github.com/jozuenoon/dunder/tracing"
 	"github.com/oklog/ulid"
 )
 
 const (
-	defaultDatabase = "dunder"
-	defaultUser     = "api_service"
+	defaultDatabase          = "dunder"
+	defaultUser              = "api_service"
+	defaultPort              = 26257
+	defaultSSLMode           = "disable"
+	defaultIdempotencyWindow = 24 * time.Hour
 )
 
 type Config struct {
-	Host          string
-	ShouldMigrate bool
-	Debug         bool
-	Database      *string
-	User          *string
+	Host string
+	Port int
+	// MigrateOnStart applies pending schema migrations when service is created.
+	MigrateOnStart bool
+	Debug          bool
+	Database       *string
+	User           *string
+	// Password is read from PasswordFile when it's set, eg. mounted secret.
+	Password     string
+	PasswordFile string
+	// SSLMode is one of disable, require, verify-ca and verify-full. Client
+	// certificate authentication requires SSLCert and SSLKey.
+	SSLMode     string
+	SSLRootCert string
+	SSLCert     string
+	SSLKey      string
+	// DSN is full connection string overriding all connection options above.
+	DSN string
+	// ReadDSN is connection string of read replica serving Message, Messages
+	// and Trends. Reads fall back to primary while replica is unreachable.
+	ReadDSN string
+
+	// MaxOpenConns and MaxIdleConns limit connection pool, zero keeps database/sql
+	// defaults. Read replica pool has the same limits.
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+
+	// IdempotencyWindow is how long idempotency keys are remembered.
+	IdempotencyWindow time.Duration
+
+	// ReadConsistency is default consistency of Messages and Trends queries,
+	// model.ConsistencyStrong when empty.
+	ReadConsistency string
+	// ReadStaleness is how old are stale reads, zero uses follower_read_timestamp().
+	ReadStaleness time.Duration
+
+	// IDs caches ids of users and hashtags resolved when messages are created.
+	IDs repository.IDCache
 }
 
 func New(cfg *Config) (*ServiceImpl, error) {
 	t := time.Now()
 	entropy := ulid.Monotonic(rand.New(rand.NewSource(t.UnixNano())), 0)
 
-	db, err := newDatabase(cfg.Host, cfg.ShouldMigrate, cfg.Debug, cfg.Database, cfg.User)
+	db, err := newDatabase(cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	return &ServiceImpl{
-		DB:          db,
-		ulidEntropy: entropy,
-	}, nil
-}
+	window := cfg.IdempotencyWindow
+	if window == 0 {
+		window = defaultIdempotencyWindow
+	}
 
-func newDatabase(host string, shouldMigrate, debug bool, database, user *string) (*gorm.DB, error) {
-	dbName := defaultDatabase
-	if database != nil {
-		dbName = *database
+	consistency := cfg.ReadConsistency
+	if consistency == "" {
+		consistency = model.ConsistencyStrong
 	}
-	dbUser := defaultUser
-	if user != nil {
-		dbUser = *user
+
+	ids := cfg.IDs
+	if ids == nil {
+		ids = repository.NopIDCache{}
 	}
 
-	addr := fmt.Sprintf("postgresql://%s@%s:26257/%s?sslmode=disable", dbUser, host, dbName)
-	db, err := gorm.Open("postgres", addr)
+	s := &ServiceImpl{
+		DB:                db,
+		ids:               ids,
+		ulidEntropy:       entropy,
+		idempotencyWindow: window,
+		readConsistency:   consistency,
+		readStaleness:     cfg.ReadStaleness,
+	}
+	if cfg.ReadDSN != "" {
+		if s.replica, err = newReplica(cfg); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	if cfg.MigrateOnStart {
+		if _, err := s.MigrateUp(context.Background()); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func newDatabase(cfg *Config) (*gorm.DB, error) {
+	dsn, err := cfg.dsn()
 	if err != nil {
 		return nil, err
 	}
+	db, err := gorm.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	setupDatabase(db, cfg)
+	return db, nil
+}
 
-	db.LogMode(debug)
+// newReplica opens read replica pool. Replica unreachable on start doesn't
+// fail service, reads go to primary until it's back.
+func newReplica(cfg *Config) (*replica, error) {
+	pool, err := sql.Open("postgres", cfg.ReadDSN)
+	if err != nil {
+		return nil, err
+	}
+	r := &replica{}
+	if r.db, err = gorm.Open("postgres", pool); err != nil {
+		r.markDown()
+	}
+	setupDatabase(r.db, cfg)
+	return r, nil
+}
 
-	if shouldMigrate {
-		db.AutoMigrate(&repository.User{})
-		db.AutoMigrate(&repository.Message{})
-		db.AutoMigrate(&repository.Hashtag{})
-		db.AutoMigrate(&repository.Trend{})
+func setupDatabase(db *gorm.DB, cfg *Config) {
+	pool := db.DB()
+	if cfg.MaxOpenConns > 0 {
+		pool.SetMaxOpenConns(cfg.MaxOpenConns)
 	}
+	if cfg.MaxIdleConns > 0 {
+		pool.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+	pool.SetConnMaxLifetime(cfg.ConnMaxLifetime)
 
-	return db, nil
+	db.LogMode(cfg.Debug)
+	registerTracing(db)
 }
 
 var _ repository.Service = (*ServiceImpl)(nil)
@@ -76,30 +163,156 @@ var _ repository.Service = (*ServiceImpl)(nil)
 type ServiceImpl struct {
 	DB          *gorm.DB
 	ulidEntropy io.Reader
+	entropyMu   sync.Mutex
+
+	idempotencyWindow time.Duration
+	readConsistency   string
+	readStaleness     time.Duration
+	replica           *replica
+	ids               repository.IDCache
+}
+
+// newULID generates ulid for given time, monotonic entropy source is not safe for concurrent use.
+func (s *ServiceImpl) newULID(t time.Time) (ulid.ULID, error) {
+	s.entropyMu.Lock()
+	defer s.entropyMu.Unlock()
+	return ulid.New(ulid.Timestamp(t), s.ulidEntropy)
 }
 
-func (s *ServiceImpl) Message(ctx context.Context, ulid string) (*repository.Message, error) {
-	var resp repository.Message
-	return &resp, s.DB.Where("ulid = ?", ulid).Preload("User").Preload("Hashtags").First(&resp).Error
+// ReadReplica returns connection pool of read replica, nil when it's not configured.
+func (s *ServiceImpl) ReadReplica() *sql.DB {
+	if s.replica == nil {
+		return nil
+	}
+	return s.replica.db.DB()
 }
 
-func (s *ServiceImpl) getUserByName(db *gorm.DB, name string) (*repository.User, error) {
-	user := &repository.User{
-		Name: &name,
+// Close releases database connections.
+func (s *ServiceImpl) Close() error {
+	if s.replica != nil {
+		s.replica.db.Close()
 	}
-	if err := db.Where("name = ?", name).FirstOrCreate(user).Error; err != nil {
+	return s.DB.Close()
+}
+
+func (s *ServiceImpl) HealthCheck(ctx context.Context) (*repository.HealthStatus, error) {
+	if err := s.DB.DB().PingContext(ctx); err != nil {
 		return nil, err
 	}
-	return user, nil
+	// Missing schema table means no migration was applied yet.
+	migration := repository.MigrationPending
+	if version, err := s.schemaVersion(ctx); err == nil && version >= latestVersion() {
+		migration = repository.MigrationCurrent
+	}
+	return &repository.HealthStatus{
+		Migration: migration,
+	}, nil
 }
 
-func (s *ServiceImpl) getHashtagsByText(db *gorm.DB, texts []string) ([]*repository.Hashtag, error) {
+func (s *ServiceImpl) Message(ctx context.Context, ulid string) (_ *repository.Message, err error) {
+	ctx, span := tracer.Start(ctx, "cockroach.Message")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	var resp *repository.Message
+	err = s.read(ctx, model.ConsistencyStrong, func(db *gorm.DB) error {
+		msgs, err := findMessages(db, messagesQuery(db).Where("messages.ulid = ?", ulid))
+		if err != nil {
+			return err
+		}
+		if len(msgs) == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		resp = msgs[0]
+		return nil
+	})
+	return resp, err
+}
+
+// getUserByName returns user with given name, creating it when missing.
+func (s *ServiceImpl) getUserByName(db *gorm.DB, name string) (*repository.User, bool, error) {
+	if id, ok := s.ids.UserID(name); ok {
+		return &repository.User{ID: id, Name: &name}, false, nil
+	}
+	user := &repository.User{}
+	err := db.Where("name = ?", name).First(user).Error
+	if err == nil {
+		return user, false, nil
+	}
+	if !gorm.IsRecordNotFoundError(err) {
+		return nil, false, err
+	}
+	user.Name = &name
+	if err := db.Create(user).Error; err != nil {
+		return nil, false, err
+	}
+	return user, true, nil
+}
+
+// getUsersByName returns users by name, creating missing ones. Created users
+// are returned along.
+func (s *ServiceImpl) getUsersByName(db *gorm.DB, names []string) (map[string]*repository.User, []*repository.User, error) {
+	users := make(map[string]*repository.User, len(names))
+	var uncached []string
+	for _, name := range names {
+		if id, ok := s.ids.UserID(name); ok {
+			n := name
+			users[name] = &repository.User{ID: id, Name: &n}
+		} else {
+			uncached = append(uncached, name)
+		}
+	}
+	if len(uncached) == 0 {
+		return users, nil, nil
+	}
+
+	var stored []*repository.User
+	if err := db.Where("name IN (?)", uncached).Find(&stored).Error; err != nil {
+		return nil, nil, err
+	}
+	for _, user := range stored {
+		users[*user.Name] = user
+	}
+
+	var created []*repository.User
+	for _, name := range uncached {
+		if _, ok := users[name]; ok {
+			continue
+		}
+		n := name
+		user := &repository.User{Name: &n}
+		if err := db.Create(user).Error; err != nil {
+			return nil, nil, err
+		}
+		users[name] = user
+		created = append(created, user)
+	}
+	return users, created, nil
+}
+
+// getHashtagsByText returns hashtags matching texts, creating missing ones. Created
+// hashtags are returned along.
+func (s *ServiceImpl) getHashtagsByText(db *gorm.DB, texts []string) ([]*repository.Hashtag, []*repository.Hashtag, error) {
 	var hashtags []*repository.Hashtag
-	if err := db.Where("text IN (?)", texts).Find(&hashtags).Error; err != nil {
-		return nil, err
+	var uncached []string
+	for _, txt := range texts {
+		if id, ok := s.ids.HashtagID(txt); ok {
+			tt := txt
+			hashtags = append(hashtags, &repository.Hashtag{ID: id, Text: &tt})
+		} else {
+			uncached = append(uncached, txt)
+		}
+	}
+	if len(uncached) == 0 {
+		return hashtags, nil, nil
+	}
+
+	var stored []*repository.Hashtag
+	if err := db.Where("text IN (?)", uncached).Find(&stored).Error; err != nil {
+		return nil, nil, err
 	}
+	hashtags = append(hashtags, stored...)
 	if len(hashtags) == len(texts) {
-		return hashtags, nil
+		return hashtags, nil, nil
 	}
 
 	found := func(txt string) bool {
@@ -119,99 +332,158 @@ func (s *ServiceImpl) getHashtagsByText(db *gorm.DB, texts []string) ([]*reposit
 				Text: &tt,
 			}
 			if err := db.Create(ctag).Error; err != nil {
-				return nil, err
+				return nil, nil, err
 			}
 			missingTags = append(missingTags, ctag)
 		}
 	}
 
-	return append(hashtags, missingTags...), nil
+	return append(hashtags, missingTags...), missingTags, nil
 }
 
 func (s *ServiceImpl) CreateMessage(ctx context.Context, req *repository.CreateMessageRequest) (mID string, err error) {
-	t := time.Now()
-	u, err := ulid.New(ulid.Timestamp(t), s.ulidEntropy)
-	if err != nil {
-		return "", err
+	ctx, span := tracer.Start(ctx, "cockroach.CreateMessage")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	// Message time may be set by import, events and idempotency keys are
+	// always recorded now, so event log followers don't skip them.
+	now := time.Now()
+	t := now
+	if !req.CreatedAt.IsZero() {
+		t = req.CreatedAt
 	}
-	tx := s.DB.Begin()
-	defer func() {
+	us := req.Ulid
+	if us == "" {
+		u, err := s.newULID(t)
 		if err != nil {
-			tx.Rollback()
+			return "", err
 		}
-	}()
-	user, err := s.getUserByName(tx, req.UserName)
-	if err != nil {
-		return "", err
+		us = u.String()
 	}
+	var (
+		user     *repository.User
+		hashtags []*repository.Hashtag
+		created  []*repository.Hashtag
+	)
+	err = s.runInTx(ctx, func(tx *gorm.DB) error {
+		var userCreated bool
+		var err error
+		user, userCreated, err = s.getUserByName(tx, req.UserName)
+		if err != nil {
+			return err
+		}
+		if userCreated {
+			if err := s.appendEvent(tx, now, model.EventUserCreated, *user.Name, &model.Event{
+				User: &model.User{ID: user.ID, Name: *user.Name},
+			}); err != nil {
+				return err
+			}
+		}
 
-	hashtags, err := s.getHashtagsByText(tx, req.Hashtags)
-	if err != nil {
-		return "", err
-	}
+		// Repeated hashtags would count message twice in trends.
+		hashtags, created, err = s.getHashtagsByText(tx, unique(req.Hashtags))
+		if err != nil {
+			return err
+		}
 
-	if err := trendsUpdate(tx, t, hashtags); err != nil {
-		return "", err
-	}
+		for _, tag := range created {
+			if err := s.appendEvent(tx, now, model.EventHashtagCreated, *tag.Text, &model.Event{
+				Hashtag: *tag.Text,
+			}); err != nil {
+				return err
+			}
+		}
 
-	us := u.String()
-	message := &repository.Message{
-		CreatedAt: t,
-		UpdatedAt: t,
-		Ulid:      &us,
-		UserRef:   user.ID,
-		Text:      req.Text,
-		Hashtags:  hashtags,
-	}
+		if err := trendsUpdate(tx, t, hashtags); err != nil {
+			return err
+		}
+
+		message := &repository.Message{
+			CreatedAt: t,
+			UpdatedAt: t,
+			Ulid:      &us,
+			UserRef:   user.ID,
+			Text:      req.Text,
+			Hashtags:  hashtags,
+		}
 
-	if result := tx.Create(message); result.Error != nil {
-		return "", result.Error
+		if result := tx.Create(message); result.Error != nil {
+			return result.Error
+		}
+		if result := tx.Save(message); result.Error != nil {
+			return result.Error
+		}
+		if req.IdempotencyKey != "" {
+			if err := s.storeIdempotencyKey(tx, now, req.UserName, req.IdempotencyKey, us); err != nil {
+				return err
+			}
+		}
+		return s.appendEvent(tx, now, model.EventMessageCreated, us, &model.Event{
+			Message: &model.Message{
+				ID:        us,
+				User:      model.User{ID: user.ID, Name: *user.Name},
+				Text:      req.Text,
+				Hashtags:  hashtagTexts(hashtags),
+				CreatedAt: t,
+			},
+		})
+	})
+	if err != nil {
+		return "", err
 	}
-	if result := tx.Save(message); result.Error != nil {
-		return "", result.Error
+	// Ids are cached only after commit, rows created by rolled back attempt don't exist.
+	s.ids.AddUser(*user.Name, user.ID)
+	for _, tag := range hashtags {
+		s.ids.AddHashtag(*tag.Text, tag.ID)
 	}
-	tx.Commit()
-	return *message.Ulid, nil
+	metrics.HashtagsCreated(len(created))
+	return us, nil
 }
 
-func (s *ServiceImpl) Messages(ctx context.Context, filter repository.Filter) ([]*repository.Message, error) {
+func (s *ServiceImpl) Messages(ctx context.Context, filter repository.Filter) (_ []*repository.Message, err error) {
+	ctx, span := tracer.Start(ctx, "cockroach.Messages")
+	defer func() { tracing.EndSpan(span, err) }()
+
 	var resp []*repository.Message
 
 	if filter.IsAggregateQuery() {
 		return nil, fmt.Errorf("can't handle aggregate query")
 	}
 
-	query := s.DB.Limit(filter.GetLimit()).Order("ulid desc")
+	err = s.read(ctx, filter.GetConsistency(), func(db *gorm.DB) error {
+		query := messagesQuery(db).Limit(filter.GetLimit()).Order("messages.ulid desc")
 
-	switch {
-	case filter.IsCursorQuery():
-		query = query.Where("ulid < ?", filter.GetCursor())
-	case filter.IsDateRangeQuery():
-		query = query.Where("created_at > ?", filter.GetFromDate()).
-			Where("created_at < ?", filter.GetToDate())
-	}
+		switch {
+		case filter.IsCursorQuery():
+			query = query.Where("messages.ulid < ?", filter.GetCursor())
+		case filter.IsDateRangeQuery():
+			query = query.Where("messages.created_at > ?", filter.GetFromDate()).
+				Where("messages.created_at < ?", filter.GetToDate())
+		}
 
-	if filter.IsUserQuery() {
-		var user repository.User
-		s.DB.Where("name = ?", filter.GetUserName()).First(&user)
-		query = query.Where("user_ref = ?", user.ID)
-	}
+		if filter.IsUserQuery() {
+			query = query.Where("users.name = ?", filter.GetUserName())
+		}
 
-	if filter.IsHashtagsQuery() {
-		var tag repository.Hashtag
-		s.DB.Where("text = ?", filter.GetHashtag()).Find(&tag)
-		query = query.Joins("JOIN message_hashtags on message_hashtags.message_id = messages.id").
-			Where("message_hashtags.hashtag_id = ?", tag.ID)
-	}
+		if filter.IsHashtagsQuery() {
+			query = withHashtag(query, filter.GetHashtag())
+		}
 
-	return resp, query.Preload("User").Preload("Hashtags").Find(&resp).Error
+		var err error
+		resp, err = findMessages(db, query)
+		return err
+	})
+	return resp, err
 }
 
 const (
 	minute = 60
 )
 
-func (s *ServiceImpl) Trends(ctx context.Context, filter repository.Filter) (*repository.MessagesAggregate, error) {
+func (s *ServiceImpl) Trends(ctx context.Context, filter repository.Filter) (_ *repository.MessagesAggregate, err error) {
+	ctx, span := tracer.Start(ctx, "cockroach.Trends")
+	defer func() { tracing.EndSpan(span, err) }()
+
 	if !filter.IsAggregateQuery() {
 		return nil, fmt.Errorf("expected aggregate filter query, possibly missing `aggregate` query option")
 	}
@@ -223,50 +495,71 @@ func (s *ServiceImpl) Trends(ctx context.Context, filter repository.Filter) (*re
 	fromBoundary := filter.GetFromDate().Unix() / minute
 	toBoundary := filter.GetToDate().Unix() / minute
 
-	query := s.DB.Table("trends").
-		Select("floor(bucket/?) as bbucket,sum(count)", bucketSize).
-		Where("bucket > ?", fromBoundary).
-		Where("bucket < ?", toBoundary).
-		Order("bbucket").
-		Group("1")
-
-	if filter.IsHashtagsQuery() {
-		var tag repository.Hashtag
-		s.DB.Where("text = ?", filter.GetHashtag()).Find(&tag)
-		query = query.Where("hashtag_ref = ?", tag.ID)
-	}
+	var trends []*model.Trend
+	err = s.read(ctx, filter.GetConsistency(), func(db *gorm.DB) error {
+		trends = nil
+		query := db.Table("trends").
+			Select("floor(bucket/?) as bbucket,sum(count)", bucketSize).
+			Where("bucket > ?", fromBoundary).
+			Where("bucket < ?", toBoundary).
+			// Deleted messages may leave empty buckets behind.
+			Where("count > 0").
+			Order("bbucket").
+			Group("1")
+
+		if filter.IsHashtagsQuery() {
+			query = query.Joins("JOIN hashtags ON hashtags.id = trends.hashtag_ref").
+				Where("hashtags.text = ?", filter.GetHashtag())
+		}
 
-	rows, err := query.Rows()
+		rows, err := query.Rows()
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var raw rawTrend
+			if err := rows.Scan(&raw.Bucket, &raw.Count); err != nil {
+				return err
+			}
+			fromDate := time.Unix(raw.Bucket*bucketSize*minute, 0)
+			toDate := fromDate.Add(time.Second * time.Duration(bucketSize*minute))
+			trends = append(trends, &model.Trend{
+				FromDate: fromDate,
+				ToDate:   toDate,
+				Count:    raw.Count,
+			})
+		}
+		return rows.Err()
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-	var trends []*model.Trend
-	for rows.Next() {
-		var raw rawTrend
-		if err := rows.Scan(&raw.Bucket, &raw.Count); err != nil {
-			return nil, err
-		}
-		fromDate := time.Unix(raw.Bucket*bucketSize*minute, 0)
-		toDate := fromDate.Add(time.Second * time.Duration(bucketSize*minute))
-		trends = append(trends, &model.Trend{
-			FromDate: fromDate,
-			ToDate:   toDate,
-			Count:    raw.Count,
-		})
-	}
 
 	return &repository.MessagesAggregate{Trends: trends}, nil
 }
 
+func hashtagTexts(tags []*repository.Hashtag) []string {
+	texts := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		texts = append(texts, *tag.Text)
+	}
+	return texts
+}
+
 type rawTrend struct {
 	Bucket int64
 	Count  uint
 }
 
+// bucketOf returns minute bucket of trends containing t.
+func bucketOf(t time.Time) uint {
+	return uint(t.Unix() / minute)
+}
+
 // trendsUpdate - creates or updates bucket_hashtag entry.
 func trendsUpdate(db *gorm.DB, t time.Time, tags []*repository.Hashtag) error {
-	bucket := uint(t.Unix() / 60)
+	bucket := bucketOf(t)
 	for _, tag := range tags {
 		trend := &repository.Trend{
 			Bucket:     bucket,
@@ -282,3 +575,19 @@ func trendsUpdate(db *gorm.DB, t time.Time, tags []*repository.Hashtag) error {
 	}
 	return nil
 }
+
+// trendKey identifies trends row.
+type trendKey struct {
+	bucket     uint
+	hashtagRef uint
+}
+
+// trendsBatchUpdate adds counts to trends rows with single statement.
+func trendsBatchUpdate(db *gorm.DB, counts map[trendKey]uint) error {
+	rows := make([][]interface{}, 0, len(counts))
+	for key, count := range counts {
+		rows = append(rows, []interface{}{key.bucket, key.hashtagRef, count})
+	}
+	return insertRows(db, "trends", []string{"bucket", "hashtag_ref", "count"}, rows,
+		" ON CONFLICT (bucket, hashtag_ref) DO UPDATE SET count = trends.count + excluded.count")
+}
@@ -5,18 +5,30 @@ package cockroach
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"math/rand"
 	"os"
 	"os/exec"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/jinzhu/gorm"
 	"github.com/jozuenoon/dunder/model"
 
 	"github.com/jozuenoon/dunder/repository"
+	"github.com/jozuenoon/dunder/snapshot"
+	"github.com/oklog/ulid"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func createDb(database string) error {
@@ -73,11 +85,11 @@ func TestSimpleInsertAndGet(t *testing.T) {
 	user := "root"
 
 	svc, err := New(&Config{
-		Host:          getDBHost(),
-		ShouldMigrate: true,
-		Debug:         false,
-		Database:      &database,
-		User:          &user,
+		Host:           getDBHost(),
+		MigrateOnStart: true,
+		Debug:          false,
+		Database:       &database,
+		User:           &user,
 	})
 	if err != nil {
 		t.Fatal("failed to create service")
@@ -114,11 +126,11 @@ func TestService_CreateMessage_Message(t *testing.T) {
 	user := "root"
 
 	svc, err := New(&Config{
-		Host:          getDBHost(),
-		ShouldMigrate: true,
-		Debug:         true,
-		Database:      &database,
-		User:          &user,
+		Host:           getDBHost(),
+		MigrateOnStart: true,
+		Debug:          true,
+		Database:       &database,
+		User:           &user,
 	})
 	if err != nil {
 		t.Fatal("failed to create service")
@@ -197,11 +209,11 @@ func TestSimpleFilter(t *testing.T) {
 	user := "root"
 
 	svc, err := New(&Config{
-		Host:          getDBHost(),
-		ShouldMigrate: true,
-		Debug:         false,
-		Database:      &database,
-		User:          &user,
+		Host:           getDBHost(),
+		MigrateOnStart: true,
+		Debug:          false,
+		Database:       &database,
+		User:           &user,
 	})
 	if err != nil {
 		t.Fatal("failed to create service")
@@ -314,11 +326,11 @@ func TestSimpleTrends(t *testing.T) {
 	user := "root"
 
 	svc, err := New(&Config{
-		Host:          getDBHost(),
-		ShouldMigrate: true,
-		Debug:         true,
-		Database:      &database,
-		User:          &user,
+		Host:           getDBHost(),
+		MigrateOnStart: true,
+		Debug:          true,
+		Database:       &database,
+		User:           &user,
 	})
 	if err != nil {
 		t.Fatal("failed to create service")
@@ -344,3 +356,825 @@ func TestSimpleTrends(t *testing.T) {
 		fmt.Println(r.FromDate, r.ToDate, r.Count)
 	}
 }
+
+func TestOutbox(t *testing.T) {
+	database := fmt.Sprintf("test_%d", rand.Intn(1000))
+	t.Log("using database: ", database)
+	err := createDb(database)
+	if err != nil {
+		t.Fatalf("failed to create database: %s", err)
+	}
+	defer dropDb(t, database)
+	user := "root"
+
+	svc, err := New(&Config{
+		Host:           getDBHost(),
+		MigrateOnStart: true,
+		Debug:          false,
+		Database:       &database,
+		User:           &user,
+	})
+	if err != nil {
+		t.Fatal("failed to create service")
+	}
+
+	// Second message reuses user and one hashtag.
+	reqs := []*repository.CreateMessageRequest{
+		{UserName: "john@example.com", Text: "my dummy text 1", Hashtags: []string{"atwork"}},
+		{UserName: "john@example.com", Text: "my dummy text 2", Hashtags: []string{"atwork", "drift"}},
+	}
+	var ids []string
+	for _, req := range reqs {
+		id, err := svc.CreateMessage(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	events, err := svc.PendingEvents(context.Background(), 10)
+	assert.NoError(t, err, "failed to read pending events")
+	var types []string
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	assert.Equal(t, []string{
+		model.EventUserCreated, model.EventHashtagCreated, model.EventMessageCreated,
+		model.EventHashtagCreated, model.EventMessageCreated,
+	}, types)
+	assert.Equal(t, ids[1], events[4].AggregateID)
+
+	assert.NoError(t, svc.MarkPublished(context.Background(), []uint{events[0].ID, events[1].ID}))
+	pending, err := svc.PendingEvents(context.Background(), 10)
+	assert.NoError(t, err, "failed to read pending events")
+	assert.Len(t, pending, 3)
+
+	log, err := svc.Events(context.Background(), *events[2].Ulid, 10)
+	assert.NoError(t, err, "failed to read event log")
+	assert.Len(t, log, 2)
+}
+
+func TestEditDeleteMessage(t *testing.T) {
+	database := fmt.Sprintf("test_%d", rand.Intn(1000))
+	t.Log("using database: ", database)
+	err := createDb(database)
+	if err != nil {
+		t.Fatalf("failed to create database: %s", err)
+	}
+	defer dropDb(t, database)
+	user := "root"
+
+	svc, err := New(&Config{
+		Host:           getDBHost(),
+		MigrateOnStart: true,
+		Debug:          false,
+		Database:       &database,
+		User:           &user,
+	})
+	if err != nil {
+		t.Fatal("failed to create service")
+	}
+	ctx := context.Background()
+
+	var ids []string
+	for _, text := range []string{"edited", "deleted", "archived"} {
+		id, err := svc.CreateMessage(ctx, &repository.CreateMessageRequest{UserName: "john", Text: text, Hashtags: []string{"drift"}})
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+
+	// Messages of other users are not found.
+	assert.Equal(t, gorm.ErrRecordNotFound, svc.EditMessage(ctx, &repository.EditMessageRequest{UserName: "ala", Ulid: ids[0], Text: "x"}))
+	assert.Equal(t, gorm.ErrRecordNotFound, svc.DeleteMessage(ctx, "ala", ids[1]))
+
+	require.NoError(t, svc.EditMessage(ctx, &repository.EditMessageRequest{UserName: "john", Ulid: ids[0], Text: "fixed"}))
+	msg, err := svc.Message(ctx, ids[0])
+	require.NoError(t, err)
+	assert.Equal(t, "fixed", msg.Text)
+	assert.Equal(t, []string{"drift"}, extractTagText(msg.Hashtags))
+
+	require.NoError(t, svc.DeleteMessage(ctx, "john", ids[1]))
+	_, err = svc.Message(ctx, ids[1])
+	assert.Equal(t, gorm.ErrRecordNotFound, err)
+	assert.Equal(t, gorm.ErrRecordNotFound, svc.DeleteMessage(ctx, "john", ids[1]))
+
+	trends, err := svc.Trends(ctx, &repository.FilterImpl{QueryRequest: model.QueryRequest{
+		FromDate: []time.Time{time.Now().Add(-time.Hour)},
+		ToDate:   []time.Time{time.Now().Add(time.Hour)},
+		Rules:    model.QueryRules{Aggregation: []time.Duration{24 * time.Hour}, Hashtag: []string{"drift"}},
+	}})
+	require.NoError(t, err)
+	var count uint
+	for _, tr := range trends.Trends {
+		count += tr.Count
+	}
+	assert.Equal(t, uint(2), count)
+
+	require.NoError(t, svc.DeleteMessages(ctx, []string{ids[2]}))
+
+	events, err := svc.Events(ctx, "", 100)
+	require.NoError(t, err)
+	var changes []string
+	for _, e := range events {
+		if e.Type != model.EventMessageCreated && strings.HasPrefix(e.Type, "message.") {
+			var event model.Event
+			require.NoError(t, json.Unmarshal([]byte(e.Payload), &event))
+			changes = append(changes, e.Type+" "+event.Message.Text)
+			assert.Equal(t, "john", event.Message.User.Name)
+			assert.Equal(t, []string{"drift"}, event.Message.Hashtags)
+		}
+	}
+	assert.Equal(t, []string{
+		model.EventMessageEdited + " fixed",
+		model.EventMessageDeleted + " deleted",
+		model.EventMessageArchived + " archived",
+	}, changes)
+}
+
+func TestWebhookJobs(t *testing.T) {
+	database := fmt.Sprintf("test_%d", rand.Intn(1000))
+	t.Log("using database: ", database)
+	err := createDb(database)
+	if err != nil {
+		t.Fatalf("failed to create database: %s", err)
+	}
+	defer dropDb(t, database)
+	user := "root"
+
+	svc, err := New(&Config{
+		Host:           getDBHost(),
+		MigrateOnStart: true,
+		Debug:          false,
+		Database:       &database,
+		User:           &user,
+	})
+	if err != nil {
+		t.Fatal("failed to create service")
+	}
+	ctx := context.Background()
+
+	var subs []*repository.WebhookSubscription
+	for _, url := range []string{"http://example.com/a", "http://example.com/b"} {
+		sub, err := svc.CreateWebhook(ctx, &repository.CreateWebhookRequest{Owner: "john", URL: url, Secret: "s3cret"})
+		require.NoError(t, err)
+		subs = append(subs, sub)
+	}
+
+	now := time.Now()
+	jobs := []*repository.WebhookJob{
+		{CreatedAt: now, SubscriptionRef: subs[0].ID, EventID: "01DQ1", EventType: model.EventMessageCreated, Payload: "{}", NextAttemptAt: now},
+		{CreatedAt: now, SubscriptionRef: subs[1].ID, EventID: "01DQ1", EventType: model.EventMessageCreated, Payload: "{}", NextAttemptAt: now},
+	}
+	require.NoError(t, svc.EnqueueDeliveries(ctx, jobs))
+	// Republished event is not scheduled twice.
+	require.NoError(t, svc.EnqueueDeliveries(ctx, jobs[:1]))
+
+	due, err := svc.DueDeliveries(ctx, now, time.Minute, 10)
+	require.NoError(t, err)
+	require.Len(t, due, 2)
+	urls := map[string]uint{}
+	for _, j := range due {
+		assert.Equal(t, "s3cret", j.Subscription.Secret)
+		urls[j.Subscription.URL] = j.ID
+	}
+	require.Len(t, urls, 2)
+
+	// Claimed jobs are due only after lease passes.
+	due, err = svc.DueDeliveries(ctx, now.Add(time.Second), time.Minute, 10)
+	require.NoError(t, err)
+	assert.Empty(t, due)
+
+	require.NoError(t, svc.RescheduleDelivery(ctx, urls["http://example.com/a"], 1, now.Add(time.Second)))
+	require.NoError(t, svc.DeleteWebhook(ctx, "john", *subs[1].Ulid))
+	due, err = svc.DueDeliveries(ctx, now.Add(2*time.Minute), time.Minute, 10)
+	require.NoError(t, err)
+	// Job of deleted subscription is dropped.
+	require.Len(t, due, 1)
+	assert.Equal(t, subs[0].ID, due[0].SubscriptionRef)
+	assert.Equal(t, 1, due[0].Attempt)
+
+	require.NoError(t, svc.CompleteDelivery(ctx, due[0].ID))
+	due, err = svc.DueDeliveries(ctx, now.Add(time.Hour), time.Minute, 10)
+	require.NoError(t, err)
+	assert.Empty(t, due)
+}
+
+func TestCreateMessage_RepeatedHashtags(t *testing.T) {
+	database := fmt.Sprintf("test_%d", rand.Intn(1000))
+	t.Log("using database: ", database)
+	err := createDb(database)
+	if err != nil {
+		t.Fatalf("failed to create database: %s", err)
+	}
+	defer dropDb(t, database)
+	user := "root"
+
+	svc, err := New(&Config{
+		Host:           getDBHost(),
+		MigrateOnStart: true,
+		Debug:          false,
+		Database:       &database,
+		User:           &user,
+	})
+	if err != nil {
+		t.Fatal("failed to create service")
+	}
+	ctx := context.Background()
+
+	// Second message finds hashtag ids in cache.
+	var ids []string
+	for i := 0; i < 2; i++ {
+		id, err := svc.CreateMessage(ctx, &repository.CreateMessageRequest{UserName: "john", Text: "text", Hashtags: []string{"go", "go"}})
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+	for _, id := range ids {
+		msg, err := svc.Message(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"go"}, extractTagText(msg.Hashtags))
+	}
+
+	trends, err := svc.Trends(ctx, &repository.FilterImpl{QueryRequest: model.QueryRequest{
+		FromDate: []time.Time{time.Now().Add(-time.Hour)},
+		ToDate:   []time.Time{time.Now().Add(time.Hour)},
+		Rules:    model.QueryRules{Aggregation: []time.Duration{24 * time.Hour}, Hashtag: []string{"go"}},
+	}})
+	require.NoError(t, err)
+	var count uint
+	for _, tr := range trends.Trends {
+		count += tr.Count
+	}
+	assert.Equal(t, uint(2), count)
+}
+
+func TestIdempotentMessage(t *testing.T) {
+	database := fmt.Sprintf("test_%d", rand.Intn(1000))
+	t.Log("using database: ", database)
+	err := createDb(database)
+	if err != nil {
+		t.Fatalf("failed to create database: %s", err)
+	}
+	defer dropDb(t, database)
+	user := "root"
+
+	svc, err := New(&Config{
+		Host:           getDBHost(),
+		MigrateOnStart: true,
+		Debug:          false,
+		Database:       &database,
+		User:           &user,
+	})
+	if err != nil {
+		t.Fatal("failed to create service")
+	}
+
+	ctx := context.Background()
+	id, err := svc.CreateMessage(ctx, &repository.CreateMessageRequest{
+		UserName: "john@example.com", Text: "deploy done", IdempotencyKey: "deploy-1",
+	})
+	assert.NoError(t, err, "failed to create message")
+
+	found, err := svc.IdempotentMessage(ctx, "john@example.com", "deploy-1")
+	assert.NoError(t, err)
+	assert.Equal(t, id, found)
+
+	// Keys are scoped per user.
+	_, err = svc.IdempotentMessage(ctx, "jane@example.com", "deploy-1")
+	assert.Equal(t, gorm.ErrRecordNotFound, err)
+
+	// Same key can't be stored twice.
+	_, err = svc.CreateMessage(ctx, &repository.CreateMessageRequest{
+		UserName: "john@example.com", Text: "deploy done", IdempotencyKey: "deploy-1",
+	})
+	assert.Error(t, err)
+}
+
+func TestRunInTx_Retry(t *testing.T) {
+	database := fmt.Sprintf("test_%d", rand.Intn(1000))
+	t.Log("using database: ", database)
+	err := createDb(database)
+	if err != nil {
+		t.Fatalf("failed to create database: %s", err)
+	}
+	defer dropDb(t, database)
+	user := "root"
+
+	svc, err := New(&Config{
+		Host:           getDBHost(),
+		MigrateOnStart: true,
+		Debug:          false,
+		Database:       &database,
+		User:           &user,
+	})
+	if err != nil {
+		t.Fatal("failed to create service")
+	}
+
+	// force_retry returns serialization error until transaction is older than given interval.
+	var attempts int
+	err = svc.runInTx(context.Background(), func(tx *gorm.DB) error {
+		attempts++
+		name := "retried"
+		if err := tx.Create(&repository.User{Name: &name}).Error; err != nil {
+			return err
+		}
+		return tx.Exec("SELECT crdb_internal.force_retry('50ms')").Error
+	})
+	assert.NoError(t, err)
+	assert.True(t, attempts > 1, "expected transaction to be retried")
+
+	var count int
+	assert.NoError(t, svc.DB.Model(&repository.User{}).Where("name = ?", "retried").Count(&count).Error)
+	assert.Equal(t, 1, count, "rolled back attempts must not leave rows")
+
+	err = svc.runInTx(context.Background(), func(tx *gorm.DB) error {
+		return tx.Exec("SELECT crdb_internal.force_retry('1h')").Error
+	})
+	assert.True(t, errors.Is(err, ErrTxRetriesExhausted))
+}
+
+func TestTracing(t *testing.T) {
+	database := fmt.Sprintf("test_%d", rand.Intn(1000))
+	t.Log("using database: ", database)
+	err := createDb(database)
+	if err != nil {
+		t.Fatalf("failed to create database: %s", err)
+	}
+	defer dropDb(t, database)
+	user := "root"
+
+	svc, err := New(&Config{
+		Host:           getDBHost(),
+		MigrateOnStart: true,
+		Debug:          false,
+		Database:       &database,
+		User:           &user,
+	})
+	if err != nil {
+		t.Fatal("failed to create service")
+	}
+
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	_, err = svc.CreateMessage(context.Background(), &repository.CreateMessageRequest{
+		UserName: "john@example.com", Text: "traced", Hashtags: []string{"otel"},
+	})
+	assert.NoError(t, err)
+
+	var parent trace.SpanID
+	statements := map[trace.SpanID]int{}
+	for _, span := range recorder.Ended() {
+		if span.Name() == "cockroach.CreateMessage" {
+			parent = span.SpanContext().SpanID()
+		}
+		if strings.HasPrefix(span.Name(), "sql.") {
+			statements[span.Parent().SpanID()]++
+		}
+	}
+	assert.True(t, parent.IsValid(), "expected repository span")
+	assert.True(t, statements[parent] > 0, "expected statement spans under repository span")
+
+	// Transaction of cancelled request is rolled back.
+	ctx, cancel := context.WithCancel(context.Background())
+	err = svc.runInTx(ctx, func(tx *gorm.DB) error {
+		name := "cancelled"
+		if err := tx.Create(&repository.User{Name: &name}).Error; err != nil {
+			return err
+		}
+		cancel()
+		return nil
+	})
+	assert.Error(t, err)
+	var count int
+	assert.NoError(t, svc.DB.Model(&repository.User{}).Where("name = ?", "cancelled").Count(&count).Error)
+	assert.Equal(t, 0, count)
+}
+
+func TestMigrations(t *testing.T) {
+	database := fmt.Sprintf("test_%d", rand.Intn(1000))
+	t.Log("using database: ", database)
+	err := createDb(database)
+	if err != nil {
+		t.Fatalf("failed to create database: %s", err)
+	}
+	defer dropDb(t, database)
+	user := "root"
+
+	cfg := &Config{
+		Host:     getDBHost(),
+		Database: &database,
+		User:     &user,
+	}
+	svc, err := New(cfg)
+	if err != nil {
+		t.Fatal("failed to create service")
+	}
+	ctx := context.Background()
+
+	status, err := svc.HealthCheck(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, repository.MigrationPending, status.Migration)
+
+	// Replicas starting at once apply each migration once.
+	var wg sync.WaitGroup
+	applied := make([][]*MigrationStatus, 3)
+	for i := range applied {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			replica, err := New(cfg)
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer replica.Close()
+			applied[i], err = replica.MigrateUp(ctx)
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+	var total int
+	for _, a := range applied {
+		total += len(a)
+	}
+	assert.Equal(t, len(migrations), total)
+
+	status, err = svc.HealthCheck(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, repository.MigrationCurrent, status.Migration)
+
+	reverted, err := svc.MigrateDown(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, latestVersion(), reverted.Version)
+
+	statuses, err := svc.Migrations(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, statuses, len(migrations))
+	assert.Nil(t, statuses[len(statuses)-1].AppliedAt)
+	assert.NotNil(t, statuses[0].AppliedAt)
+
+	again, err := svc.MigrateUp(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, again, 1)
+
+	// Down migrations revert whole schema.
+	for range migrations {
+		_, err := svc.MigrateDown(ctx)
+		assert.NoError(t, err)
+	}
+	reverted, err = svc.MigrateDown(ctx)
+	assert.NoError(t, err)
+	assert.Nil(t, reverted)
+	assert.False(t, svc.DB.HasTable("messages"))
+}
+
+func TestMigrationLock(t *testing.T) {
+	database := fmt.Sprintf("test_%d", rand.Intn(1000))
+	t.Log("using database: ", database)
+	err := createDb(database)
+	if err != nil {
+		t.Fatalf("failed to create database: %s", err)
+	}
+	defer dropDb(t, database)
+	user := "root"
+
+	svc, err := New(&Config{
+		Host:     getDBHost(),
+		Database: &database,
+		User:     &user,
+	})
+	if err != nil {
+		t.Fatal("failed to create service")
+	}
+	ctx := context.Background()
+
+	lock, err := svc.lockMigrations(ctx)
+	require.NoError(t, err)
+	defer lock.release()
+	assert.NoError(t, lock.extend())
+
+	// Other migrator took over lease which expired meanwhile.
+	require.NoError(t, svc.DB.Exec("UPDATE schema_migrations_lock SET holder = 'other'").Error)
+	assert.True(t, errors.Is(lock.extend(), errMigrationLockLost))
+	err = svc.runMigration(ctx, lock, migrations[0], migrations[0].Up, true)
+	assert.True(t, errors.Is(err, errMigrationLockLost))
+	applied, err := svc.appliedMigrations(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, applied)
+}
+
+func TestStaleReads(t *testing.T) {
+	database := fmt.Sprintf("test_%d", rand.Intn(1000))
+	t.Log("using database: ", database)
+	err := createDb(database)
+	if err != nil {
+		t.Fatalf("failed to create database: %s", err)
+	}
+	defer dropDb(t, database)
+	user := "root"
+
+	svc, err := New(&Config{
+		Host:           getDBHost(),
+		MigrateOnStart: true,
+		Database:       &database,
+		User:           &user,
+		ReadStaleness:  2 * time.Second,
+	})
+	if err != nil {
+		t.Fatal("failed to create service")
+	}
+	ctx := context.Background()
+
+	old, err := svc.CreateMessage(ctx, &repository.CreateMessageRequest{UserName: "john@example.com", Text: "old"})
+	assert.NoError(t, err)
+	time.Sleep(3 * time.Second)
+	recent, err := svc.CreateMessage(ctx, &repository.CreateMessageRequest{UserName: "john@example.com", Text: "recent"})
+	assert.NoError(t, err)
+
+	ids := func(consistency string) []string {
+		msgs, err := svc.Messages(ctx, &repository.FilterImpl{QueryRequest: model.QueryRequest{
+			Consistency: []string{consistency},
+		}})
+		assert.NoError(t, err)
+		var ids []string
+		for _, m := range msgs {
+			ids = append(ids, *m.Ulid)
+		}
+		return ids
+	}
+	assert.Equal(t, []string{recent, old}, ids(model.ConsistencyStrong))
+	assert.Equal(t, []string{old}, ids(model.ConsistencyStale))
+}
+
+func TestMessages_UnknownFilters(t *testing.T) {
+	database := fmt.Sprintf("test_%d", rand.Intn(1000))
+	t.Log("using database: ", database)
+	err := createDb(database)
+	if err != nil {
+		t.Fatalf("failed to create database: %s", err)
+	}
+	defer dropDb(t, database)
+	user := "root"
+
+	svc, err := New(&Config{
+		Host:           getDBHost(),
+		MigrateOnStart: true,
+		Database:       &database,
+		User:           &user,
+	})
+	if err != nil {
+		t.Fatal("failed to create service")
+	}
+	ctx := context.Background()
+
+	_, err = svc.CreateMessage(ctx, &repository.CreateMessageRequest{
+		UserName: "john@example.com", Text: "my dummy text", Hashtags: []string{"atwork"},
+	})
+	assert.NoError(t, err)
+
+	for _, rules := range []model.QueryRules{
+		{UserName: []string{"nobody@example.com"}},
+		{Hashtag: []string{"unknown"}},
+		{UserName: []string{"john@example.com"}, Hashtag: []string{"unknown"}},
+	} {
+		msgs, err := svc.Messages(ctx, &repository.FilterImpl{QueryRequest: model.QueryRequest{Rules: rules}})
+		assert.NoError(t, err)
+		assert.Empty(t, msgs)
+	}
+
+	msgs, err := svc.Messages(ctx, &repository.FilterImpl{QueryRequest: model.QueryRequest{
+		Rules: model.QueryRules{UserName: []string{"john@example.com"}, Hashtag: []string{"atwork"}},
+	}})
+	assert.NoError(t, err)
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, "john@example.com", *msgs[0].User.Name)
+		assert.Equal(t, []string{"atwork"}, extractTagText(msgs[0].Hashtags))
+	}
+
+	_, err = svc.Message(ctx, "01DQ0000000000000000000000")
+	assert.Equal(t, gorm.ErrRecordNotFound, err)
+}
+
+func TestCreateMessages(t *testing.T) {
+	database := fmt.Sprintf("test_%d", rand.Intn(1000))
+	t.Log("using database: ", database)
+	err := createDb(database)
+	if err != nil {
+		t.Fatalf("failed to create database: %s", err)
+	}
+	defer dropDb(t, database)
+	user := "root"
+
+	svc, err := New(&Config{
+		Host:           getDBHost(),
+		MigrateOnStart: true,
+		Database:       &database,
+		User:           &user,
+	})
+	if err != nil {
+		t.Fatal("failed to create service")
+	}
+
+	ctx := context.Background()
+	existing, err := svc.CreateMessage(ctx, &repository.CreateMessageRequest{
+		UserName: "john@example.com", Text: "build 1", Hashtags: []string{"ci"}, IdempotencyKey: "build-1",
+	})
+	assert.NoError(t, err)
+
+	results, err := svc.CreateMessages(ctx, []*repository.CreateMessageRequest{
+		{UserName: "john@example.com", Text: "build 1", Hashtags: []string{"ci"}, IdempotencyKey: "build-1"},
+		{UserName: "john@example.com", Text: "build 2", Hashtags: []string{"ci", "release", "ci"}, IdempotencyKey: "build-2"},
+		{UserName: "jane@example.com", Text: "hello", Hashtags: []string{"release"}},
+		{UserName: "john@example.com", Text: "build 2 again", IdempotencyKey: "build-2"},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, results, 4)
+	assert.Equal(t, &repository.CreateMessageResult{Ulid: existing, Replayed: true}, results[0])
+	assert.False(t, results[1].Replayed)
+	assert.False(t, results[2].Replayed)
+	assert.Equal(t, &repository.CreateMessageResult{Ulid: results[1].Ulid, Replayed: true}, results[3])
+
+	msg, err := svc.Message(ctx, results[1].Ulid)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "build 2", msg.Text)
+		assert.Equal(t, "john@example.com", *msg.User.Name)
+		assert.ElementsMatch(t, []string{"ci", "release"}, extractTagText(msg.Hashtags))
+	}
+	msg, err = svc.Message(ctx, results[2].Ulid)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "jane@example.com", *msg.User.Name)
+	}
+
+	now := time.Now()
+	trends, err := svc.Trends(ctx, &repository.FilterImpl{QueryRequest: model.QueryRequest{
+		FromDate: []time.Time{now.Add(-time.Hour)},
+		ToDate:   []time.Time{now.Add(time.Hour)},
+		Rules:    model.QueryRules{Hashtag: []string{"ci"}, Aggregation: []time.Duration{24 * time.Hour}},
+	}})
+	if assert.NoError(t, err) {
+		var count uint
+		for _, trend := range trends.Trends {
+			count += trend.Count
+		}
+		assert.Equal(t, uint(2), count, "repeated hashtag counts once")
+	}
+
+	found, err := svc.IdempotentMessage(ctx, "john@example.com", "build-2")
+	assert.NoError(t, err)
+	assert.Equal(t, results[1].Ulid, found)
+
+	events, err := svc.Events(ctx, "", 100)
+	assert.NoError(t, err)
+	// user, hashtag and message of first message, one user, one hashtag and two messages of batch.
+	assert.Len(t, events, 7)
+}
+
+func TestCreateMessage_Backfill(t *testing.T) {
+	database := fmt.Sprintf("test_%d", rand.Intn(1000))
+	t.Log("using database: ", database)
+	err := createDb(database)
+	if err != nil {
+		t.Fatalf("failed to create database: %s", err)
+	}
+	defer dropDb(t, database)
+	user := "root"
+
+	svc, err := New(&Config{
+		Host:           getDBHost(),
+		MigrateOnStart: true,
+		Database:       &database,
+		User:           &user,
+	})
+	if err != nil {
+		t.Fatal("failed to create service")
+	}
+
+	ctx := context.Background()
+	past := time.Date(2015, 3, 1, 12, 30, 0, 0, time.UTC)
+	recent, err := svc.CreateMessage(ctx, &repository.CreateMessageRequest{
+		UserName: "john@example.com", Text: "recent", Hashtags: []string{"history"},
+	})
+	assert.NoError(t, err)
+	old, err := svc.CreateMessage(ctx, &repository.CreateMessageRequest{
+		UserName: "john@example.com", Text: "old", Hashtags: []string{"history"}, CreatedAt: past,
+	})
+	assert.NoError(t, err)
+	results, err := svc.CreateMessages(ctx, []*repository.CreateMessageRequest{
+		{UserName: "john@example.com", Text: "older", Hashtags: []string{"history"}, CreatedAt: past.Add(-time.Hour)},
+	})
+	assert.NoError(t, err)
+
+	id, err := ulid.Parse(old)
+	if assert.NoError(t, err) {
+		assert.Equal(t, ulid.Timestamp(past), id.Time())
+	}
+	msg, err := svc.Message(ctx, old)
+	if assert.NoError(t, err) {
+		assert.True(t, past.Equal(msg.CreatedAt))
+	}
+
+	// Backfilled messages sort by their original time.
+	msgs, err := svc.Messages(ctx, &repository.FilterImpl{QueryRequest: model.QueryRequest{
+		Rules: model.QueryRules{UserName: []string{"john@example.com"}},
+	}})
+	if assert.NoError(t, err) && assert.Len(t, msgs, 3) {
+		assert.Equal(t, []string{recent, old, results[0].Ulid}, []string{*msgs[0].Ulid, *msgs[1].Ulid, *msgs[2].Ulid})
+	}
+
+	trends, err := svc.Trends(ctx, &repository.FilterImpl{QueryRequest: model.QueryRequest{
+		FromDate: []time.Time{past.Add(-2 * time.Hour)},
+		ToDate:   []time.Time{past.Add(time.Hour)},
+		Rules:    model.QueryRules{Hashtag: []string{"history"}, Aggregation: []time.Duration{time.Hour}},
+	}})
+	if assert.NoError(t, err) && assert.Len(t, trends.Trends, 2) {
+		assert.Equal(t, uint(1), trends.Trends[0].Count)
+		assert.Equal(t, uint(1), trends.Trends[1].Count)
+	}
+
+	// Events are appended now, so log followers don't skip them.
+	events, err := svc.Events(ctx, "", 100)
+	if assert.NoError(t, err) && assert.Len(t, events, 5) {
+		assert.Equal(t, old, events[3].AggregateID)
+		assert.Equal(t, results[0].Ulid, events[4].AggregateID)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	ctx := context.Background()
+	user := "root"
+	newService := func(database string) *ServiceImpl {
+		t.Log("using database: ", database)
+		if err := createDb(database); err != nil {
+			t.Fatalf("failed to create database: %s", err)
+		}
+		svc, err := New(&Config{
+			Host:           getDBHost(),
+			MigrateOnStart: true,
+			Database:       &database,
+			User:           &user,
+		})
+		if err != nil {
+			t.Fatal("failed to create service")
+		}
+		return svc
+	}
+	n := rand.Intn(1000)
+	src := newService(fmt.Sprintf("test_%d", n))
+	defer dropDb(t, fmt.Sprintf("test_%d", n))
+	dst := newService(fmt.Sprintf("test_%d_import", n))
+	defer dropDb(t, fmt.Sprintf("test_%d_import", n))
+
+	past := time.Date(2015, 3, 1, 12, 30, 0, 0, time.UTC)
+	_, err := src.CreateMessages(ctx, []*repository.CreateMessageRequest{
+		{UserName: "john@example.com", Text: "old", Hashtags: []string{"history", "go"}, CreatedAt: past},
+		{UserName: "jane@example.com", Text: "new", Hashtags: []string{"go"}},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, src.RestoreUsers(ctx, []*repository.User{{Name: stringPtr("idle@example.com"), Location: "Warsaw"}}))
+
+	var buf bytes.Buffer
+	exported, err := snapshot.Export(ctx, src, &buf, &snapshot.Config{BatchSize: 1})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, &snapshot.Counts{Users: 3, Hashtags: 2, Messages: 2, MessageHashtags: 3, Trends: 3}, exported)
+
+	imported, err := snapshot.Import(ctx, dst, &buf, &snapshot.Config{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, exported, imported)
+
+	srcCounts, err := src.SnapshotCounts(ctx)
+	assert.NoError(t, err)
+	dstCounts, err := dst.SnapshotCounts(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, srcCounts, dstCounts)
+
+	srcMsgs, err := src.Messages(ctx, &repository.FilterImpl{})
+	assert.NoError(t, err)
+	dstMsgs, err := dst.Messages(ctx, &repository.FilterImpl{})
+	assert.NoError(t, err)
+	if assert.Len(t, dstMsgs, 2) {
+		for i := range srcMsgs {
+			assert.Equal(t, *srcMsgs[i].Ulid, *dstMsgs[i].Ulid)
+			assert.True(t, srcMsgs[i].CreatedAt.Equal(dstMsgs[i].CreatedAt))
+			assert.ElementsMatch(t, extractTagText(srcMsgs[i].Hashtags), extractTagText(dstMsgs[i].Hashtags))
+		}
+	}
+
+	// Restored history is recorded for projections but not as created messages.
+	events, err := dst.PendingEvents(ctx, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, events, 2) {
+		for _, e := range events {
+			assert.Equal(t, model.EventMessageRestored, e.Type)
+		}
+	}
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,139 @@
+package cockroach
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jinzhu/gorm"
+	"github.com/jozuenoon/dunder/model"
+	"github.com/jozuenoon/dunder/repository"
+	"github.com/jozuenoon/dunder/tracing"
+)
+
+var _ repository.SnapshotStore = (*ServiceImpl)(nil)
+
+func (s *ServiceImpl) Users(ctx context.Context, after, limit uint) (_ []*repository.User, err error) {
+	ctx, span := tracer.Start(ctx, "cockroach.Users")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	var users []*repository.User
+	return users, withContext(ctx, s.DB).Where("id > ?", after).Order("id").Limit(limit).Find(&users).Error
+}
+
+func (s *ServiceImpl) Hashtags(ctx context.Context, after, limit uint) (_ []*repository.Hashtag, err error) {
+	ctx, span := tracer.Start(ctx, "cockroach.Hashtags")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	var hashtags []*repository.Hashtag
+	return hashtags, withContext(ctx, s.DB).Where("id > ?", after).Order("id").Limit(limit).Find(&hashtags).Error
+}
+
+func (s *ServiceImpl) TrendCounts(ctx context.Context, after *repository.TrendCount, limit uint) (_ []*repository.TrendCount, err error) {
+	ctx, span := tracer.Start(ctx, "cockroach.TrendCounts")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	query := withContext(ctx, s.DB).Table("trends").
+		Select("trends.bucket, trends.hashtag_ref, hashtags.text, trends.count").
+		Joins("JOIN hashtags ON hashtags.id = trends.hashtag_ref").
+		Order("trends.bucket, trends.hashtag_ref").
+		Limit(limit)
+	if after != nil {
+		query = query.Where("(trends.bucket, trends.hashtag_ref) > (?, ?)", after.Bucket, after.HashtagRef)
+	}
+	rows, err := query.Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var trends []*repository.TrendCount
+	for rows.Next() {
+		var t repository.TrendCount
+		if err := rows.Scan(&t.Bucket, &t.HashtagRef, &t.Hashtag, &t.Count); err != nil {
+			return nil, err
+		}
+		trends = append(trends, &t)
+	}
+	return trends, rows.Err()
+}
+
+func (s *ServiceImpl) SnapshotCounts(ctx context.Context) (_ *repository.SnapshotCounts, err error) {
+	ctx, span := tracer.Start(ctx, "cockroach.SnapshotCounts")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	var counts repository.SnapshotCounts
+	row := withContext(ctx, s.DB).Raw(`SELECT
+		(SELECT count(*) FROM users WHERE deleted_at IS NULL),
+		(SELECT count(*) FROM hashtags WHERE deleted_at IS NULL),
+		(SELECT count(*) FROM messages WHERE deleted_at IS NULL),
+		(SELECT count(*) FROM message_hashtags JOIN messages ON messages.id = message_hashtags.message_id WHERE messages.deleted_at IS NULL),
+		(SELECT count(*) FROM trends)`).Row()
+	if err := row.Scan(&counts.Users, &counts.Hashtags, &counts.Messages, &counts.MessageHashtags, &counts.Trends); err != nil {
+		return nil, err
+	}
+	return &counts, nil
+}
+
+func (s *ServiceImpl) RestoreUsers(ctx context.Context, users []*repository.User) (err error) {
+	ctx, span := tracer.Start(ctx, "cockroach.RestoreUsers")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	rows := make([][]interface{}, 0, len(users))
+	for _, u := range users {
+		rows = append(rows, []interface{}{*u.Name, u.ScreenName, u.Location, u.URL, u.Description})
+	}
+	return insertRows(withContext(ctx, s.DB), "users", []string{"name", "screen_name", "location", "url", "description"},
+		rows, " ON CONFLICT (name) DO NOTHING")
+}
+
+func (s *ServiceImpl) RestoreHashtags(ctx context.Context, texts []string) (err error) {
+	ctx, span := tracer.Start(ctx, "cockroach.RestoreHashtags")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	rows := make([][]interface{}, 0, len(texts))
+	for _, txt := range unique(texts) {
+		rows = append(rows, []interface{}{txt})
+	}
+	return insertRows(withContext(ctx, s.DB), "hashtags", []string{"text"}, rows, " ON CONFLICT (text) DO NOTHING")
+}
+
+func (s *ServiceImpl) RestoreMessages(ctx context.Context, msgs []*repository.CreateMessageRequest) (err error) {
+	ctx, span := tracer.Start(ctx, "cockroach.RestoreMessages")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	_, err = s.createMessages(ctx, msgs, model.EventMessageRestored)
+	return err
+}
+
+func (s *ServiceImpl) RestoreTrends(ctx context.Context, trends []*repository.TrendCount) (err error) {
+	ctx, span := tracer.Start(ctx, "cockroach.RestoreTrends")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	if len(trends) == 0 {
+		return nil
+	}
+	return s.runInTx(ctx, func(tx *gorm.DB) error {
+		texts := make([]string, 0, len(trends))
+		for _, t := range trends {
+			texts = append(texts, t.Hashtag)
+		}
+		var hashtags []*repository.Hashtag
+		if err := tx.Where("text IN (?)", unique(texts)).Find(&hashtags).Error; err != nil {
+			return err
+		}
+		ids := make(map[string]uint, len(hashtags))
+		for _, h := range hashtags {
+			ids[*h.Text] = h.ID
+		}
+
+		rows := make([][]interface{}, 0, len(trends))
+		for _, t := range trends {
+			id, ok := ids[t.Hashtag]
+			if !ok {
+				return fmt.Errorf("hashtag %q of trend is missing", t.Hashtag)
+			}
+			rows = append(rows, []interface{}{t.Bucket, id, t.Count})
+		}
+		return insertRows(tx, "trends", []string{"bucket", "hashtag_ref", "count"}, rows,
+			" ON CONFLICT (bucket, hashtag_ref) DO UPDATE SET count = excluded.count")
+	})
+}
@@ -0,0 +1,109 @@
+package cockroach
+
+import (
+	"context"
+
+	"github.com/jinzhu/gorm"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	tracerName = "github.com/jozuenoon/dunder/repository/cockroach"
+
+	// contextKey holds request context on gorm.DB so statement spans are attached to caller trace.
+	contextKey = "dunder:context"
+	spanKey    = "dunder:span"
+)
+
+var tracer = otel.Tracer(tracerName)
+
+// withContext returns database handle carrying context for statement tracing.
+// gorm v1 does not pass context to the driver, so cancelled context doesn't
+// interrupt statements run on returned handle. Only transactions of runInTx are
+// bound to context, they are rolled back once it's done.
+func withContext(ctx context.Context, db *gorm.DB) *gorm.DB {
+	return db.Set(contextKey, ctx)
+}
+
+// registerTracing adds callbacks creating span for each SQL statement executed
+// through gorm models and Raw queries. gorm runs no callbacks for Exec, such
+// statements are traced by execRaw.
+func registerTracing(db *gorm.DB) {
+	cb := db.Callback()
+	cb.Create().Before("gorm:create").Register("tracing:before_create", startStatementSpan("create"))
+	cb.Create().After("gorm:create").Register("tracing:after_create", endStatementSpan)
+	cb.Query().Before("gorm:query").Register("tracing:before_query", startStatementSpan("query"))
+	cb.Query().After("gorm:query").Register("tracing:after_query", endStatementSpan)
+	cb.RowQuery().Before("gorm:row_query").Register("tracing:before_row_query", startStatementSpan("row_query"))
+	cb.RowQuery().After("gorm:row_query").Register("tracing:after_row_query", endStatementSpan)
+	cb.Update().Before("gorm:update").Register("tracing:before_update", startStatementSpan("update"))
+	cb.Update().After("gorm:update").Register("tracing:after_update", endStatementSpan)
+	cb.Delete().Before("gorm:delete").Register("tracing:before_delete", startStatementSpan("delete"))
+	cb.Delete().After("gorm:delete").Register("tracing:after_delete", endStatementSpan)
+}
+
+// execRaw runs raw statement, eg. multi-row insert or migration, in its own span.
+func execRaw(db *gorm.DB, sql string, values ...interface{}) *gorm.DB {
+	_, span := tracer.Start(statementContext(db), "sql.exec",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemCockroachdb,
+			semconv.DBStatementKey.String(sql),
+		))
+	defer span.End()
+	result := db.Exec(sql, values...)
+	span.SetAttributes(attribute.Int64("db.rows_affected", result.RowsAffected))
+	if err := result.Error; err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return result
+}
+
+// statementContext returns context set on db by withContext.
+func statementContext(db interface {
+	Get(name string) (interface{}, bool)
+}) context.Context {
+	if v, ok := db.Get(contextKey); ok {
+		if c, ok := v.(context.Context); ok {
+			return c
+		}
+	}
+	return context.Background()
+}
+
+func startStatementSpan(operation string) func(*gorm.Scope) {
+	return func(scope *gorm.Scope) {
+		_, span := tracer.Start(statementContext(scope), "sql."+operation,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				semconv.DBSystemCockroachdb,
+				semconv.DBSQLTableKey.String(scope.TableName()),
+			))
+		scope.Set(spanKey, span)
+	}
+}
+
+func endStatementSpan(scope *gorm.Scope) {
+	v, ok := scope.Get(spanKey)
+	if !ok {
+		return
+	}
+	span, ok := v.(trace.Span)
+	if !ok {
+		return
+	}
+	defer span.End()
+	span.SetAttributes(
+		semconv.DBStatementKey.String(scope.SQL),
+		attribute.Int64("db.rows_affected", scope.DB().RowsAffected),
+	)
+	if err := scope.DB().Error; err != nil && err != gorm.ErrRecordNotFound {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
@@ -0,0 +1,96 @@
+package cockroach
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/jozuenoon/dunder/metrics"
+	"github.com/lib/pq"
+)
+
+const (
+	// restartSavepoint is savepoint name CockroachDB recognizes for client side
+	// transaction retries.
+	restartSavepoint = "cockroach_restart"
+
+	maxTxRetries   = 10
+	minTxBackoff   = 10 * time.Millisecond
+	maxTxBackoff   = time.Second
+	retryErrorCode = "40001"
+)
+
+// ErrTxRetriesExhausted is returned when transaction kept failing with
+// serialization errors after all retries.
+var ErrTxRetriesExhausted = errors.New("transaction retries exhausted")
+
+// runInTx runs fn in transaction, retrying it from `cockroach_restart` savepoint
+// when CockroachDB asks for retry with serialization error (SQLSTATE 40001).
+// Retried fn must build its writes from scratch, values set by failed attempt,
+// like generated IDs, are rolled back. Commit failure is returned to caller,
+// the transaction outcome is then unknown only for ambiguous result errors.
+// Transaction is bound to ctx, it's rolled back when ctx is done and statements
+// issued afterwards fail.
+func (s *ServiceImpl) runInTx(ctx context.Context, fn func(tx *gorm.DB) error) (err error) {
+	tx := withContext(ctx, s.DB).BeginTx(ctx, nil)
+	if err := tx.Error; err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	if err := execRaw(tx, "SAVEPOINT "+restartSavepoint).Error; err != nil {
+		return err
+	}
+
+	for attempt := 0; ; attempt++ {
+		err = fn(tx)
+		if err == nil {
+			// Release commits transaction in CockroachDB, serialization
+			// errors detected at commit are returned here and could be retried.
+			err = execRaw(tx, "RELEASE SAVEPOINT "+restartSavepoint).Error
+		}
+		if err == nil {
+			return tx.Commit().Error
+		}
+		if !isRetryable(err) {
+			return err
+		}
+		if attempt >= maxTxRetries {
+			return fmt.Errorf("%w: %v", ErrTxRetriesExhausted, err)
+		}
+		metrics.TxRetried()
+		if err = execRaw(tx, "ROLLBACK TO SAVEPOINT "+restartSavepoint).Error; err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			return err
+		case <-time.After(txBackoff(attempt)):
+		}
+	}
+}
+
+// isRetryable tells whether error is CockroachDB request to retry transaction.
+func isRetryable(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && string(pqErr.Code) == retryErrorCode
+}
+
+// txBackoff returns exponential delay before retry with full jitter.
+func txBackoff(attempt int) time.Duration {
+	d := maxTxBackoff
+	if attempt < 7 {
+		d = minTxBackoff << uint(attempt)
+		if d > maxTxBackoff {
+			d = maxTxBackoff
+		}
+	}
+	return time.Duration(rand.Int63n(int64(d)) + 1)
+}
@@ -0,0 +1,221 @@
+package cockroach
+
+import (
+	"context"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/jozuenoon/dunder/model"
+	"github.com/jozuenoon/dunder/repository"
+	"github.com/jozuenoon/dunder/tracing"
+)
+
+var _ repository.WebhookStore = (*ServiceImpl)(nil)
+
+func (s *ServiceImpl) CreateWebhook(ctx context.Context, req *repository.CreateWebhookRequest) (_ *repository.WebhookSubscription, err error) {
+	ctx, span := tracer.Start(ctx, "cockroach.CreateWebhook")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	t := time.Now()
+	u, err := s.newULID(t)
+	if err != nil {
+		return nil, err
+	}
+	us := u.String()
+	var sub *repository.WebhookSubscription
+	err = s.runInTx(ctx, func(tx *gorm.DB) error {
+		owner, ownerCreated, err := s.getUserByName(tx, req.Owner)
+		if err != nil {
+			return err
+		}
+		if ownerCreated {
+			if err := s.appendEvent(tx, t, model.EventUserCreated, *owner.Name, &model.Event{
+				User: &model.User{ID: owner.ID, Name: *owner.Name},
+			}); err != nil {
+				return err
+			}
+		}
+		sub = &repository.WebhookSubscription{
+			CreatedAt: t,
+			UpdatedAt: t,
+			Ulid:      &us,
+			Owner:     *owner,
+			OwnerRef:  owner.ID,
+			URL:       req.URL,
+			Hashtag:   req.Hashtag,
+			UserName:  req.UserName,
+			Secret:    req.Secret,
+		}
+		return tx.Create(sub).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+func (s *ServiceImpl) Webhook(ctx context.Context, owner, ulid string) (_ *repository.WebhookSubscription, err error) {
+	ctx, span := tracer.Start(ctx, "cockroach.Webhook")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	var sub repository.WebhookSubscription
+	return &sub, withContext(ctx, s.DB).
+		Select("webhook_subscriptions.*").
+		Joins("JOIN users ON users.id = webhook_subscriptions.owner_ref").
+		Where("users.name = ?", owner).
+		Where("webhook_subscriptions.ulid = ?", ulid).
+		Preload("Owner").
+		First(&sub).Error
+}
+
+func (s *ServiceImpl) Webhooks(ctx context.Context, owner string) (_ []*repository.WebhookSubscription, err error) {
+	ctx, span := tracer.Start(ctx, "cockroach.Webhooks")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	var subs []*repository.WebhookSubscription
+	return subs, withContext(ctx, s.DB).
+		Select("webhook_subscriptions.*").
+		Joins("JOIN users ON users.id = webhook_subscriptions.owner_ref").
+		Where("users.name = ?", owner).
+		Order("webhook_subscriptions.ulid").
+		Preload("Owner").
+		Find(&subs).Error
+}
+
+func (s *ServiceImpl) DeleteWebhook(ctx context.Context, owner, ulid string) (err error) {
+	ctx, span := tracer.Start(ctx, "cockroach.DeleteWebhook")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	sub, err := s.Webhook(ctx, owner, ulid)
+	if err != nil {
+		return err
+	}
+	return withContext(ctx, s.DB).Delete(sub).Error
+}
+
+func (s *ServiceImpl) MatchingWebhooks(ctx context.Context, userName string, hashtags []string) (_ []*repository.WebhookSubscription, err error) {
+	ctx, span := tracer.Start(ctx, "cockroach.MatchingWebhooks")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	query := withContext(ctx, s.DB).Where("user_name = '' OR user_name = ?", userName)
+	if len(hashtags) > 0 {
+		query = query.Where("hashtag = '' OR hashtag IN (?)", hashtags)
+	} else {
+		query = query.Where("hashtag = ''")
+	}
+	var subs []*repository.WebhookSubscription
+	return subs, query.Find(&subs).Error
+}
+
+func (s *ServiceImpl) EnqueueDeliveries(ctx context.Context, jobs []*repository.WebhookJob) (err error) {
+	ctx, span := tracer.Start(ctx, "cockroach.EnqueueDeliveries")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	rows := make([][]interface{}, 0, len(jobs))
+	for _, j := range jobs {
+		rows = append(rows, []interface{}{j.CreatedAt, j.SubscriptionRef, j.EventID, j.EventType, j.Payload, j.Attempt, j.NextAttemptAt})
+	}
+	// Relay may publish the same event again after crash.
+	return insertRows(withContext(ctx, s.DB), "webhook_jobs",
+		[]string{"created_at", "subscription_ref", "event_id", "event_type", "payload", "attempt", "next_attempt_at"},
+		rows, " ON CONFLICT (subscription_ref, event_id) DO NOTHING")
+}
+
+func (s *ServiceImpl) DueDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit uint) (_ []*repository.WebhookJob, err error) {
+	ctx, span := tracer.Start(ctx, "cockroach.DueDeliveries")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	var jobs []*repository.WebhookJob
+	err = s.runInTx(ctx, func(tx *gorm.DB) error {
+		jobs = nil
+		rows, err := tx.Raw(`UPDATE webhook_jobs SET next_attempt_at = ?
+			WHERE id IN (SELECT id FROM webhook_jobs WHERE next_attempt_at <= ? ORDER BY next_attempt_at LIMIT ?)
+			RETURNING id, created_at, subscription_ref, event_id, event_type, payload, attempt, next_attempt_at`,
+			now.Add(lease), now, limit).Rows()
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		var refs []uint
+		for rows.Next() {
+			var j repository.WebhookJob
+			if err := rows.Scan(&j.ID, &j.CreatedAt, &j.SubscriptionRef, &j.EventID, &j.EventType, &j.Payload, &j.Attempt, &j.NextAttemptAt); err != nil {
+				return err
+			}
+			jobs = append(jobs, &j)
+			refs = append(refs, j.SubscriptionRef)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(jobs) == 0 {
+			return nil
+		}
+
+		var subs []*repository.WebhookSubscription
+		if err := tx.Where("id IN (?)", refs).Find(&subs).Error; err != nil {
+			return err
+		}
+		byID := make(map[uint]*repository.WebhookSubscription, len(subs))
+		for _, sub := range subs {
+			byID[sub.ID] = sub
+		}
+		due := jobs[:0]
+		var orphaned []uint
+		for _, j := range jobs {
+			sub, ok := byID[j.SubscriptionRef]
+			if !ok {
+				orphaned = append(orphaned, j.ID)
+				continue
+			}
+			j.Subscription = *sub
+			due = append(due, j)
+		}
+		jobs = due
+		if len(orphaned) == 0 {
+			return nil
+		}
+		return tx.Where("id IN (?)", orphaned).Delete(&repository.WebhookJob{}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+func (s *ServiceImpl) RescheduleDelivery(ctx context.Context, id uint, attempt int, next time.Time) (err error) {
+	ctx, span := tracer.Start(ctx, "cockroach.RescheduleDelivery")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	return withContext(ctx, s.DB).Model(&repository.WebhookJob{}).Where("id = ?", id).
+		Updates(map[string]interface{}{"attempt": attempt, "next_attempt_at": next}).Error
+}
+
+func (s *ServiceImpl) CompleteDelivery(ctx context.Context, id uint) (err error) {
+	ctx, span := tracer.Start(ctx, "cockroach.CompleteDelivery")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	return withContext(ctx, s.DB).Where("id = ?", id).Delete(&repository.WebhookJob{}).Error
+}
+
+func (s *ServiceImpl) RecordDelivery(ctx context.Context, delivery *repository.WebhookDelivery) (err error) {
+	ctx, span := tracer.Start(ctx, "cockroach.RecordDelivery")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	return withContext(ctx, s.DB).Create(delivery).Error
+}
+
+func (s *ServiceImpl) WebhookDeliveries(ctx context.Context, subscriptionID uint, deadOnly bool, limit uint) (_ []*repository.WebhookDelivery, err error) {
+	ctx, span := tracer.Start(ctx, "cockroach.WebhookDeliveries")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	query := withContext(ctx, s.DB).
+		Where("subscription_ref = ?", subscriptionID).
+		Order("id desc").
+		Limit(limit)
+	if deadOnly {
+		query = query.Where("dead = ?", true)
+	}
+	var deliveries []*repository.WebhookDelivery
+	return deliveries, query.Find(&deliveries).Error
+}
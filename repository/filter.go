@@ -15,4 +15,6 @@ type Filter interface {
 	GetToDate() time.Time
 	GetLimit() uint
 	GetUserName() string
+	// GetConsistency returns requested read consistency, empty means store default.
+	GetConsistency() string
 }
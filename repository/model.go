@@ -51,7 +51,33 @@ type Hashtag struct {
 }
 
 type CreateMessageRequest struct {
+	UserName       string
+	Text           string
+	Hashtags       []string
+	IdempotencyKey string
+	// CreatedAt backdates imported message, zero means now.
+	CreatedAt time.Time
+	// Ulid keeps id of imported message, empty generates new one.
+	Ulid string
+}
+
+type EditMessageRequest struct {
 	UserName string
+	Ulid     string
 	Text     string
-	Hashtags []string
+}
+
+// CreateMessageResult is outcome of single message of CreateMessages.
+type CreateMessageResult struct {
+	Ulid string
+	// Replayed is set when idempotency key was already used and no message was created.
+	Replayed bool
+}
+
+// IdempotencyKey remembers message created by request with given key.
+type IdempotencyKey struct {
+	UserName    string    `gorm:"primary_key"`
+	Key         string    `gorm:"column:idempotency_key;primary_key"`
+	CreatedAt   time.Time `gorm:"column:created_at;not null;default:CURRENT_TIMESTAMP;index"`
+	MessageUlid string    `gorm:"not null"`
 }
@@ -0,0 +1,29 @@
+package repository
+
+import (
+	"context"
+	"time"
+)
+
+// OutboxStore exposes event log written in same transaction as state changes.
+type OutboxStore interface {
+	// PendingEvents returns oldest events not yet published.
+	PendingEvents(ctx context.Context, limit uint) ([]*OutboxEvent, error)
+	MarkPublished(ctx context.Context, ids []uint) error
+	// Events returns event log in order, starting after event with given ulid, empty
+	// ulid starts from the beginning.
+	Events(ctx context.Context, after string, limit uint) ([]*OutboxEvent, error)
+}
+
+// OutboxEvent is single entry of event log. Events are ordered by ulid.
+type OutboxEvent struct {
+	ID        uint      `gorm:"primary_key"`
+	CreatedAt time.Time `gorm:"column:created_at;not null;default:CURRENT_TIMESTAMP"`
+	Ulid      *string   `gorm:"unique;not null"`
+	Type      string    `gorm:"not null"`
+	// AggregateID identifies changed entity, eg. message ulid or user name.
+	AggregateID string `gorm:"not null"`
+	// Payload holds JSON encoded model.Event.
+	Payload     string     `gorm:"type:jsonb;not null"`
+	PublishedAt *time.Time `gorm:"index"`
+}
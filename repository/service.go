@@ -7,15 +7,51 @@ import (
 	"github.com/jozuenoon/dunder/model"
 )
 
-type Service interface {
-	Message(ctx context.Context, ulid string) (*Message, error)
-
+// CommandStore is write side of storage and source of truth.
+type CommandStore interface {
 	// Returns message ulid
 	CreateMessage(ctx context.Context, message *CreateMessageRequest) (string, error)
+	// CreateMessages creates all messages in single transaction, results are in
+	// order of requests. Requests with idempotency key used already, also earlier
+	// in the same batch, are replayed instead of created.
+	CreateMessages(ctx context.Context, messages []*CreateMessageRequest) ([]*CreateMessageResult, error)
+	// IdempotentMessage returns ulid of message created by user with given
+	// idempotency key, unless the key expired.
+	IdempotentMessage(ctx context.Context, userName, key string) (string, error)
+	// EditMessage replaces text of message. Messages of other users are not found.
+	EditMessage(ctx context.Context, req *EditMessageRequest) error
+	// DeleteMessage removes message of user and its trend counts. Messages of
+	// other users are not found.
+	DeleteMessage(ctx context.Context, userName, ulid string) error
+}
+
+// QueryStore serves reads, it may be backed by projection of event log and lag
+// behind CommandStore.
+type QueryStore interface {
+	Message(ctx context.Context, ulid string) (*Message, error)
 	Messages(ctx context.Context, filter Filter) ([]*Message, error)
 	Trends(ctx context.Context, filter Filter) (*MessagesAggregate, error)
 }
 
+type Service interface {
+	CommandStore
+	QueryStore
+
+	// HealthCheck verifies storage is reachable.
+	HealthCheck(ctx context.Context) (*HealthStatus, error)
+}
+
+const (
+	MigrationCurrent = "current"
+	MigrationPending = "pending"
+)
+
+// HealthStatus describes state of storage backend.
+type HealthStatus struct {
+	// Migration is schema migration state.
+	Migration string
+}
+
 const (
 	defaultLimit uint = 100
 )
@@ -70,6 +106,13 @@ func (f *FilterImpl) GetToDate() time.Time {
 	return f.ToDate[0].Truncate(time.Minute)
 }
 
+func (f *FilterImpl) GetConsistency() string {
+	if len(f.Consistency) == 0 {
+		return ""
+	}
+	return f.Consistency[0]
+}
+
 func (f *FilterImpl) GetLimit() uint {
 	if len(f.Limit) == 0 {
 		return defaultLimit
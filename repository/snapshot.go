@@ -0,0 +1,45 @@
+package repository
+
+import "context"
+
+// SnapshotStore reads and restores state which isn't reachable through
+// messages, it's used to export and import whole instance.
+type SnapshotStore interface {
+	// Users returns users with id greater than after, in id order.
+	Users(ctx context.Context, after, limit uint) ([]*User, error)
+	// Hashtags returns hashtags with id greater than after, in id order.
+	Hashtags(ctx context.Context, after, limit uint) ([]*Hashtag, error)
+	// TrendCounts returns trends rows following after, in bucket and hashtag order.
+	// Nil after starts from the first row.
+	TrendCounts(ctx context.Context, after *TrendCount, limit uint) ([]*TrendCount, error)
+	// SnapshotCounts counts rows of live data.
+	SnapshotCounts(ctx context.Context) (*SnapshotCounts, error)
+
+	// RestoreUsers creates users missing by name, existing ones are kept.
+	RestoreUsers(ctx context.Context, users []*User) error
+	// RestoreHashtags creates missing hashtags.
+	RestoreHashtags(ctx context.Context, texts []string) error
+	// RestoreMessages creates messages with their ulids and creation time.
+	// Unlike CreateMessages they are recorded as message.restored events, so
+	// projections pick them up while webhooks don't receive history again.
+	RestoreMessages(ctx context.Context, msgs []*CreateMessageRequest) error
+	// RestoreTrends sets counts of trends rows, hashtags must exist.
+	RestoreTrends(ctx context.Context, trends []*TrendCount) error
+}
+
+// TrendCount is trends row with resolved hashtag text.
+type TrendCount struct {
+	Bucket     uint
+	HashtagRef uint
+	Hashtag    string
+	Count      uint
+}
+
+// SnapshotCounts are numbers of rows in store.
+type SnapshotCounts struct {
+	Users           uint
+	Hashtags        uint
+	Messages        uint
+	MessageHashtags uint
+	Trends          uint
+}
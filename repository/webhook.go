@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"context"
+	"time"
+)
+
+// WebhookStore keeps webhook subscriptions and their delivery logs.
+type WebhookStore interface {
+	CreateWebhook(ctx context.Context, req *CreateWebhookRequest) (*WebhookSubscription, error)
+	Webhook(ctx context.Context, owner, ulid string) (*WebhookSubscription, error)
+	Webhooks(ctx context.Context, owner string) ([]*WebhookSubscription, error)
+	DeleteWebhook(ctx context.Context, owner, ulid string) error
+	// MatchingWebhooks returns subscriptions interested in message of given user with given hashtags.
+	MatchingWebhooks(ctx context.Context, userName string, hashtags []string) ([]*WebhookSubscription, error)
+
+	// EnqueueDeliveries schedules delivery jobs, jobs of the same subscription
+	// and event which are already scheduled are skipped.
+	EnqueueDeliveries(ctx context.Context, jobs []*WebhookJob) error
+	// DueDeliveries claims up to limit jobs due at now along with their
+	// subscriptions. Claimed jobs aren't due again until lease passes, so jobs
+	// of crashed dispatcher are retried. Jobs of deleted subscriptions are dropped.
+	DueDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit uint) ([]*WebhookJob, error)
+	// RescheduleDelivery stores number of attempts made so far and time of next attempt.
+	RescheduleDelivery(ctx context.Context, id uint, attempt int, next time.Time) error
+	// CompleteDelivery removes job which succeeded or was moved to dead letters.
+	CompleteDelivery(ctx context.Context, id uint) error
+
+	RecordDelivery(ctx context.Context, delivery *WebhookDelivery) error
+	// WebhookDeliveries returns delivery log of subscription, newest first.
+	WebhookDeliveries(ctx context.Context, subscriptionID uint, deadOnly bool, limit uint) ([]*WebhookDelivery, error)
+}
+
+type WebhookSubscription struct {
+	ID        uint       `gorm:"primary_key"`
+	CreatedAt time.Time  `gorm:"column:created_at;not null;default:CURRENT_TIMESTAMP"`
+	UpdatedAt time.Time  `gorm:"column:updated_at;not null;default:CURRENT_TIMESTAMP"`
+	DeletedAt *time.Time `sql:"index"`
+	Ulid      *string    `gorm:"unique;not null"`
+	Owner     User       `gorm:"foreignkey:OwnerRef;association_autoupdate:false"`
+	OwnerRef  uint       `gorm:"index"`
+	URL       string     `gorm:"not null"`
+	// Hashtag and UserName filter messages, empty value matches all.
+	Hashtag  string
+	UserName string
+	Secret   string `gorm:"not null"`
+}
+
+// WebhookJob is scheduled delivery of event to subscription, it's kept until
+// delivery succeeds or is moved to dead letters.
+type WebhookJob struct {
+	ID              uint                `gorm:"primary_key"`
+	CreatedAt       time.Time           `gorm:"column:created_at;not null;default:CURRENT_TIMESTAMP"`
+	Subscription    WebhookSubscription `gorm:"foreignkey:SubscriptionRef;association_autoupdate:false"`
+	SubscriptionRef uint
+	EventID         string
+	EventType       string
+	Payload         string
+	// Attempt is number of attempts made so far.
+	Attempt       int
+	NextAttemptAt time.Time `gorm:"index"`
+}
+
+// WebhookDelivery is single delivery attempt of event to subscription.
+type WebhookDelivery struct {
+	ID              uint      `gorm:"primary_key"`
+	CreatedAt       time.Time `gorm:"column:created_at;not null;default:CURRENT_TIMESTAMP"`
+	SubscriptionRef uint      `gorm:"index"`
+	EventID         string
+	EventType       string
+	Attempt         int
+	StatusCode      int
+	Error           string
+	// Dead marks final failed attempt, such deliveries form dead letter list.
+	Dead bool
+}
+
+type CreateWebhookRequest struct {
+	Owner    string
+	URL      string
+	Hashtag  string
+	UserName string
+	Secret   string
+}
@@ -2,40 +2,88 @@ package service
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	"github.com/jozuenoon/dunder/model"
 
 	"github.com/rs/zerolog"
 
 	"github.com/jozuenoon/dunder/repository"
+	"github.com/jozuenoon/dunder/tracing"
 )
 
 type Dunder interface {
 	CreateMessage(context.Context, string, *model.CreateMessageRequest) (*model.CreateMessageResponse, error)
 	GetMessage(context.Context, *model.GetMessageRequest) (*model.GetMessageResponse, error)
+	// CreateMessages creates messages of user in bulk, results are in order of messages.
+	CreateMessages(context.Context, string, []*model.BatchMessage) ([]*model.BatchMessageResult, error)
+	// EditMessage replaces text of message, only its author may edit it.
+	EditMessage(context.Context, string, string, *model.EditMessageRequest) error
+	// DeleteMessage removes message, only its author may delete it.
+	DeleteMessage(context.Context, string, string) error
 }
 
+// batchChunkSize is number of messages created in single transaction. Failure
+// of transaction fails only messages of its chunk.
+const batchChunkSize = 500
+
+var (
+	// ErrImportForbidden is returned when user who isn't importer backdates messages.
+	ErrImportForbidden = fmt.Errorf("only importers may set created_at of messages")
+	ErrCreatedInFuture = fmt.Errorf("created_at is in the future")
+)
+
 var _ Dunder = (*DunderImpl)(nil)
 
-func NewDunder(repo repository.Service, log *zerolog.Logger) *DunderImpl {
+type DunderConfig struct {
+	// Importers may backfill history, creating messages with original created_at.
+	// User names come from unverified bearer tokens, so this is no stronger
+	// than authentication in front of the service.
+	Importers []string
+}
+
+func NewDunder(repo repository.Service, cfg *DunderConfig, log *zerolog.Logger) *DunderImpl {
+	importers := make(map[string]bool, len(cfg.Importers))
+	for _, user := range cfg.Importers {
+		importers[user] = true
+	}
 	return &DunderImpl{
-		repo: repo,
-		log:  log,
+		repo:      repo,
+		importers: importers,
+		log:       log,
 	}
 }
 
 type DunderImpl struct {
-	repo repository.Service
-	log  *zerolog.Logger
+	repo      repository.Service
+	importers map[string]bool
+	log       *zerolog.Logger
 }
 
-func (d *DunderImpl) CreateMessage(ctx context.Context, userName string, req *model.CreateMessageRequest) (*model.CreateMessageResponse, error) {
+func (d *DunderImpl) CreateMessage(ctx context.Context, userName string, req *model.CreateMessageRequest) (_ *model.CreateMessageResponse, err error) {
+	ctx, span := tracer.Start(ctx, "Dunder.CreateMessage")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	if req.IdempotencyKey != "" {
+		if msgID, err := d.repo.IdempotentMessage(ctx, userName, req.IdempotencyKey); err == nil {
+			return &model.CreateMessageResponse{ID: msgID, Replayed: true}, nil
+		}
+	}
+
 	msgID, err := d.repo.CreateMessage(ctx, &repository.CreateMessageRequest{
-		UserName: userName,
-		Text:     req.Text,
-		Hashtags: req.Hashtags,
+		UserName:       userName,
+		Text:           req.Text,
+		Hashtags:       req.Hashtags,
+		IdempotencyKey: req.IdempotencyKey,
 	})
 	if err != nil {
+		// Concurrent request with the same key might have created the message meanwhile.
+		if req.IdempotencyKey != "" {
+			if msgID, lookupErr := d.repo.IdempotentMessage(ctx, userName, req.IdempotencyKey); lookupErr == nil {
+				return &model.CreateMessageResponse{ID: msgID, Replayed: true}, nil
+			}
+		}
 		return nil, err
 	}
 	return &model.CreateMessageResponse{
@@ -43,7 +91,85 @@ func (d *DunderImpl) CreateMessage(ctx context.Context, userName string, req *mo
 	}, nil
 }
 
-func (d *DunderImpl) GetMessage(ctx context.Context, req *model.GetMessageRequest) (*model.GetMessageResponse, error) {
+func (d *DunderImpl) CreateMessages(ctx context.Context, userName string, msgs []*model.BatchMessage) (_ []*model.BatchMessageResult, err error) {
+	ctx, span := tracer.Start(ctx, "Dunder.CreateMessages")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	if !d.importers[userName] {
+		for _, m := range msgs {
+			if !m.CreatedAt.IsZero() {
+				return nil, ErrImportForbidden
+			}
+		}
+	}
+
+	now := time.Now()
+	results := make([]*model.BatchMessageResult, len(msgs))
+	for start := 0; start < len(msgs); start += batchChunkSize {
+		end := start + batchChunkSize
+		if end > len(msgs) {
+			end = len(msgs)
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		var reqs []*repository.CreateMessageRequest
+		var positions []int
+		for i := start; i < end; i++ {
+			m := msgs[i]
+			if m.CreatedAt.After(now) {
+				results[i] = &model.BatchMessageResult{Error: ErrCreatedInFuture.Error()}
+				continue
+			}
+			reqs = append(reqs, &repository.CreateMessageRequest{
+				UserName:       userName,
+				Text:           m.Text,
+				Hashtags:       m.Hashtags,
+				IdempotencyKey: m.IdempotencyKey,
+				CreatedAt:      m.CreatedAt,
+			})
+			positions = append(positions, i)
+		}
+		if len(reqs) == 0 {
+			continue
+		}
+		created, err := d.repo.CreateMessages(ctx, reqs)
+		if err != nil {
+			d.log.Warn().Err(err).Int("from", start).Int("to", end).Msg("failed to create batch of messages")
+			for _, i := range positions {
+				results[i] = &model.BatchMessageResult{Error: err.Error()}
+			}
+			continue
+		}
+		for j, c := range created {
+			results[positions[j]] = &model.BatchMessageResult{ID: c.Ulid, Replayed: c.Replayed}
+		}
+	}
+	return results, nil
+}
+
+func (d *DunderImpl) EditMessage(ctx context.Context, userName, ulid string, req *model.EditMessageRequest) (err error) {
+	ctx, span := tracer.Start(ctx, "Dunder.EditMessage")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	return d.repo.EditMessage(ctx, &repository.EditMessageRequest{
+		UserName: userName,
+		Ulid:     ulid,
+		Text:     req.Text,
+	})
+}
+
+func (d *DunderImpl) DeleteMessage(ctx context.Context, userName, ulid string) (err error) {
+	ctx, span := tracer.Start(ctx, "Dunder.DeleteMessage")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	return d.repo.DeleteMessage(ctx, userName, ulid)
+}
+
+func (d *DunderImpl) GetMessage(ctx context.Context, req *model.GetMessageRequest) (_ *model.GetMessageResponse, err error) {
+	ctx, span := tracer.Start(ctx, "Dunder.GetMessage")
+	defer func() { tracing.EndSpan(span, err) }()
+
 	msg, err := d.repo.Message(ctx, req.ID)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,150 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/jozuenoon/dunder/model"
+	"github.com/jozuenoon/dunder/repository"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type keyedRepository struct {
+	repository.Service
+
+	keys    map[string]string
+	created int
+	// conflict simulates concurrent request which stored the key first.
+	conflict bool
+}
+
+func (r *keyedRepository) IdempotentMessage(ctx context.Context, userName, key string) (string, error) {
+	id, ok := r.keys[userName+"/"+key]
+	if !ok {
+		return "", gorm.ErrRecordNotFound
+	}
+	return id, nil
+}
+
+func (r *keyedRepository) CreateMessage(ctx context.Context, req *repository.CreateMessageRequest) (string, error) {
+	if r.conflict {
+		r.keys[req.UserName+"/"+req.IdempotencyKey] = "01CONCURRENT"
+		return "", errors.New("duplicate key value")
+	}
+	r.created++
+	id := "01MESSAGE" + string(rune('0'+r.created))
+	if req.IdempotencyKey != "" {
+		r.keys[req.UserName+"/"+req.IdempotencyKey] = id
+	}
+	return id, nil
+}
+
+func TestDunder_CreateMessage_Idempotent(t *testing.T) {
+	log := zerolog.Nop()
+	repo := &keyedRepository{keys: make(map[string]string)}
+	d := NewDunder(repo, &DunderConfig{}, &log)
+	ctx := context.Background()
+
+	first, err := d.CreateMessage(ctx, "john", &model.CreateMessageRequest{Text: "build ok", IdempotencyKey: "build-1"})
+	require.NoError(t, err)
+	assert.False(t, first.Replayed)
+
+	retry, err := d.CreateMessage(ctx, "john", &model.CreateMessageRequest{Text: "build ok", IdempotencyKey: "build-1"})
+	require.NoError(t, err)
+	assert.True(t, retry.Replayed)
+	assert.Equal(t, first.ID, retry.ID)
+	assert.Equal(t, 1, repo.created)
+
+	other, err := d.CreateMessage(ctx, "ala", &model.CreateMessageRequest{Text: "build ok", IdempotencyKey: "build-1"})
+	require.NoError(t, err)
+	assert.NotEqual(t, first.ID, other.ID, "keys are scoped to user")
+
+	_, err = d.CreateMessage(ctx, "john", &model.CreateMessageRequest{Text: "no key"})
+	require.NoError(t, err)
+	_, err = d.CreateMessage(ctx, "john", &model.CreateMessageRequest{Text: "no key"})
+	require.NoError(t, err)
+	assert.Equal(t, 4, repo.created)
+}
+
+func TestDunder_CreateMessage_ConcurrentKey(t *testing.T) {
+	log := zerolog.Nop()
+	repo := &keyedRepository{keys: make(map[string]string), conflict: true}
+	d := NewDunder(repo, &DunderConfig{}, &log)
+
+	resp, err := d.CreateMessage(context.Background(), "john", &model.CreateMessageRequest{Text: "build ok", IdempotencyKey: "build-1"})
+	require.NoError(t, err)
+	assert.True(t, resp.Replayed)
+	assert.Equal(t, "01CONCURRENT", resp.ID)
+
+	_, err = d.CreateMessage(context.Background(), "john", &model.CreateMessageRequest{Text: "no key"})
+	assert.Error(t, err)
+}
+
+// batchRepository fails chunks containing message with text "fail".
+type batchRepository struct {
+	repository.Service
+	chunks    int
+	createdAt []time.Time
+}
+
+func (r *batchRepository) CreateMessages(ctx context.Context, reqs []*repository.CreateMessageRequest) ([]*repository.CreateMessageResult, error) {
+	r.chunks++
+	var results []*repository.CreateMessageResult
+	for _, req := range reqs {
+		if req.Text == "fail" {
+			return nil, errors.New("chunk failed")
+		}
+		r.createdAt = append(r.createdAt, req.CreatedAt)
+		results = append(results, &repository.CreateMessageResult{Ulid: req.UserName + "/" + req.Text})
+	}
+	return results, nil
+}
+
+func TestDunder_CreateMessages_Chunks(t *testing.T) {
+	log := zerolog.Nop()
+	repo := &batchRepository{}
+	d := NewDunder(repo, &DunderConfig{}, &log)
+
+	msgs := make([]*model.BatchMessage, 2*batchChunkSize+1)
+	for i := range msgs {
+		msgs[i] = &model.BatchMessage{Text: "ok"}
+	}
+	msgs[batchChunkSize+1].Text = "fail"
+
+	results, err := d.CreateMessages(context.Background(), "john", msgs)
+	require.NoError(t, err)
+	require.Len(t, results, len(msgs))
+	assert.Equal(t, 3, repo.chunks)
+	assert.Equal(t, "john/ok", results[0].ID)
+	assert.Equal(t, "chunk failed", results[batchChunkSize].Error, "whole failed chunk is reported")
+	assert.Equal(t, "chunk failed", results[2*batchChunkSize-1].Error)
+	assert.Equal(t, "john/ok", results[2*batchChunkSize].ID)
+}
+
+func TestDunder_CreateMessages_Import(t *testing.T) {
+	log := zerolog.Nop()
+	repo := &batchRepository{}
+	d := NewDunder(repo, &DunderConfig{Importers: []string{"importer"}}, &log)
+	ctx := context.Background()
+	past := time.Date(2015, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	_, err := d.CreateMessages(ctx, "john", []*model.BatchMessage{{Text: "ok"}, {Text: "old", CreatedAt: past}})
+	assert.Equal(t, ErrImportForbidden, err)
+	assert.Equal(t, 0, repo.chunks)
+
+	results, err := d.CreateMessages(ctx, "importer", []*model.BatchMessage{
+		{Text: "old", CreatedAt: past},
+		{Text: "future", CreatedAt: time.Now().Add(time.Hour)},
+		{Text: "now"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "importer/old", results[0].ID)
+	assert.Equal(t, ErrCreatedInFuture.Error(), results[1].Error)
+	assert.Equal(t, "importer/now", results[2].ID)
+	assert.Equal(t, []time.Time{past, {}}, repo.createdAt)
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/jozuenoon/dunder/model"
+	"github.com/jozuenoon/dunder/repository"
+	"github.com/rs/zerolog"
+)
+
+const (
+	StateStarting = "starting"
+	StateServing  = "serving"
+	StateStopping = "stopping"
+)
+
+var ErrNotReady = fmt.Errorf("service not ready")
+
+type Health interface {
+	// Liveness reports server state, error is returned when server is going down.
+	Liveness(context.Context) (*model.HealthResponse, error)
+	// Readiness reports whether service is able to handle traffic.
+	Readiness(context.Context) (*model.HealthResponse, error)
+}
+
+var _ Health = (*HealthImpl)(nil)
+
+func NewHealth(repo repository.Service, log *zerolog.Logger) *HealthImpl {
+	h := &HealthImpl{
+		repo: repo,
+		log:  log,
+	}
+	h.state.Store(StateStarting)
+	return h
+}
+
+type HealthImpl struct {
+	repo  repository.Service
+	log   *zerolog.Logger
+	state atomic.Value
+}
+
+// SetState updates server state reported by health checks.
+func (h *HealthImpl) SetState(state string) {
+	h.state.Store(state)
+}
+
+func (h *HealthImpl) State() string {
+	return h.state.Load().(string)
+}
+
+func (h *HealthImpl) Liveness(ctx context.Context) (*model.HealthResponse, error) {
+	resp := &model.HealthResponse{Status: h.State()}
+	if resp.Status == StateStopping {
+		return resp, ErrNotReady
+	}
+	return resp, nil
+}
+
+func (h *HealthImpl) Readiness(ctx context.Context) (*model.HealthResponse, error) {
+	resp := &model.HealthResponse{Status: h.State()}
+	status, err := h.repo.HealthCheck(ctx)
+	if err != nil {
+		h.log.Warn().Err(err).Msg("readiness: database health check failed")
+		resp.Database = err.Error()
+		return resp, ErrNotReady
+	}
+	resp.Database = "ok"
+	resp.Migration = status.Migration
+	if resp.Status != StateServing {
+		return resp, ErrNotReady
+	}
+	return resp, nil
+}
@@ -2,21 +2,26 @@ package service
 
 import (
 	"context"
+	"time"
 
 	"github.com/jozuenoon/dunder/model"
 
 	"github.com/jozuenoon/dunder/repository"
+	"github.com/jozuenoon/dunder/tracing"
 	"github.com/rs/zerolog"
 )
 
 type DunderSearch interface {
 	Messages(context.Context, *model.QueryRequest) (*model.QueryResponse, error)
 	Trends(context.Context, *model.QueryRequest) (*model.QueryResponse, error)
+	// WalkMessages calls fn with consecutive pages of messages matching query
+	// until all of them are visited.
+	WalkMessages(context.Context, *model.QueryRequest, func([]*model.Message) error) error
 }
 
 var _ DunderSearch = (*DunderSearchImpl)(nil)
 
-func NewDunderSearch(repo repository.Service, log *zerolog.Logger) *DunderSearchImpl {
+func NewDunderSearch(repo repository.QueryStore, log *zerolog.Logger) *DunderSearchImpl {
 	return &DunderSearchImpl{
 		repo: repo,
 		log:  log,
@@ -24,11 +29,14 @@ func NewDunderSearch(repo repository.Service, log *zerolog.Logger) *DunderSearch
 }
 
 type DunderSearchImpl struct {
-	repo repository.Service
+	repo repository.QueryStore
 	log  *zerolog.Logger
 }
 
-func (d *DunderSearchImpl) Messages(ctx context.Context, req *model.QueryRequest) (*model.QueryResponse, error) {
+func (d *DunderSearchImpl) Messages(ctx context.Context, req *model.QueryRequest) (_ *model.QueryResponse, err error) {
+	ctx, span := tracer.Start(ctx, "DunderSearch.Messages")
+	defer func() { tracing.EndSpan(span, err) }()
+
 	msgs, err := d.repo.Messages(ctx, &repository.FilterImpl{QueryRequest: *req})
 	if err != nil {
 		return nil, err
@@ -48,7 +56,10 @@ func (d *DunderSearchImpl) Messages(ctx context.Context, req *model.QueryRequest
 	}, nil
 }
 
-func (d *DunderSearchImpl) Trends(ctx context.Context, req *model.QueryRequest) (*model.QueryResponse, error) {
+func (d *DunderSearchImpl) Trends(ctx context.Context, req *model.QueryRequest) (_ *model.QueryResponse, err error) {
+	ctx, span := tracer.Start(ctx, "DunderSearch.Trends")
+	defer func() { tracing.EndSpan(span, err) }()
+
 	trends, err := d.repo.Trends(ctx, &repository.FilterImpl{QueryRequest: *req})
 	if err != nil {
 		return nil, err
@@ -58,3 +69,39 @@ func (d *DunderSearchImpl) Trends(ctx context.Context, req *model.QueryRequest)
 		NextCursor: "",
 	}, nil
 }
+
+func (d *DunderSearchImpl) WalkMessages(ctx context.Context, req *model.QueryRequest, fn func([]*model.Message) error) (err error) {
+	ctx, span := tracer.Start(ctx, "DunderSearch.WalkMessages")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	query := *req
+	filter := &repository.FilterImpl{QueryRequest: query}
+	var fromDate time.Time
+	if filter.IsDateRangeQuery() {
+		fromDate = filter.GetFromDate()
+	}
+	for {
+		resp, err := d.Messages(ctx, &query)
+		if err != nil {
+			return err
+		}
+		msgs := resp.Messages
+		// Cursor queries don't respect date range, so lower bound is checked here.
+		// Messages are ordered newest first.
+		for i, m := range msgs {
+			if !fromDate.IsZero() && !m.CreatedAt.After(fromDate) {
+				if i == 0 {
+					return nil
+				}
+				return fn(msgs[:i])
+			}
+		}
+		if len(msgs) == 0 {
+			return nil
+		}
+		if err := fn(msgs); err != nil {
+			return err
+		}
+		query.Cursor = []string{resp.NextCursor}
+	}
+}
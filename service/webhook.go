@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+
+	"github.com/jozuenoon/dunder/model"
+	"github.com/jozuenoon/dunder/repository"
+	"github.com/rs/zerolog"
+)
+
+const deliveriesLimit = 100
+
+type Webhooks interface {
+	// CreateWebhook subscribes owner to events, returned webhook contains secret used to sign deliveries.
+	CreateWebhook(ctx context.Context, owner string, req *model.CreateWebhookRequest) (*model.Webhook, error)
+	Webhooks(ctx context.Context, owner string) (*model.WebhooksResponse, error)
+	DeleteWebhook(ctx context.Context, owner, id string) error
+	// Deliveries returns recent delivery log of webhook, deadOnly limits it to dead letters.
+	Deliveries(ctx context.Context, owner, id string, deadOnly bool) (*model.WebhooksResponse, error)
+}
+
+// TargetChecker validates address of webhook, eg. rejecting internal networks.
+type TargetChecker interface {
+	CheckURL(ctx context.Context, url string) error
+}
+
+var _ Webhooks = (*WebhooksImpl)(nil)
+
+func NewWebhooks(store repository.WebhookStore, targets TargetChecker, log *zerolog.Logger) *WebhooksImpl {
+	return &WebhooksImpl{
+		store:   store,
+		targets: targets,
+		log:     log,
+	}
+}
+
+type WebhooksImpl struct {
+	store   repository.WebhookStore
+	targets TargetChecker
+	log     *zerolog.Logger
+}
+
+func (w *WebhooksImpl) CreateWebhook(ctx context.Context, owner string, req *model.CreateWebhookRequest) (*model.Webhook, error) {
+	u, err := url.Parse(req.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook url: %v", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid webhook url: expected absolute http or https url")
+	}
+	if err := w.targets.CheckURL(ctx, req.URL); err != nil {
+		return nil, fmt.Errorf("invalid webhook url: %v", err)
+	}
+	secret := req.Secret
+	if secret == "" {
+		if secret, err = newSecret(); err != nil {
+			return nil, err
+		}
+	}
+	sub, err := w.store.CreateWebhook(ctx, &repository.CreateWebhookRequest{
+		Owner:    owner,
+		URL:      req.URL,
+		Hashtag:  req.Hashtag,
+		UserName: req.UserName,
+		Secret:   secret,
+	})
+	if err != nil {
+		return nil, err
+	}
+	hook := webhookAdapter(sub)
+	hook.Secret = sub.Secret
+	return hook, nil
+}
+
+func (w *WebhooksImpl) Webhooks(ctx context.Context, owner string) (*model.WebhooksResponse, error) {
+	subs, err := w.store.Webhooks(ctx, owner)
+	if err != nil {
+		return nil, err
+	}
+	resp := &model.WebhooksResponse{}
+	for _, sub := range subs {
+		resp.Webhooks = append(resp.Webhooks, webhookAdapter(sub))
+	}
+	return resp, nil
+}
+
+func (w *WebhooksImpl) DeleteWebhook(ctx context.Context, owner, id string) error {
+	return w.store.DeleteWebhook(ctx, owner, id)
+}
+
+func (w *WebhooksImpl) Deliveries(ctx context.Context, owner, id string, deadOnly bool) (*model.WebhooksResponse, error) {
+	sub, err := w.store.Webhook(ctx, owner, id)
+	if err != nil {
+		return nil, err
+	}
+	deliveries, err := w.store.WebhookDeliveries(ctx, sub.ID, deadOnly, deliveriesLimit)
+	if err != nil {
+		return nil, err
+	}
+	resp := &model.WebhooksResponse{}
+	for _, d := range deliveries {
+		resp.Deliveries = append(resp.Deliveries, &model.WebhookDelivery{
+			EventID:    d.EventID,
+			EventType:  d.EventType,
+			Attempt:    d.Attempt,
+			StatusCode: d.StatusCode,
+			Error:      d.Error,
+			Dead:       d.Dead,
+			CreatedAt:  d.CreatedAt,
+		})
+	}
+	return resp, nil
+}
+
+// webhookAdapter converts subscription omitting secret.
+func webhookAdapter(sub *repository.WebhookSubscription) *model.Webhook {
+	return &model.Webhook{
+		ID:        *sub.Ulid,
+		URL:       sub.URL,
+		Hashtag:   sub.Hashtag,
+		UserName:  sub.UserName,
+		CreatedAt: sub.CreatedAt,
+	}
+}
+
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
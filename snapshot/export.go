@@ -0,0 +1,126 @@
+package snapshot
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/jozuenoon/dunder/model"
+	"github.com/jozuenoon/dunder/repository"
+)
+
+const (
+	defaultBatchSize = 500
+	minute           = 60
+	// newestCursor sorts after ulid of any message, so every page of export is
+	// cursor query and tiered store reads archived messages too.
+	newestCursor = "7ZZZZZZZZZZZZZZZZZZZZZZZZZ"
+)
+
+// Source is instance exported to archive. Messages are read through its
+// QueryStore, which should be archive.Tiered when old messages are archived.
+type Source interface {
+	repository.QueryStore
+	repository.SnapshotStore
+}
+
+// Export writes users, hashtags, messages and trends of src to w. Rows changed
+// while export runs may be missed, export instance which doesn't take writes.
+func Export(ctx context.Context, src Source, w io.Writer, cfg *Config) (*Counts, error) {
+	batch := cfg.batchSize()
+	out := newWriter(w)
+	if err := out.write(&Record{Type: recordHeader, Header: &Header{Version: Version, CreatedAt: time.Now().UTC()}}); err != nil {
+		return nil, err
+	}
+
+	var after uint
+	for {
+		users, err := src.Users(ctx, after, batch)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range users {
+			if err := out.write(&Record{Type: recordUser, User: &model.User{
+				Name:        *u.Name,
+				ScreenName:  u.ScreenName,
+				Location:    u.Location,
+				URL:         u.URL,
+				Description: u.Description,
+			}}); err != nil {
+				return nil, err
+			}
+			after = u.ID
+		}
+		if uint(len(users)) < batch {
+			break
+		}
+	}
+
+	after = 0
+	for {
+		hashtags, err := src.Hashtags(ctx, after, batch)
+		if err != nil {
+			return nil, err
+		}
+		for _, h := range hashtags {
+			if err := out.write(&Record{Type: recordHashtag, Hashtag: *h.Text}); err != nil {
+				return nil, err
+			}
+			after = h.ID
+		}
+		if uint(len(hashtags)) < batch {
+			break
+		}
+	}
+
+	filter := &repository.FilterImpl{QueryRequest: model.QueryRequest{Limit: []uint{batch}, Cursor: []string{newestCursor}}}
+	for {
+		msgs, err := src.Messages(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range msgs {
+			hashtags := make([]string, 0, len(m.Hashtags))
+			for _, h := range m.Hashtags {
+				hashtags = append(hashtags, *h.Text)
+			}
+			if err := out.write(&Record{Type: recordMessage, Message: &model.Message{
+				ID:        *m.Ulid,
+				User:      model.User{Name: *m.User.Name},
+				Text:      m.Text,
+				Hashtags:  hashtags,
+				CreatedAt: m.CreatedAt.UTC(),
+			}}); err != nil {
+				return nil, err
+			}
+		}
+		if uint(len(msgs)) < batch {
+			break
+		}
+		filter.Cursor = []string{*msgs[len(msgs)-1].Ulid}
+	}
+
+	var last *repository.TrendCount
+	for {
+		trends, err := src.TrendCounts(ctx, last, batch)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range trends {
+			if err := out.write(&Record{Type: recordTrend, Trend: &Trend{
+				Minute:  time.Unix(int64(t.Bucket)*minute, 0).UTC(),
+				Hashtag: t.Hashtag,
+				Count:   t.Count,
+			}}); err != nil {
+				return nil, err
+			}
+			last = t
+		}
+		if uint(len(trends)) < batch {
+			break
+		}
+	}
+
+	counts := out.counts
+	return &counts, out.close()
+}
@@ -0,0 +1,201 @@
+// Package snapshot exports whole Dunder instance to portable archive and
+// imports it into any repository backend.
+//
+// Archive is gzip compressed NDJSON stream of records: header, users,
+// hashtags, messages, trends and footer with counts of written records, so
+// truncated archive is detected.
+package snapshot
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jozuenoon/dunder/model"
+)
+
+// Version of archive format.
+const Version = 1
+
+const (
+	recordHeader  = "header"
+	recordUser    = "user"
+	recordHashtag = "hashtag"
+	recordMessage = "message"
+	recordTrend   = "trend"
+	recordFooter  = "footer"
+)
+
+var (
+	// ErrTruncated is returned when archive ends without footer.
+	ErrTruncated = errors.New("snapshot archive is truncated")
+	// ErrCountMismatch is returned when counts of records or restored rows don't match footer.
+	ErrCountMismatch = errors.New("snapshot counts mismatch")
+)
+
+// Record is single line of archive, Type tells which field is set.
+type Record struct {
+	Type    string         `json:"type"`
+	Header  *Header        `json:"header,omitempty"`
+	User    *model.User    `json:"user,omitempty"`
+	Hashtag string         `json:"hashtag,omitempty"`
+	Message *model.Message `json:"message,omitempty"`
+	Trend   *Trend         `json:"trend,omitempty"`
+	Counts  *Counts        `json:"counts,omitempty"`
+}
+
+type Header struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Trend is number of messages with hashtag posted within minute.
+type Trend struct {
+	Minute  time.Time `json:"minute"`
+	Hashtag string    `json:"hashtag"`
+	Count   uint      `json:"count"`
+}
+
+// Counts are numbers of archived records, MessageHashtags counts hashtags of messages.
+type Counts struct {
+	Users           uint `json:"users"`
+	Hashtags        uint `json:"hashtags"`
+	Messages        uint `json:"messages"`
+	MessageHashtags uint `json:"message_hashtags"`
+	Trends          uint `json:"trends"`
+}
+
+// diff lists fields which differ from other.
+func (c *Counts) diff(other *Counts, skipTrends bool) error {
+	var diffs []string
+	check := func(name string, a, b uint) {
+		if a != b {
+			diffs = append(diffs, fmt.Sprintf("%s %d != %d", name, a, b))
+		}
+	}
+	check("users", c.Users, other.Users)
+	check("hashtags", c.Hashtags, other.Hashtags)
+	check("messages", c.Messages, other.Messages)
+	check("message_hashtags", c.MessageHashtags, other.MessageHashtags)
+	if !skipTrends {
+		check("trends", c.Trends, other.Trends)
+	}
+	if len(diffs) > 0 {
+		return fmt.Errorf("%w: %v", ErrCountMismatch, diffs)
+	}
+	return nil
+}
+
+// writer encodes records counting them.
+type writer struct {
+	zw     *gzip.Writer
+	enc    *json.Encoder
+	counts Counts
+}
+
+func newWriter(w io.Writer) *writer {
+	zw := gzip.NewWriter(w)
+	return &writer{zw: zw, enc: json.NewEncoder(zw)}
+}
+
+func (w *writer) write(r *Record) error {
+	switch r.Type {
+	case recordUser:
+		w.counts.Users++
+	case recordHashtag:
+		w.counts.Hashtags++
+	case recordMessage:
+		w.counts.Messages++
+		w.counts.MessageHashtags += uint(len(r.Message.Hashtags))
+	case recordTrend:
+		w.counts.Trends++
+	}
+	return w.enc.Encode(r)
+}
+
+// close writes footer and flushes compressed stream.
+func (w *writer) close() error {
+	counts := w.counts
+	if err := w.enc.Encode(&Record{Type: recordFooter, Counts: &counts}); err != nil {
+		return err
+	}
+	return w.zw.Close()
+}
+
+// reader decodes records counting them, footer is checked against counts.
+type reader struct {
+	zr     *gzip.Reader
+	dec    *json.Decoder
+	counts Counts
+	footer *Counts
+}
+
+func newReader(r io.Reader) (*reader, *Header, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	rd := &reader{zr: zr, dec: json.NewDecoder(zr)}
+	rec, err := rd.next()
+	if err != nil {
+		return nil, nil, err
+	}
+	if rec == nil || rec.Type != recordHeader || rec.Header == nil {
+		return nil, nil, fmt.Errorf("snapshot archive has no header")
+	}
+	if rec.Header.Version != Version {
+		return nil, nil, fmt.Errorf("unsupported snapshot version %d, expected %d", rec.Header.Version, Version)
+	}
+	return rd, rec.Header, nil
+}
+
+// next returns following record, nil after footer.
+func (r *reader) next() (*Record, error) {
+	if r.footer != nil {
+		return nil, nil
+	}
+	var rec Record
+	if err := r.dec.Decode(&rec); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrTruncated
+		}
+		return nil, err
+	}
+	switch rec.Type {
+	case recordUser:
+		if rec.User == nil {
+			return nil, fmt.Errorf("user record without user")
+		}
+		r.counts.Users++
+	case recordHashtag:
+		r.counts.Hashtags++
+	case recordMessage:
+		if rec.Message == nil {
+			return nil, fmt.Errorf("message record without message")
+		}
+		r.counts.Messages++
+		r.counts.MessageHashtags += uint(len(rec.Message.Hashtags))
+	case recordTrend:
+		if rec.Trend == nil {
+			return nil, fmt.Errorf("trend record without trend")
+		}
+		r.counts.Trends++
+	case recordFooter:
+		if rec.Counts == nil {
+			return nil, fmt.Errorf("footer record without counts")
+		}
+		r.footer = rec.Counts
+		if err := r.counts.diff(r.footer, false); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	case recordHeader:
+		// Header is handled by newReader.
+	default:
+		return nil, fmt.Errorf("unknown snapshot record %q", rec.Type)
+	}
+	return &rec, nil
+}
@@ -0,0 +1,175 @@
+package snapshot
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/jozuenoon/dunder/repository"
+)
+
+type Config struct {
+	// BatchSize is number of rows read or written at once.
+	BatchSize uint
+	// RecomputeTrends skips archived trends on import, trends are then counted
+	// from imported messages only.
+	RecomputeTrends bool
+}
+
+func (c *Config) batchSize() uint {
+	if c.BatchSize == 0 {
+		return defaultBatchSize
+	}
+	return c.BatchSize
+}
+
+// Import restores archive read from r into dst. Messages keep their ulids and
+// creation time. Users and hashtags without messages and archived trends are
+// restored only when dst implements repository.SnapshotStore, which also
+// restores messages as message.restored events, otherwise messages are created
+// as new ones and trends are recomputed from them. When dst is SnapshotStore, rows it gained are
+// verified against archive counts, so dst should be empty and idle.
+func Import(ctx context.Context, dst repository.Service, r io.Reader, cfg *Config) (*Counts, error) {
+	in, _, err := newReader(r)
+	if err != nil {
+		return nil, err
+	}
+	store, restore := dst.(repository.SnapshotStore)
+	recompute := cfg.RecomputeTrends || !restore
+
+	var before *repository.SnapshotCounts
+	if restore {
+		if before, err = store.SnapshotCounts(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	batch := int(cfg.batchSize())
+	var (
+		users    []*repository.User
+		hashtags []string
+		messages []*repository.CreateMessageRequest
+		trends   []*repository.TrendCount
+	)
+	flush := func(force bool) error {
+		if len(users) > 0 && (force || len(users) >= batch) {
+			if err := store.RestoreUsers(ctx, users); err != nil {
+				return err
+			}
+			users = nil
+		}
+		if len(hashtags) > 0 && (force || len(hashtags) >= batch) {
+			if err := store.RestoreHashtags(ctx, hashtags); err != nil {
+				return err
+			}
+			hashtags = nil
+		}
+		if len(messages) > 0 && (force || len(messages) >= batch) {
+			if err := importMessages(ctx, dst, messages); err != nil {
+				return fmt.Errorf("failed to import messages %s..%s: %w", messages[0].Ulid, messages[len(messages)-1].Ulid, err)
+			}
+			messages = nil
+		}
+		if len(trends) > 0 && (force || len(trends) >= batch) {
+			if err := store.RestoreTrends(ctx, trends); err != nil {
+				return err
+			}
+			trends = nil
+		}
+		return nil
+	}
+
+	for {
+		rec, err := in.next()
+		if err != nil {
+			return nil, err
+		}
+		if rec == nil {
+			break
+		}
+		switch rec.Type {
+		case recordUser:
+			if restore && rec.User.Name != "" {
+				name := rec.User.Name
+				users = append(users, &repository.User{
+					Name:        &name,
+					ScreenName:  rec.User.ScreenName,
+					Location:    rec.User.Location,
+					URL:         rec.User.URL,
+					Description: rec.User.Description,
+				})
+			}
+		case recordHashtag:
+			if restore {
+				hashtags = append(hashtags, rec.Hashtag)
+			}
+		case recordMessage:
+			// Users and hashtags are restored before messages referring them.
+			if err := flush(len(users) > 0 || len(hashtags) > 0); err != nil {
+				return nil, err
+			}
+			m := rec.Message
+			messages = append(messages, &repository.CreateMessageRequest{
+				UserName:  m.User.Name,
+				Text:      m.Text,
+				Hashtags:  m.Hashtags,
+				CreatedAt: m.CreatedAt,
+				Ulid:      m.ID,
+			})
+		case recordTrend:
+			if recompute {
+				continue
+			}
+			// Archived counts replace counts of imported messages.
+			if err := flush(len(messages) > 0); err != nil {
+				return nil, err
+			}
+			trends = append(trends, &repository.TrendCount{
+				Bucket:  uint(rec.Trend.Minute.Unix() / minute),
+				Hashtag: rec.Trend.Hashtag,
+				Count:   rec.Trend.Count,
+			})
+		}
+		if err := flush(false); err != nil {
+			return nil, err
+		}
+	}
+	if err := flush(true); err != nil {
+		return nil, err
+	}
+
+	counts := *in.footer
+	if !restore {
+		return &counts, nil
+	}
+	after, err := store.SnapshotCounts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	gained := &Counts{
+		Users:           after.Users - before.Users,
+		Hashtags:        after.Hashtags - before.Hashtags,
+		Messages:        after.Messages - before.Messages,
+		MessageHashtags: after.MessageHashtags - before.MessageHashtags,
+		Trends:          after.Trends - before.Trends,
+	}
+	return &counts, gained.diff(&counts, cfg.RecomputeTrends)
+}
+
+// importMessages creates messages in dst, through RestoreMessages when it's
+// SnapshotStore so webhooks are not notified.
+func importMessages(ctx context.Context, dst repository.Service, messages []*repository.CreateMessageRequest) error {
+	if store, ok := dst.(repository.SnapshotStore); ok {
+		return store.RestoreMessages(ctx, messages)
+	}
+	results, err := dst.CreateMessages(ctx, messages)
+	if err != nil {
+		return err
+	}
+	for i, res := range results {
+		if res.Replayed || res.Ulid != messages[i].Ulid {
+			return fmt.Errorf("message %s was imported as %s", messages[i].Ulid, res.Ulid)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,247 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/jozuenoon/dunder/model"
+	"github.com/jozuenoon/dunder/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memStore keeps instance state in memory, ids are positions in slices.
+type memStore struct {
+	repository.Service
+
+	users    []*repository.User
+	hashtags []*repository.Hashtag
+	messages []*repository.Message
+	trends   map[[2]uint]uint
+	// events counts message.created events appended by CreateMessages.
+	events int
+}
+
+func newMemStore() *memStore {
+	return &memStore{trends: make(map[[2]uint]uint)}
+}
+
+func (m *memStore) user(name string) *repository.User {
+	for _, u := range m.users {
+		if *u.Name == name {
+			return u
+		}
+	}
+	n := name
+	u := &repository.User{ID: uint(len(m.users) + 1), Name: &n}
+	m.users = append(m.users, u)
+	return u
+}
+
+func (m *memStore) hashtag(text string) *repository.Hashtag {
+	for _, h := range m.hashtags {
+		if *h.Text == text {
+			return h
+		}
+	}
+	t := text
+	h := &repository.Hashtag{ID: uint(len(m.hashtags) + 1), Text: &t}
+	m.hashtags = append(m.hashtags, h)
+	return h
+}
+
+func (m *memStore) CreateMessages(ctx context.Context, reqs []*repository.CreateMessageRequest) ([]*repository.CreateMessageResult, error) {
+	m.events += len(reqs)
+	return m.create(reqs), nil
+}
+
+func (m *memStore) RestoreMessages(ctx context.Context, reqs []*repository.CreateMessageRequest) error {
+	m.create(reqs)
+	return nil
+}
+
+func (m *memStore) create(reqs []*repository.CreateMessageRequest) []*repository.CreateMessageResult {
+	var results []*repository.CreateMessageResult
+	for _, req := range reqs {
+		ulid := req.Ulid
+		msg := &repository.Message{Ulid: &ulid, User: *m.user(req.UserName), Text: req.Text, CreatedAt: req.CreatedAt}
+		for _, txt := range req.Hashtags {
+			h := m.hashtag(txt)
+			msg.Hashtags = append(msg.Hashtags, h)
+			m.trends[[2]uint{uint(req.CreatedAt.Unix() / minute), h.ID}]++
+		}
+		m.messages = append(m.messages, msg)
+		results = append(results, &repository.CreateMessageResult{Ulid: ulid})
+	}
+	return results
+}
+
+func (m *memStore) Messages(ctx context.Context, filter repository.Filter) ([]*repository.Message, error) {
+	sort.Slice(m.messages, func(i, j int) bool { return *m.messages[i].Ulid > *m.messages[j].Ulid })
+	var resp []*repository.Message
+	for _, msg := range m.messages {
+		if filter.IsCursorQuery() && *msg.Ulid >= filter.GetCursor() {
+			continue
+		}
+		if uint(len(resp)) == filter.GetLimit() {
+			break
+		}
+		resp = append(resp, msg)
+	}
+	return resp, nil
+}
+
+func (m *memStore) Users(ctx context.Context, after, limit uint) ([]*repository.User, error) {
+	var resp []*repository.User
+	for _, u := range m.users {
+		if u.ID > after && uint(len(resp)) < limit {
+			resp = append(resp, u)
+		}
+	}
+	return resp, nil
+}
+
+func (m *memStore) Hashtags(ctx context.Context, after, limit uint) ([]*repository.Hashtag, error) {
+	var resp []*repository.Hashtag
+	for _, h := range m.hashtags {
+		if h.ID > after && uint(len(resp)) < limit {
+			resp = append(resp, h)
+		}
+	}
+	return resp, nil
+}
+
+func (m *memStore) TrendCounts(ctx context.Context, after *repository.TrendCount, limit uint) ([]*repository.TrendCount, error) {
+	var all []*repository.TrendCount
+	for key, count := range m.trends {
+		all = append(all, &repository.TrendCount{Bucket: key[0], HashtagRef: key[1], Hashtag: *m.hashtags[key[1]-1].Text, Count: count})
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Bucket < all[j].Bucket || all[i].Bucket == all[j].Bucket && all[i].HashtagRef < all[j].HashtagRef
+	})
+	var resp []*repository.TrendCount
+	for _, t := range all {
+		if after != nil && (t.Bucket < after.Bucket || t.Bucket == after.Bucket && t.HashtagRef <= after.HashtagRef) {
+			continue
+		}
+		if uint(len(resp)) < limit {
+			resp = append(resp, t)
+		}
+	}
+	return resp, nil
+}
+
+func (m *memStore) SnapshotCounts(ctx context.Context) (*repository.SnapshotCounts, error) {
+	counts := &repository.SnapshotCounts{
+		Users:    uint(len(m.users)),
+		Hashtags: uint(len(m.hashtags)),
+		Messages: uint(len(m.messages)),
+		Trends:   uint(len(m.trends)),
+	}
+	for _, msg := range m.messages {
+		counts.MessageHashtags += uint(len(msg.Hashtags))
+	}
+	return counts, nil
+}
+
+func (m *memStore) RestoreUsers(ctx context.Context, users []*repository.User) error {
+	for _, u := range users {
+		m.user(*u.Name).Location = u.Location
+	}
+	return nil
+}
+
+func (m *memStore) RestoreHashtags(ctx context.Context, texts []string) error {
+	for _, txt := range texts {
+		m.hashtag(txt)
+	}
+	return nil
+}
+
+func (m *memStore) RestoreTrends(ctx context.Context, trends []*repository.TrendCount) error {
+	for _, t := range trends {
+		m.trends[[2]uint{t.Bucket, m.hashtag(t.Hashtag).ID}] = t.Count
+	}
+	return nil
+}
+
+// serviceOnly hides SnapshotStore of wrapped store.
+type serviceOnly struct {
+	repository.Service
+}
+
+func sourceStore(t *testing.T) *memStore {
+	src := newMemStore()
+	at := time.Date(2019, 9, 22, 10, 0, 0, 0, time.UTC)
+	_, err := src.CreateMessages(context.Background(), []*repository.CreateMessageRequest{
+		{Ulid: "01DNQ8Y8BJM3XWK9FWX9A7R3V1", UserName: "john", Text: "release", Hashtags: []string{"go", "release"}, CreatedAt: at},
+		{Ulid: "01DNQ8Y8BJM3XWK9FWX9A7R3V2", UserName: "jane", Text: "hello", Hashtags: []string{"go"}, CreatedAt: at},
+		{Ulid: "01DNQ8Y8BJM3XWK9FWX9A7R3V3", UserName: "john", Text: "later", CreatedAt: at.Add(time.Hour)},
+	})
+	require.NoError(t, err)
+	src.user("idle").Location = "Warsaw"
+	src.hashtag("unused")
+	// Trends keep counts of messages which were archived.
+	src.trends[[2]uint{uint(at.Unix()/minute) - 60, src.hashtag("go").ID}] = 4
+	return src
+}
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	src := sourceStore(t)
+	var buf bytes.Buffer
+	exported, err := Export(ctx, src, &buf, &Config{BatchSize: 2})
+	require.NoError(t, err)
+	assert.Equal(t, &Counts{Users: 3, Hashtags: 3, Messages: 3, MessageHashtags: 3, Trends: 3}, exported)
+
+	dst := newMemStore()
+	imported, err := Import(ctx, dst, bytes.NewReader(buf.Bytes()), &Config{BatchSize: 2})
+	require.NoError(t, err)
+	assert.Equal(t, exported, imported)
+	assert.Equal(t, src.trends, dst.trends)
+	assert.Equal(t, "Warsaw", dst.user("idle").Location)
+	// Restored history isn't published again.
+	assert.Zero(t, dst.events)
+	srcMsgs, _ := src.Messages(ctx, &repository.FilterImpl{})
+	dstMsgs, _ := dst.Messages(ctx, &repository.FilterImpl{})
+	require.Len(t, dstMsgs, 3)
+	for i := range srcMsgs {
+		assert.Equal(t, *srcMsgs[i].Ulid, *dstMsgs[i].Ulid)
+		assert.Equal(t, *srcMsgs[i].User.Name, *dstMsgs[i].User.Name)
+		assert.True(t, srcMsgs[i].CreatedAt.Equal(dstMsgs[i].CreatedAt))
+	}
+
+	// Recomputed trends don't count archived messages.
+	dst = newMemStore()
+	_, err = Import(ctx, dst, bytes.NewReader(buf.Bytes()), &Config{RecomputeTrends: true})
+	require.NoError(t, err)
+	assert.Len(t, dst.trends, 2)
+
+	// Backend without SnapshotStore gets only messages.
+	dst = newMemStore()
+	_, err = Import(ctx, serviceOnly{dst}, bytes.NewReader(buf.Bytes()), &Config{})
+	require.NoError(t, err)
+	assert.Len(t, dst.messages, 3)
+	assert.Len(t, dst.users, 2)
+	assert.Len(t, dst.trends, 2)
+	assert.Equal(t, 3, dst.events)
+
+	// Importing into non-empty store fails verification.
+	_, err = Import(ctx, dst, bytes.NewReader(buf.Bytes()), &Config{})
+	assert.True(t, errors.Is(err, ErrCountMismatch), "expected count mismatch, got: %v", err)
+}
+
+func TestImport_Truncated(t *testing.T) {
+	var buf bytes.Buffer
+	w := newWriter(&buf)
+	require.NoError(t, w.write(&Record{Type: recordHeader, Header: &Header{Version: Version}}))
+	require.NoError(t, w.write(&Record{Type: recordUser, User: &model.User{Name: "john"}}))
+	require.NoError(t, w.zw.Close())
+
+	_, err := Import(context.Background(), newMemStore(), &buf, &Config{})
+	assert.Equal(t, ErrTruncated, err)
+}
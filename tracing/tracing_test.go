@@ -0,0 +1,59 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestEndSpan(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+
+	ctx, parent := tracer.Start(context.Background(), "service.CreateMessage")
+	_, child := tracer.Start(ctx, "cockroach.CreateMessage")
+	EndSpan(child, errors.New("connection refused"))
+	EndSpan(parent, nil)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "cockroach.CreateMessage", spans[0].Name())
+	assert.Equal(t, codes.Error, spans[0].Status().Code)
+	assert.Equal(t, "connection refused", spans[0].Status().Description)
+	require.Len(t, spans[0].Events(), 1, "error should be recorded as event")
+	assert.Equal(t, spans[1].SpanContext().SpanID(), spans[0].Parent().SpanID())
+	assert.Equal(t, codes.Unset, spans[1].Status().Code)
+}
+
+func TestSetup(t *testing.T) {
+	_, err := Setup(&Config{Exporter: "jaeger"})
+	assert.Error(t, err)
+	_, err = Setup(&Config{Exporter: ExporterFile})
+	assert.Error(t, err, "file exporter requires path")
+
+	dir, err := ioutil.TempDir("", "tracing")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "spans.json")
+
+	shutdown, err := Setup(&Config{ServiceName: "dunder", Exporter: ExporterFile, File: file})
+	require.NoError(t, err)
+	_, span := otel.Tracer("test").Start(context.Background(), "transport.CreateMessage")
+	span.End()
+	require.NoError(t, shutdown(context.Background()))
+
+	out, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+	assert.Contains(t, string(out), "transport.CreateMessage")
+	assert.Contains(t, string(out), "dunder")
+}
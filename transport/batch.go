@@ -0,0 +1,187 @@
+package transport
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/jozuenoon/dunder/model"
+)
+
+const (
+	// maxBatchSize is maximum number of messages in single batch request.
+	maxBatchSize = 10000
+	// maxBatchLineSize bounds single line of NDJSON batch.
+	maxBatchLineSize = 1 << 20
+	// maxBatchBodySize bounds whole batch request, so messages with large
+	// text are not decoded into memory before they are validated.
+	maxBatchBodySize = 32 << 20
+)
+
+// batchItem is decoded message of batch, err is set when it couldn't be accepted.
+type batchItem struct {
+	msg *model.BatchMessage
+	err error
+}
+
+// CreateMessages creates messages in bulk from JSON array or NDJSON stream,
+// depending on Content-Type. Messages which can't be decoded or created are
+// reported in results, others are created anyway.
+func (h *Http) CreateMessages(w http.ResponseWriter, r *http.Request) {
+	user, err := h.authenticate(r)
+	if err != nil {
+		h.writeError(err, w)
+		return
+	}
+	body := &batchBody{r: http.MaxBytesReader(w, r.Body, maxBatchBodySize)}
+	items, err := decodeBatch(r, body)
+	if body.exceeded() {
+		err = errBatchBodyTooLarge
+	}
+	if err != nil {
+		h.writeError(err, w)
+		return
+	}
+	// Rate limiter admitted request for single message, rest of batch is
+	// charged on top of it.
+	chargeRateLimit(r.Context(), len(items)-1)
+
+	var msgs []*model.BatchMessage
+	for _, item := range items {
+		if item.err == nil {
+			msgs = append(msgs, item.msg)
+		}
+	}
+	created, err := h.dunder.CreateMessages(r.Context(), user, msgs)
+	if err != nil {
+		h.writeError(err, w)
+		return
+	}
+
+	resp := &model.CreateMessagesResponse{Results: make([]*model.BatchMessageResult, 0, len(items))}
+	for _, item := range items {
+		res := &model.BatchMessageResult{}
+		if item.err != nil {
+			res.Error = item.err.Error()
+		} else {
+			res, created = created[0], created[1:]
+		}
+		if res.Error != "" {
+			resp.Failed++
+		} else if !res.Replayed {
+			resp.Created++
+		}
+		resp.Results = append(resp.Results, res)
+	}
+
+	buf, err := h.prepareResponse(resp)
+	if err != nil {
+		h.writeError(err, w)
+		return
+	}
+	if resp.Failed > 0 {
+		w.WriteHeader(http.StatusMultiStatus)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+	}
+	h.writeResponse(buf, w)
+}
+
+// batchBody counts bytes read from http.MaxBytesReader, so body over limit is
+// told apart from malformed one.
+type batchBody struct {
+	r    io.Reader
+	read int64
+	err  error
+}
+
+func (b *batchBody) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.read += int64(n)
+	if err != nil && err != io.EOF {
+		b.err = err
+	}
+	return n, err
+}
+
+// exceeded tells whether reading stopped at maxBatchBodySize.
+func (b *batchBody) exceeded() bool {
+	return b.err != nil && b.read >= maxBatchBodySize
+}
+
+func decodeBatch(r *http.Request, body io.Reader) ([]*batchItem, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	var items []*batchItem
+	var err error
+	if mediaType == contentTypeNDJSON {
+		items, err = decodeNDJSONBatch(body)
+	} else {
+		items, err = decodeJSONBatch(body)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, errEmptyBatch
+	}
+	for _, item := range items {
+		if item.err == nil && len(item.msg.IdempotencyKey) > maxIdempotencyKeyLength {
+			item.err = errIdempotencyKeyTooLong
+		}
+	}
+	return items, nil
+}
+
+// decodeJSONBatch reads JSON array of messages, malformed array fails whole batch.
+func decodeJSONBatch(body io.Reader) ([]*batchItem, error) {
+	dec := json.NewDecoder(body)
+	if tok, err := dec.Token(); err != nil {
+		return nil, err
+	} else if tok != json.Delim('[') {
+		return nil, errBatchNotArray
+	}
+	var items []*batchItem
+	for dec.More() {
+		if len(items) == maxBatchSize {
+			return nil, errBatchTooLarge
+		}
+		var msg model.BatchMessage
+		if err := dec.Decode(&msg); err != nil {
+			return nil, fmt.Errorf("message %d: %v", len(items), err)
+		}
+		items = append(items, &batchItem{msg: &msg})
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// decodeNDJSONBatch reads message per line, malformed lines are reported as
+// failed items. Empty lines are skipped.
+func decodeNDJSONBatch(body io.Reader) ([]*batchItem, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxBatchLineSize)
+	var items []*batchItem
+	line := 0
+	for scanner.Scan() {
+		line++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		if len(items) == maxBatchSize {
+			return nil, errBatchTooLarge
+		}
+		var msg model.BatchMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			items = append(items, &batchItem{err: fmt.Errorf("line %d: %v", line, err)})
+			continue
+		}
+		items = append(items, &batchItem{msg: &msg})
+	}
+	return items, scanner.Err()
+}
@@ -0,0 +1,52 @@
+package transport
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHttp_CreateMessages(t *testing.T) {
+	log := zerolog.Nop()
+	h := NewHttp(&replayingDunder{}, nil, nil, nil, &log)
+
+	post := func(contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/messages:batch", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer am9obg==")
+		req.Header.Set("Content-Type", contentType)
+		rec := httptest.NewRecorder()
+		h.CreateMessages(rec, req)
+		return rec
+	}
+
+	rec := post("application/json", `[{"text":"a","hashtags":["go"]},{"text":"b","idempotency_key":"k1"}]`)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.JSONEq(t, `{"data":{"results":[{"id":"01DQ0"},{"id":"01DQ1","replayed":true}],"created":1}}`, rec.Body.String())
+
+	rec = post(contentTypeNDJSON, "{\"text\":\"a\"}\n\n{broken\n{\"text\":\"fail\"}\n{\"text\":\"b\"}\n")
+	assert.Equal(t, http.StatusMultiStatus, rec.Code)
+	assert.JSONEq(t, `{"data":{"results":[
+		{"id":"01DQ0"},
+		{"error":"line 3: invalid character 'b' looking for beginning of object key string"},
+		{"error":"failed"},
+		{"id":"01DQ2"}
+	],"created":2,"failed":2}}`, rec.Body.String())
+
+	rec = post("application/json", `{"text":"a"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = post("application/json", `[]`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = post("application/json", "["+strings.Repeat(`{"text":"a"},`, maxBatchSize)+`{"text":"a"}]`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// Body is limited before messages are counted.
+	large := `{"text":"` + strings.Repeat("a", maxBatchLineSize/2) + `"}`
+	rec = post("application/json", "["+strings.Repeat(large+",", 2*maxBatchBodySize/maxBatchLineSize)+large+"]")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	rec = post(contentTypeNDJSON, strings.Repeat(large+"\n", 2*maxBatchBodySize/maxBatchLineSize))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+}
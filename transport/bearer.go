@@ -2,9 +2,19 @@ package transport
 
 import (
 	"encoding/base64"
+	"net/http"
 	"strings"
 )
 
+// authenticate returns name of user making request.
+func (h *Http) authenticate(r *http.Request) (string, error) {
+	token := r.Header.Get("authorization")
+	if token == "" {
+		return "", unauthorized
+	}
+	return h.userFromBearerToken(token)
+}
+
 // userFromBearerToken is simplistic authentication example, read base64 encoded user name in bearer token.
 // Example "Authorization: Bearer <base64 encoded username>"
 func (h *Http) userFromBearerToken(authHeader string) (string, error) {
@@ -5,17 +5,32 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/jinzhu/gorm"
+	"github.com/jozuenoon/dunder/service"
 	"net/http"
 )
 
 var (
-	unauthorized = fmt.Errorf("unauthorized")
+	unauthorized    = fmt.Errorf("unauthorized")
+	tooManyRequests = fmt.Errorf("too many requests")
+
+	errIdempotencyKeyTooLong = fmt.Errorf("idempotency key longer than %d characters", maxIdempotencyKeyLength)
+	errInvalidConsistency    = fmt.Errorf("consistency must be strong or stale")
+	errEmptyBatch            = fmt.Errorf("batch has no messages")
+	errBatchNotArray         = fmt.Errorf("batch must be array of messages or NDJSON stream")
+	errBatchTooLarge         = fmt.Errorf("batch has more than %d messages", maxBatchSize)
+	errBatchBodyTooLarge     = fmt.Errorf("batch is larger than %d bytes", maxBatchBodySize)
 )
 
 func (h *Http) writeError(err error, w http.ResponseWriter) {
 	switch err {
 	case unauthorized:
 		w.WriteHeader(http.StatusUnauthorized)
+	case tooManyRequests:
+		w.WriteHeader(http.StatusTooManyRequests)
+	case errBatchBodyTooLarge:
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+	case service.ErrImportForbidden:
+		w.WriteHeader(http.StatusForbidden)
 	case gorm.ErrRecordNotFound:
 		// This error should be masked by Service error instead so dependencies to gorm are not propagated here.
 		w.WriteHeader(http.StatusNotFound)
@@ -0,0 +1,200 @@
+package transport
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jozuenoon/dunder/model"
+)
+
+const (
+	formatCSV    = "csv"
+	formatNDJSON = "ndjson"
+
+	contentTypeCSV    = "text/csv"
+	contentTypeNDJSON = "application/x-ndjson"
+
+	// exportPageSize is number of messages fetched at once while streaming export.
+	exportPageSize = 1000
+	// exportWriteTimeout replaces server write timeout for each page of export,
+	// so large exports are not cut off while stalled clients still are.
+	exportWriteTimeout = time.Minute
+)
+
+// exportFormat picks export format from `format` query parameter or `Accept` header.
+// Empty string means regular JSON API response.
+func exportFormat(r *http.Request) string {
+	switch f := strings.ToLower(r.Form.Get("format")); f {
+	case formatCSV, formatNDJSON:
+		return f
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case contentTypeCSV:
+			return formatCSV
+		case contentTypeNDJSON:
+			return formatNDJSON
+		}
+	}
+	return ""
+}
+
+// exporter streams records directly to response writer, flushing after each batch.
+type exporter struct {
+	w     http.ResponseWriter
+	flush func()
+	csv   *csv.Writer
+	json  *json.Encoder
+}
+
+func newExporter(format, name string, w http.ResponseWriter) *exporter {
+	e := &exporter{w: w, flush: func() {}}
+	if f, ok := w.(http.Flusher); ok {
+		e.flush = f.Flush
+	}
+	e.extendDeadline()
+	if format == formatCSV {
+		w.Header().Set("Content-Type", contentTypeCSV+"; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.csv"`)
+		e.csv = csv.NewWriter(w)
+	} else {
+		w.Header().Set("Content-Type", contentTypeNDJSON)
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.ndjson"`)
+		e.json = json.NewEncoder(w)
+	}
+	return e
+}
+
+func (e *exporter) header(columns ...string) error {
+	if e.csv == nil {
+		return nil
+	}
+	return e.csv.Write(columns)
+}
+
+// write outputs single record, row is used for CSV and v for NDJSON.
+func (e *exporter) write(v interface{}, row ...string) error {
+	if e.csv != nil {
+		return e.csv.Write(row)
+	}
+	return e.json.Encode(v)
+}
+
+func (e *exporter) done() error {
+	if e.csv != nil {
+		e.csv.Flush()
+		if err := e.csv.Error(); err != nil {
+			return err
+		}
+	}
+	e.flush()
+	e.extendDeadline()
+	return nil
+}
+
+// extendDeadline gives next page exportWriteTimeout to be written.
+func (e *exporter) extendDeadline() {
+	setWriteDeadline(e.w, time.Now().Add(exportWriteTimeout))
+}
+
+// setWriteDeadline changes write deadline of connection, writers wrapped by
+// middlewares are unwrapped as http.ResponseController does. Writers without
+// deadline support, eg. in tests, are left as they are.
+func setWriteDeadline(w http.ResponseWriter, deadline time.Time) {
+	for {
+		switch t := w.(type) {
+		case interface{ SetWriteDeadline(time.Time) error }:
+			_ = t.SetWriteDeadline(deadline)
+			return
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = t.Unwrap()
+		default:
+			return
+		}
+	}
+}
+
+// exportMessages streams all messages matching query, walking through pages server side.
+// Response is started with the first page, so failure to fetch it is reported
+// as error status instead of empty export.
+func (h *Http) exportMessages(format string, r *http.Request, q *model.QueryRequest, w http.ResponseWriter) {
+	q.Limit = []uint{exportPageSize}
+	q.Cursor = nil
+
+	var e *exporter
+	start := func() error {
+		e = newExporter(format, "messages", w)
+		return e.header("id", "created_at", "user_name", "text", "hashtags")
+	}
+	err := h.search.WalkMessages(r.Context(), q, func(msgs []*model.Message) error {
+		if e == nil {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		for _, m := range msgs {
+			if err := e.write(m,
+				m.ID,
+				m.CreatedAt.UTC().Format(time.RFC3339Nano),
+				m.User.Name,
+				m.Text,
+				strings.Join(m.Hashtags, " "),
+			); err != nil {
+				return err
+			}
+		}
+		return e.done()
+	})
+	switch {
+	case err != nil && e == nil:
+		h.writeError(err, w)
+	case err != nil:
+		// Headers are already sent, client would notice truncated stream.
+		h.log.Error().Err(err).Msg("export: failed to stream messages")
+	case e == nil:
+		// No messages matched, export has only header.
+		err := start()
+		if err == nil {
+			err = e.done()
+		}
+		if err != nil {
+			h.log.Error().Err(err).Msg("export: failed to write header")
+		}
+	}
+}
+
+// exportTrends streams trend buckets.
+func (h *Http) exportTrends(format string, r *http.Request, q *model.QueryRequest, w http.ResponseWriter) {
+	resp, err := h.search.Trends(r.Context(), q)
+	if err != nil {
+		h.writeError(err, w)
+		return
+	}
+	e := newExporter(format, "trends", w)
+	if err := e.header("from_date", "to_date", "count"); err != nil {
+		h.log.Error().Err(err).Msg("export: failed to write header")
+		return
+	}
+	for _, t := range resp.Trends {
+		if err := e.write(t,
+			t.FromDate.UTC().Format(time.RFC3339),
+			t.ToDate.UTC().Format(time.RFC3339),
+			strconv.FormatUint(uint64(t.Count), 10),
+		); err != nil {
+			h.log.Error().Err(err).Msg("export: failed to stream trends")
+			return
+		}
+	}
+	if err := e.done(); err != nil {
+		h.log.Error().Err(err).Msg("export: failed to stream trends")
+	}
+}
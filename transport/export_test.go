@@ -0,0 +1,180 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jozuenoon/dunder/metrics"
+	"github.com/jozuenoon/dunder/model"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
+)
+
+// pagedSearch returns messages in pages of two.
+type pagedSearch struct {
+	msgs []*model.Message
+}
+
+func (s *pagedSearch) Messages(context.Context, *model.QueryRequest) (*model.QueryResponse, error) {
+	return &model.QueryResponse{Messages: s.msgs}, nil
+}
+
+func (s *pagedSearch) Trends(context.Context, *model.QueryRequest) (*model.QueryResponse, error) {
+	return &model.QueryResponse{Trends: []*model.Trend{
+		{FromDate: time.Date(2019, 9, 22, 10, 0, 0, 0, time.UTC), ToDate: time.Date(2019, 9, 22, 11, 0, 0, 0, time.UTC), Count: 3},
+	}}, nil
+}
+
+func (s *pagedSearch) WalkMessages(ctx context.Context, q *model.QueryRequest, fn func([]*model.Message) error) error {
+	for i := 0; i < len(s.msgs); i += 2 {
+		end := i + 2
+		if end > len(s.msgs) {
+			end = len(s.msgs)
+		}
+		if err := fn(s.msgs[i:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestHttp_Export(t *testing.T) {
+	created := time.Date(2019, 9, 22, 10, 0, 0, 0, time.UTC)
+	search := &pagedSearch{msgs: []*model.Message{
+		{ID: "3", User: model.User{Name: "john"}, Text: "third, with comma", CreatedAt: created, Hashtags: []string{"a", "b"}},
+		{ID: "2", User: model.User{Name: "ala"}, Text: "second", CreatedAt: created},
+		{ID: "1", User: model.User{Name: "john"}, Text: "first", CreatedAt: created},
+	}}
+	log := zerolog.Nop()
+	h := NewHttp(nil, search, nil, nil, &log)
+
+	t.Run("messages csv", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		h.MessageQuery(rec, httptest.NewRequest(http.MethodGet, "/message?format=csv", nil))
+		assert.Equal(t, "text/csv; charset=utf-8", rec.Header().Get("Content-Type"))
+		assert.Equal(t, strings.Join([]string{
+			"id,created_at,user_name,text,hashtags",
+			`3,2019-09-22T10:00:00Z,john,"third, with comma",a b`,
+			"2,2019-09-22T10:00:00Z,ala,second,",
+			"1,2019-09-22T10:00:00Z,john,first,",
+		}, "\n")+"\n", rec.Body.String())
+	})
+
+	t.Run("messages ndjson", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/message", nil)
+		r.Header.Set("Accept", "application/x-ndjson")
+		h.MessageQuery(rec, r)
+		lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+		assert.Len(t, lines, 3)
+		assert.Contains(t, lines[0], `"id":"3"`)
+	})
+
+	t.Run("trends csv", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		h.Trends(rec, httptest.NewRequest(http.MethodGet, "/trend?format=csv", nil))
+		assert.Equal(t, "from_date,to_date,count\n2019-09-22T10:00:00Z,2019-09-22T11:00:00Z,3\n", rec.Body.String())
+	})
+}
+
+// failingSearch fails to fetch first page.
+type failingSearch struct {
+	pagedSearch
+}
+
+func (s *failingSearch) WalkMessages(context.Context, *model.QueryRequest, func([]*model.Message) error) error {
+	return errors.New("database is unavailable")
+}
+
+func TestHttp_Export_Errors(t *testing.T) {
+	log := zerolog.Nop()
+
+	t.Run("failed search", func(t *testing.T) {
+		h := NewHttp(nil, &failingSearch{}, nil, nil, &log)
+		rec := httptest.NewRecorder()
+		h.MessageQuery(rec, httptest.NewRequest(http.MethodGet, "/message?format=csv", nil))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Empty(t, rec.Header().Get("Content-Disposition"))
+		assert.Contains(t, rec.Body.String(), "database is unavailable")
+	})
+
+	t.Run("no messages", func(t *testing.T) {
+		h := NewHttp(nil, &pagedSearch{}, nil, nil, &log)
+		rec := httptest.NewRecorder()
+		h.MessageQuery(rec, httptest.NewRequest(http.MethodGet, "/message?format=csv", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "id,created_at,user_name,text,hashtags\n", rec.Body.String())
+	})
+}
+
+// gatedSearch writes next page only after previous one reached client.
+type gatedSearch struct {
+	pagedSearch
+	received chan struct{}
+	delay    time.Duration
+}
+
+func (s *gatedSearch) WalkMessages(ctx context.Context, q *model.QueryRequest, fn func([]*model.Message) error) error {
+	for i, m := range s.msgs {
+		if i > 0 {
+			select {
+			case <-s.received:
+			case <-time.After(time.Second):
+				return errors.New("page was not streamed to client")
+			}
+			time.Sleep(s.delay)
+		}
+		if err := fn([]*model.Message{m}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestHttp_Export_Middleware(t *testing.T) {
+	search := &gatedSearch{
+		pagedSearch: pagedSearch{msgs: []*model.Message{{ID: "3"}, {ID: "2"}, {ID: "1"}}},
+		received:    make(chan struct{}),
+		delay:       100 * time.Millisecond,
+	}
+	log := zerolog.Nop()
+	h := NewHttp(nil, search, nil, nil, &log)
+
+	// Same chain as service uses, with write timeout shorter than whole export.
+	r := mux.NewRouter()
+	r.Use(otelmux.Middleware("dunder"))
+	r.Use(metrics.Middleware)
+	r.Handle("/message", h.IPRateLimit(NewRateLimiter(10, 10), false, nil)(http.HandlerFunc(h.MessageQuery)))
+	srv := httptest.NewUnstartedServer(r)
+	srv.Config.WriteTimeout = 150 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/message?format=ndjson")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var ids []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var m model.Message
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &m))
+		ids = append(ids, m.ID)
+		select {
+		case search.received <- struct{}{}:
+		default:
+		}
+	}
+	require.NoError(t, scanner.Err())
+	assert.Equal(t, []string{"3", "2", "1"}, ids)
+}
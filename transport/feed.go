@@ -0,0 +1,317 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jozuenoon/dunder/model"
+)
+
+const (
+	formatAtom     = "atom"
+	formatRSS      = "rss"
+	formatJSONFeed = "json"
+
+	contentTypeAtom     = "application/atom+xml"
+	contentTypeRSS      = "application/rss+xml"
+	contentTypeJSONFeed = "application/feed+json"
+)
+
+// feedFormat picks feed format from `format` query parameter or `Accept` header.
+// Empty string means regular JSON API response.
+func feedFormat(r *http.Request) string {
+	switch f := strings.ToLower(r.Form.Get("format")); f {
+	case formatAtom, formatRSS, formatJSONFeed:
+		return f
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case contentTypeAtom:
+			return formatAtom
+		case contentTypeRSS:
+			return formatRSS
+		case contentTypeJSONFeed:
+			return formatJSONFeed
+		}
+	}
+	return ""
+}
+
+// feed holds data common for all feed formats.
+type feed struct {
+	title   string
+	link    string
+	self    string
+	updated time.Time
+	base    string
+	msgs    []*model.Message
+}
+
+func newFeed(base string, r *http.Request, q *model.QueryRequest, msgs []*model.Message) *feed {
+	title := "Dunder messages"
+	switch {
+	case len(q.Rules.Hashtag) > 0 && len(q.Rules.UserName) > 0:
+		title = fmt.Sprintf("Dunder #%s messages by %s", q.Rules.Hashtag[0], q.Rules.UserName[0])
+	case len(q.Rules.Hashtag) > 0:
+		title = fmt.Sprintf("Dunder #%s", q.Rules.Hashtag[0])
+	case len(q.Rules.UserName) > 0:
+		title = fmt.Sprintf("Dunder messages by %s", q.Rules.UserName[0])
+	}
+
+	link := *r.URL
+	query := link.Query()
+	query.Del("format")
+	link.RawQuery = query.Encode()
+
+	updated := time.Now()
+	if len(msgs) > 0 {
+		updated = msgs[0].CreatedAt
+	}
+
+	return &feed{
+		title:   title,
+		link:    base + link.RequestURI(),
+		self:    base + r.URL.RequestURI(),
+		updated: updated,
+		base:    base,
+		msgs:    msgs,
+	}
+}
+
+func (f *feed) messageLink(m *model.Message) string {
+	return f.base + "/message/" + m.ID
+}
+
+// messageID is stable entry id based on message ulid.
+func messageID(m *model.Message) string {
+	return "urn:ulid:" + m.ID
+}
+
+func messageTitle(m *model.Message) string {
+	title := m.Text
+	if i := strings.IndexByte(title, '\n'); i >= 0 {
+		title = title[:i]
+	}
+	if r := []rune(title); len(r) > 80 {
+		title = string(r[:77]) + "..."
+	}
+	return title
+}
+
+// feedBaseURL returns base of links in feeds. Feeds are cached by readers, so
+// without configured base URL links point only at scheme and host the request
+// reached, `X-Forwarded-Proto` set by any client is ignored.
+func (h *Http) feedBaseURL(r *http.Request) string {
+	if h.baseURL != "" {
+		return h.baseURL
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+func (h *Http) writeFeed(format string, f *feed, w http.ResponseWriter) {
+	var buf bytes.Buffer
+	var err error
+	var contentType string
+	switch format {
+	case formatAtom:
+		contentType = contentTypeAtom
+		err = encodeXML(&buf, f.atom())
+	case formatRSS:
+		contentType = contentTypeRSS
+		err = encodeXML(&buf, f.rss())
+	default:
+		contentType = contentTypeJSONFeed
+		err = json.NewEncoder(&buf).Encode(f.jsonFeed())
+	}
+	if err != nil {
+		h.writeError(err, w)
+		return
+	}
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	h.writeResponse(&buf, w)
+}
+
+func encodeXML(buf *bytes.Buffer, v interface{}) error {
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(buf)
+	enc.Indent("", "  ")
+	return enc.Encode(v)
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Updated    string         `xml:"updated"`
+	Published  string         `xml:"published"`
+	Author     atomAuthor     `xml:"author"`
+	Link       atomLink       `xml:"link"`
+	Content    atomContent    `xml:"content"`
+	Categories []atomCategory `xml:"category"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+func (f *feed) atom() *atomFeed {
+	out := &atomFeed{
+		ID:      f.link,
+		Title:   f.title,
+		Updated: f.updated.UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: f.link, Rel: "alternate"},
+			{Href: f.self, Rel: "self"},
+		},
+	}
+	for _, m := range f.msgs {
+		entry := atomEntry{
+			ID:        messageID(m),
+			Title:     messageTitle(m),
+			Updated:   m.CreatedAt.UTC().Format(time.RFC3339),
+			Published: m.CreatedAt.UTC().Format(time.RFC3339),
+			Author:    atomAuthor{Name: m.User.Name},
+			Link:      atomLink{Href: f.messageLink(m)},
+			Content:   atomContent{Type: "text", Body: m.Text},
+		}
+		for _, tag := range m.Hashtags {
+			entry.Categories = append(entry.Categories, atomCategory{Term: tag})
+		}
+		out.Entries = append(out.Entries, entry)
+	}
+	return out
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	GUID        rssGUID  `xml:"guid"`
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	Description string   `xml:"description"`
+	Author      string   `xml:"author,omitempty"`
+	PubDate     string   `xml:"pubDate"`
+	Categories  []string `xml:"category"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+func (f *feed) rss() *rssFeed {
+	out := &rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:         f.title,
+			Link:          f.link,
+			Description:   f.title,
+			LastBuildDate: f.updated.UTC().Format(time.RFC1123Z),
+		},
+	}
+	for _, m := range f.msgs {
+		out.Channel.Items = append(out.Channel.Items, rssItem{
+			GUID:        rssGUID{Value: messageID(m)},
+			Title:       messageTitle(m),
+			Link:        f.messageLink(m),
+			Description: m.Text,
+			Author:      m.User.Name,
+			PubDate:     m.CreatedAt.UTC().Format(time.RFC1123Z),
+			Categories:  m.Hashtags,
+		})
+	}
+	return out
+}
+
+// jsonFeed follows https://jsonfeed.org/version/1.1
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url,omitempty"`
+	FeedURL     string         `json:"feed_url,omitempty"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonFeedItem struct {
+	ID            string           `json:"id"`
+	URL           string           `json:"url,omitempty"`
+	Title         string           `json:"title,omitempty"`
+	ContentText   string           `json:"content_text"`
+	DatePublished string           `json:"date_published,omitempty"`
+	Authors       []jsonFeedAuthor `json:"authors,omitempty"`
+	Tags          []string         `json:"tags,omitempty"`
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+}
+
+func (f *feed) jsonFeed() *jsonFeed {
+	out := &jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       f.title,
+		HomePageURL: f.link,
+		FeedURL:     f.self,
+		Items:       []jsonFeedItem{},
+	}
+	for _, m := range f.msgs {
+		out.Items = append(out.Items, jsonFeedItem{
+			ID:            messageID(m),
+			URL:           f.messageLink(m),
+			Title:         messageTitle(m),
+			ContentText:   m.Text,
+			DatePublished: m.CreatedAt.UTC().Format(time.RFC3339),
+			Authors:       []jsonFeedAuthor{{Name: m.User.Name}},
+			Tags:          m.Hashtags,
+		})
+	}
+	return out
+}
@@ -0,0 +1,101 @@
+package transport
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jozuenoon/dunder/model"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var feedMessages = []*model.Message{
+	{
+		ID:        "01DNRV3ZYE8N5FBR9H7DQ4XZ7J",
+		User:      model.User{Name: "john@example.com"},
+		Text:      "v1.2.0 released",
+		Hashtags:  []string{"release"},
+		CreatedAt: time.Date(2019, 9, 22, 10, 0, 0, 0, time.UTC),
+	},
+}
+
+func TestFeedFormat(t *testing.T) {
+	tests := []struct {
+		url    string
+		accept string
+		want   string
+	}{
+		{url: "/message?hashtag=release", want: ""},
+		{url: "/message?format=atom", want: formatAtom},
+		{url: "/message?format=RSS", want: formatRSS},
+		{url: "/message", accept: "application/feed+json", want: formatJSONFeed},
+		{url: "/message", accept: "text/html, application/atom+xml;q=0.9", want: formatAtom},
+		{url: "/message", accept: "application/json", want: ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+		r.Header.Set("Accept", tt.accept)
+		require.NoError(t, r.ParseForm())
+		assert.Equal(t, tt.want, feedFormat(r), "url: %s, accept: %s", tt.url, tt.accept)
+	}
+}
+
+func TestHttp_writeFeed(t *testing.T) {
+	log := zerolog.Nop()
+	h := &Http{log: &log}
+	r := httptest.NewRequest(http.MethodGet, "https://dunder.io/message?hashtag=release&format=atom", nil)
+	q := &model.QueryRequest{Rules: model.QueryRules{Hashtag: []string{"release"}}}
+	f := newFeed(h.feedBaseURL(r), r, q, feedMessages)
+
+	t.Run("atom", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		h.writeFeed(formatAtom, f, rec)
+		assert.Equal(t, "application/atom+xml; charset=utf-8", rec.Header().Get("Content-Type"))
+		var out atomFeed
+		require.NoError(t, xml.Unmarshal(rec.Body.Bytes(), &out))
+		assert.Equal(t, "Dunder #release", out.Title)
+		require.Len(t, out.Entries, 1)
+		assert.Equal(t, "urn:ulid:01DNRV3ZYE8N5FBR9H7DQ4XZ7J", out.Entries[0].ID)
+		assert.Equal(t, "https://dunder.io/message/01DNRV3ZYE8N5FBR9H7DQ4XZ7J", out.Entries[0].Link.Href)
+		assert.Equal(t, "2019-09-22T10:00:00Z", out.Entries[0].Published)
+	})
+
+	t.Run("rss", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		h.writeFeed(formatRSS, f, rec)
+		var out rssFeed
+		require.NoError(t, xml.Unmarshal(rec.Body.Bytes(), &out))
+		require.Len(t, out.Channel.Items, 1)
+		assert.Equal(t, "https://dunder.io/message?hashtag=release", out.Channel.Link)
+		assert.Equal(t, []string{"release"}, out.Channel.Items[0].Categories)
+		assert.Equal(t, "urn:ulid:01DNRV3ZYE8N5FBR9H7DQ4XZ7J", out.Channel.Items[0].GUID.Value)
+	})
+
+	t.Run("json feed", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		h.writeFeed(formatJSONFeed, f, rec)
+		var out jsonFeed
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &out))
+		require.Len(t, out.Items, 1)
+		assert.Equal(t, "urn:ulid:01DNRV3ZYE8N5FBR9H7DQ4XZ7J", out.Items[0].ID)
+		assert.Equal(t, "v1.2.0 released", out.Items[0].ContentText)
+		assert.Equal(t, "john@example.com", out.Items[0].Authors[0].Name)
+	})
+}
+
+func TestHttp_feedBaseURL(t *testing.T) {
+	log := zerolog.Nop()
+	h := &Http{log: &log}
+	r := httptest.NewRequest(http.MethodGet, "http://dunder.io/message?format=atom", nil)
+	r.Header.Set("X-Forwarded-Proto", "gopher")
+	assert.Equal(t, "http://dunder.io", h.feedBaseURL(r), "forwarded proto set by client is ignored")
+
+	h.SetBaseURL("https://feeds.dunder.io/")
+	r.Host = "evil.example.com"
+	assert.Equal(t, "https://feeds.dunder.io", h.feedBaseURL(r))
+}
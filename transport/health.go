@@ -0,0 +1,43 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/jozuenoon/dunder/model"
+)
+
+const healthCheckTimeout = 2 * time.Second
+
+// Liveness handles `/healthz` probe.
+func (h *Http) Liveness(w http.ResponseWriter, r *http.Request) {
+	resp, err := h.health.Liveness(r.Context())
+	h.writeHealth(resp, err, w)
+}
+
+// Readiness handles `/readyz` probe.
+func (h *Http) Readiness(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+	defer cancel()
+	resp, err := h.health.Readiness(ctx)
+	h.writeHealth(resp, err, w)
+}
+
+func (h *Http) writeHealth(resp *model.HealthResponse, err error, w http.ResponseWriter) {
+	msg := &Response{Data: resp}
+	if err != nil {
+		msg.Error = err.Error()
+	}
+	var buf bytes.Buffer
+	if err1 := json.NewEncoder(&buf).Encode(msg); err1 != nil {
+		h.writeError(err1, w)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	h.writeResponse(&buf, w)
+}
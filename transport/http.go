@@ -8,6 +8,7 @@ import (
 	"github.com/gorilla/mux"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -20,18 +21,39 @@ import (
 	"github.com/jozuenoon/dunder/service"
 )
 
-func NewHttp(dunder service.Dunder, search service.DunderSearch, log *zerolog.Logger) *Http {
+const (
+	// IdempotencyKeyHeader makes message creation safe to retry, requests of
+	// the same user with the same key create single message.
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader marks response returned for repeated key.
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength  = 255
+)
+
+func NewHttp(dunder service.Dunder, search service.DunderSearch, health service.Health, webhooks service.Webhooks, log *zerolog.Logger) *Http {
 	return &Http{
-		dunder: dunder,
-		search: search,
-		log:    log,
+		dunder:   dunder,
+		search:   search,
+		health:   health,
+		webhooks: webhooks,
+		log:      log,
 	}
 }
 
 type Http struct {
-	dunder service.Dunder
-	search service.DunderSearch
-	log    *zerolog.Logger
+	dunder   service.Dunder
+	search   service.DunderSearch
+	health   service.Health
+	webhooks service.Webhooks
+	log      *zerolog.Logger
+	// baseURL is public URL of service used in links of feeds.
+	baseURL string
+}
+
+// SetBaseURL sets public URL of service, eg. `https://dunder.io`, used in links
+// of feeds instead of host of request.
+func (h *Http) SetBaseURL(baseURL string) {
+	h.baseURL = strings.TrimSuffix(baseURL, "/")
 }
 
 func (h *Http) CreateMessage(w http.ResponseWriter, r *http.Request) {
@@ -42,18 +64,17 @@ func (h *Http) CreateMessage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token := r.Header.Get("authorization")
-	if token == "" {
-		h.writeError(unauthorized, w)
-		return
-	}
-	user, err := h.userFromBearerToken(token)
+	user, err := h.authenticate(r)
 	if err != nil {
 		h.writeError(err, w)
 		return
 	}
-	ctx := context.Background()
-	resp, err := h.dunder.CreateMessage(ctx, user, &req)
+	req.IdempotencyKey = r.Header.Get(IdempotencyKeyHeader)
+	if len(req.IdempotencyKey) > maxIdempotencyKeyLength {
+		h.writeError(errIdempotencyKeyTooLong, w)
+		return
+	}
+	resp, err := h.dunder.CreateMessage(r.Context(), user, &req)
 	if err != nil {
 		h.writeError(err, w)
 		return
@@ -63,10 +84,46 @@ func (h *Http) CreateMessage(w http.ResponseWriter, r *http.Request) {
 		h.writeError(err, w)
 		return
 	}
+	if resp.Replayed {
+		w.Header().Set(IdempotentReplayedHeader, "true")
+	}
 	w.WriteHeader(http.StatusCreated)
 	h.writeResponse(buf, w)
 }
 
+// EditMessage replaces text of message posted by authenticated user.
+func (h *Http) EditMessage(w http.ResponseWriter, r *http.Request) {
+	user, err := h.authenticate(r)
+	if err != nil {
+		h.writeError(err, w)
+		return
+	}
+	var req model.EditMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(err, w)
+		return
+	}
+	if err := h.dunder.EditMessage(r.Context(), user, mux.Vars(r)["ulid"], &req); err != nil {
+		h.writeError(err, w)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteMessage removes message posted by authenticated user.
+func (h *Http) DeleteMessage(w http.ResponseWriter, r *http.Request) {
+	user, err := h.authenticate(r)
+	if err != nil {
+		h.writeError(err, w)
+		return
+	}
+	if err := h.dunder.DeleteMessage(r.Context(), user, mux.Vars(r)["ulid"]); err != nil {
+		h.writeError(err, w)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func (h Http) MessageQuery(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
@@ -76,13 +133,13 @@ func (h Http) MessageQuery(w http.ResponseWriter, r *http.Request) {
 	// Unary query from path
 	vars := mux.Vars(r)
 	if ulid, ok := vars["ulid"]; ok {
-		h.unaryMessageQuery(ulid, w)
+		h.unaryMessageQuery(r.Context(), ulid, w)
 		return
 	}
 
 	// Unary query parameters
 	if mulid := r.Form.Get("ulid"); mulid != "" {
-		h.unaryMessageQuery(mulid, w)
+		h.unaryMessageQuery(r.Context(), mulid, w)
 		return
 	}
 
@@ -92,11 +149,20 @@ func (h Http) MessageQuery(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	resp, err := h.search.Messages(context.Background(), q)
+	if format := exportFormat(r); format != "" {
+		h.exportMessages(format, r, q, w)
+		return
+	}
+
+	resp, err := h.search.Messages(r.Context(), q)
 	if err != nil {
 		h.writeError(err, w)
 		return
 	}
+	if format := feedFormat(r); format != "" {
+		h.writeFeed(format, newFeed(h.feedBaseURL(r), r, q, resp.Messages), w)
+		return
+	}
 	buf, err := h.prepareResponse(resp)
 	if err != nil {
 		h.writeError(err, w)
@@ -105,8 +171,8 @@ func (h Http) MessageQuery(w http.ResponseWriter, r *http.Request) {
 	h.writeResponse(buf, w)
 }
 
-func (h Http) unaryMessageQuery(mulid string, w http.ResponseWriter) {
-	resp, err := h.dunder.GetMessage(context.Background(), &model.GetMessageRequest{ID: mulid})
+func (h Http) unaryMessageQuery(ctx context.Context, mulid string, w http.ResponseWriter) {
+	resp, err := h.dunder.GetMessage(ctx, &model.GetMessageRequest{ID: mulid})
 	if err != nil {
 		h.writeError(err, w)
 		return
@@ -131,7 +197,12 @@ func (h Http) Trends(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	resp, err := h.search.Trends(context.Background(), q)
+	if format := exportFormat(r); format != "" {
+		h.exportTrends(format, r, q, w)
+		return
+	}
+
+	resp, err := h.search.Trends(r.Context(), q)
 	if err != nil {
 		h.writeError(err, w)
 		return
@@ -177,16 +248,22 @@ func parseQuery(vals url.Values) (*model.QueryRequest, error) {
 	if err := json.NewDecoder(&buf).Decode(&flat); err != nil {
 		return nil, err
 	}
+	for _, c := range flat.Consistency {
+		if c != model.ConsistencyStrong && c != model.ConsistencyStale {
+			return nil, errInvalidConsistency
+		}
+	}
 	return &model.QueryRequest{
 		FromDate: toTime(flat.FromDate),
 		ToDate:   toTime(flat.ToDate),
-		Limit:    flat.Limit,
+		Limit:    toUint(flat.Limit),
 		Cursor:   flat.Cursor,
 		Rules: model.QueryRules{
 			UserName:    flat.UserName,
 			Hashtag:     flat.Hashtag,
 			Aggregation: toDuration(flat.Aggregation),
 		},
+		Consistency: flat.Consistency,
 	}, nil
 }
 
@@ -206,6 +283,14 @@ func toDuration(in []Duration) []time.Duration {
 	return tt
 }
 
+func toUint(in []Uint) []uint {
+	var tt []uint
+	for _, t := range in {
+		tt = append(tt, uint(t))
+	}
+	return tt
+}
+
 type DateTime time.Time
 
 func (d *DateTime) UnmarshalJSON(b []byte) error {
@@ -238,12 +323,25 @@ func (d *Duration) UnmarshalJSON(b []byte) error {
 	}
 }
 
+// Uint accepts both JSON numbers and strings as query values are always strings.
+type Uint uint
+
+func (u *Uint) UnmarshalJSON(b []byte) error {
+	v, err := strconv.ParseUint(strings.Trim(string(b), "\""), 10, 0)
+	if err != nil {
+		return err
+	}
+	*u = Uint(v)
+	return nil
+}
+
 type flatQuery struct {
 	FromDate    []DateTime `json:"from_date,omitempty"`
 	ToDate      []DateTime `json:"to_date,omitempty"`
-	Limit       []uint     `json:"limit,omitempty"`
+	Limit       []Uint     `json:"limit,omitempty"`
 	Cursor      []string   `json:"cursor,omitempty"`
 	UserName    []string   `json:"user_name,omitempty"`
 	Hashtag     []string   `json:"hashtag,omitempty"`
 	Aggregation []Duration `json:"aggregation,omitempty"`
+	Consistency []string   `json:"consistency,omitempty"`
 }
@@ -0,0 +1,122 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+	"github.com/jozuenoon/dunder/model"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+// replayingDunder reports every keyed request as replayed.
+type replayingDunder struct {
+	key string
+	// edited holds texts of edited messages of user "john", other messages are not found.
+	edited map[string]string
+}
+
+func (d *replayingDunder) CreateMessage(ctx context.Context, user string, req *model.CreateMessageRequest) (*model.CreateMessageResponse, error) {
+	d.key = req.IdempotencyKey
+	return &model.CreateMessageResponse{ID: "01DQ", Replayed: req.IdempotencyKey != ""}, nil
+}
+
+// CreateMessages fails messages with text "fail", keyed messages are replayed.
+func (d *replayingDunder) CreateMessages(ctx context.Context, user string, msgs []*model.BatchMessage) ([]*model.BatchMessageResult, error) {
+	var results []*model.BatchMessageResult
+	for i, m := range msgs {
+		switch {
+		case m.Text == "fail":
+			results = append(results, &model.BatchMessageResult{Error: "failed"})
+		default:
+			results = append(results, &model.BatchMessageResult{ID: fmt.Sprintf("01DQ%d", i), Replayed: m.IdempotencyKey != ""})
+		}
+	}
+	return results, nil
+}
+
+func (d *replayingDunder) GetMessage(context.Context, *model.GetMessageRequest) (*model.GetMessageResponse, error) {
+	return nil, nil
+}
+
+func (d *replayingDunder) EditMessage(ctx context.Context, user, ulid string, req *model.EditMessageRequest) error {
+	if user != "john" {
+		return gorm.ErrRecordNotFound
+	}
+	if d.edited == nil {
+		d.edited = map[string]string{}
+	}
+	d.edited[ulid] = req.Text
+	return nil
+}
+
+func (d *replayingDunder) DeleteMessage(ctx context.Context, user, ulid string) error {
+	if user != "john" {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func TestHttp_CreateMessage_IdempotencyKey(t *testing.T) {
+	log := zerolog.Nop()
+	dunder := &replayingDunder{}
+	h := NewHttp(dunder, nil, nil, nil, &log)
+
+	post := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/message", strings.NewReader(`{"text":"build ok"}`))
+		req.Header.Set("Authorization", "Bearer am9obg==")
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		rec := httptest.NewRecorder()
+		h.CreateMessage(rec, req)
+		return rec
+	}
+
+	rec := post("build-1")
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, "build-1", dunder.key)
+	assert.Equal(t, "true", rec.Header().Get(IdempotentReplayedHeader))
+	assert.JSONEq(t, `{"data":{"id":"01DQ"}}`, rec.Body.String())
+
+	rec = post("")
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Empty(t, rec.Header().Get(IdempotentReplayedHeader))
+
+	rec = post(strings.Repeat("k", maxIdempotencyKeyLength+1))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestHttp_EditDeleteMessage(t *testing.T) {
+	log := zerolog.Nop()
+	dunder := &replayingDunder{}
+	h := NewHttp(dunder, nil, nil, nil, &log)
+	r := mux.NewRouter()
+	for _, route := range h.Routes() {
+		r.Handle(route.Path, route.Handler).Methods(route.Method)
+	}
+
+	do := func(method, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/message/01DQ", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// am9obg== is "john", YWxh is "ala".
+	rec := do(http.MethodPatch, "am9obg==", `{"text":"fixed typo"}`)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, map[string]string{"01DQ": "fixed typo"}, dunder.edited)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPatch, "YWxh", `{"text":"x"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPatch, "am9obg==", `{`).Code)
+
+	assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, "am9obg==", "").Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodDelete, "YWxh", "").Code)
+}
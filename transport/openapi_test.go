@@ -0,0 +1,154 @@
+package transport
+
+import (
+	"encoding/json"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/jozuenoon/dunder/model"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type spec struct {
+	Paths      map[string]map[string]operation `json:"paths"`
+	Components struct {
+		Parameters map[string]parameter `json:"parameters"`
+		Schemas    map[string]struct {
+			Properties map[string]interface{} `json:"properties"`
+		} `json:"schemas"`
+	} `json:"components"`
+}
+
+type operation struct {
+	Parameters []parameter `json:"parameters"`
+}
+
+type parameter struct {
+	Ref  string `json:"$ref"`
+	Name string `json:"name"`
+	In   string `json:"in"`
+}
+
+func loadSpec(t *testing.T) *spec {
+	t.Helper()
+	var s spec
+	require.NoError(t, json.Unmarshal([]byte(openAPISpec), &s), "openapi spec is not valid json")
+	return &s
+}
+
+func TestOpenAPI_Routes(t *testing.T) {
+	s := loadSpec(t)
+	log := zerolog.Nop()
+	h := NewHttp(nil, nil, nil, nil, &log)
+
+	routes := map[string]bool{}
+	for _, r := range h.Routes() {
+		key := strings.ToLower(r.Method) + " " + r.Path
+		routes[key] = true
+		_, ok := s.Paths[r.Path][strings.ToLower(r.Method)]
+		assert.True(t, ok, "route %s is not documented", key)
+	}
+	for path, ops := range s.Paths {
+		for method := range ops {
+			assert.True(t, routes[method+" "+path], "documented %s %s has no handler", method, path)
+		}
+	}
+}
+
+func TestOpenAPI_QueryParameters(t *testing.T) {
+	s := loadSpec(t)
+
+	documented := map[string]bool{}
+	for _, ops := range s.Paths {
+		for _, op := range ops {
+			for _, p := range op.Parameters {
+				if p.Ref != "" {
+					p = s.Components.Parameters[strings.TrimPrefix(p.Ref, "#/components/parameters/")]
+				}
+				if p.In == "query" {
+					documented[p.Name] = true
+				}
+			}
+		}
+	}
+
+	parsed := jsonFields(reflect.TypeOf(flatQuery{}))
+	// These queries are handled directly by handlers.
+	parsed = append(parsed, "ulid", "format", "dead")
+	for _, name := range parsed {
+		assert.True(t, documented[name], "query parameter %s is not documented", name)
+		delete(documented, name)
+	}
+	assert.Empty(t, documented, "documented query parameters are not parsed")
+}
+
+func TestOpenAPI_Schemas(t *testing.T) {
+	s := loadSpec(t)
+	types := map[string]interface{}{
+		"Response":               Response{},
+		"CreateMessageRequest":   model.CreateMessageRequest{},
+		"CreateMessageResponse":  model.CreateMessageResponse{},
+		"EditMessageRequest":     model.EditMessageRequest{},
+		"BatchMessage":           model.BatchMessage{},
+		"BatchMessageResult":     model.BatchMessageResult{},
+		"CreateMessagesResponse": model.CreateMessagesResponse{},
+		"GetMessageResponse":     model.GetMessageResponse{},
+		"QueryResponse":          model.QueryResponse{},
+		"Message":                model.Message{},
+		"User":                   model.User{},
+		"Trend":                  model.Trend{},
+		"HealthResponse":         model.HealthResponse{},
+		"Event":                  model.Event{},
+		"CreateWebhookRequest":   model.CreateWebhookRequest{},
+		"Webhook":                model.Webhook{},
+		"WebhookDelivery":        model.WebhookDelivery{},
+		"WebhooksResponse":       model.WebhooksResponse{},
+	}
+	for name, v := range types {
+		schema, ok := s.Components.Schemas[name]
+		if !assert.True(t, ok, "schema %s is missing", name) {
+			continue
+		}
+		var props []string
+		for p := range schema.Properties {
+			props = append(props, p)
+		}
+		assert.ElementsMatch(t, jsonFields(reflect.TypeOf(v)), props, "schema %s properties don't match", name)
+	}
+}
+
+// TestOpenAPI_DateFormats checks date formats listed in from_date description.
+func TestOpenAPI_DateFormats(t *testing.T) {
+	for _, date := range []string{
+		"2019-09-22",
+		"2019-09-22T10:00:00Z",
+		"2019-09-22 10:00:00",
+		"2019-09-22T10:00:00+02:00",
+		"09/22/2019",
+		"Sep 22 2019",
+		"1569146400",
+	} {
+		q, err := parseQuery(url.Values{"from_date": {date}, "limit": {"10"}, "aggregation": {"1h"}})
+		if assert.NoError(t, err, "failed to parse %s", date) {
+			assert.Equal(t, 2019, q.FromDate[0].Year(), "wrong date parsed from %s", date)
+			assert.Equal(t, []uint{10}, q.Limit)
+		}
+	}
+}
+
+func jsonFields(t reflect.Type) []string {
+	var fields []string
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			fields = append(fields, name)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
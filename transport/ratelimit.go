@@ -0,0 +1,203 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimiter keeps token bucket per key, eg. user name or client IP.
+type RateLimiter struct {
+	rate  float64
+	burst float64
+	now   func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter creates limiter refilling rate tokens per second up to burst tokens.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		now:     time.Now,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Allow takes single token from key bucket. It returns number of remaining tokens
+// or time after which request would be allowed.
+func (l *RateLimiter) Allow(key string) (ok bool, remaining int, retryAfter time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.bucket(key)
+	if b.tokens < 1 {
+		wait := (1 - b.tokens) / l.rate
+		return false, 0, time.Duration(wait * float64(time.Second))
+	}
+	b.tokens--
+	return true, int(b.tokens), 0
+}
+
+// Charge takes n tokens from key bucket of already allowed request, eg. for each
+// message of a batch. Bucket may go into debt, which is paid back by rejecting
+// following requests until it's refilled.
+func (l *RateLimiter) Charge(key string, n int) {
+	if n <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.bucket(key).tokens -= float64(n)
+}
+
+// bucket returns refilled bucket of key. Caller must hold l.mu.
+func (l *RateLimiter) bucket(key string) *bucket {
+	now := l.now()
+	l.sweep(now)
+
+	b, found := l.buckets[key]
+	if !found {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	return b
+}
+
+// sweep drops buckets which are full again, so memory is not held for idle clients.
+func (l *RateLimiter) sweep(now time.Time) {
+	refill := time.Duration(l.burst / l.rate * float64(time.Second))
+	if now.Sub(l.lastSweep) < refill {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+type rateLimitKey struct{}
+
+// rateLimitCharge charges bucket which admitted request.
+type rateLimitCharge func(n int)
+
+// chargeRateLimit takes n more tokens from bucket which admitted request, it's
+// no-op when route is not limited.
+func chargeRateLimit(ctx context.Context, n int) {
+	if charge, ok := ctx.Value(rateLimitKey{}).(rateLimitCharge); ok {
+		charge(n)
+	}
+}
+
+// UserRateLimit limits requests per authenticated user. Requests without valid
+// bearer token are passed through so handler can reject them.
+func (h *Http) UserRateLimit(limiter *RateLimiter) func(http.Handler) http.Handler {
+	return h.rateLimit(limiter, func(r *http.Request) string {
+		user, err := h.userFromBearerToken(r.Header.Get("authorization"))
+		if err != nil {
+			return ""
+		}
+		return "user:" + user
+	})
+}
+
+// IPRateLimit limits requests per client IP. When trustForwarded is set, client
+// address is taken from `X-Forwarded-For` header set by load balancer, as its
+// rightmost entry which is not one of trustedProxies.
+func (h *Http) IPRateLimit(limiter *RateLimiter, trustForwarded bool, trustedProxies []*net.IPNet) func(http.Handler) http.Handler {
+	return h.rateLimit(limiter, func(r *http.Request) string {
+		return "ip:" + clientIP(r, trustForwarded, trustedProxies)
+	})
+}
+
+func (h *Http) rateLimit(limiter *RateLimiter, key func(*http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			k := key(r)
+			if k == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ok, remaining, retryAfter := limiter.Allow(k)
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(int(limiter.burst)))
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+			if !ok {
+				seconds := int(math.Ceil(retryAfter.Seconds()))
+				w.Header().Set("Retry-After", strconv.Itoa(seconds))
+				w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(limiter.now().Add(retryAfter).Unix(), 10))
+				h.log.Debug().Str("key", k).Msg("rate limit exceeded")
+				h.writeError(tooManyRequests, w)
+				return
+			}
+			charge := rateLimitCharge(func(n int) { limiter.Charge(k, n) })
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), rateLimitKey{}, charge)))
+		})
+	}
+}
+
+// clientIP returns address of client. Proxies append to `X-Forwarded-For`, so
+// only its entries added by trusted proxies, from the right, can be believed.
+// Leftmost entries are set by client and would let it pick its own bucket.
+func clientIP(r *http.Request, trustForwarded bool, trustedProxies []*net.IPNet) string {
+	if trustForwarded {
+		var fwd []string
+		for _, h := range r.Header["X-Forwarded-For"] {
+			fwd = append(fwd, strings.Split(h, ",")...)
+		}
+		for i := len(fwd) - 1; i >= 0; i-- {
+			addr := strings.TrimSpace(fwd[i])
+			if ip := net.ParseIP(addr); ip == nil || !containsIP(trustedProxies, ip) {
+				return addr
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseCIDRs parses networks, eg. addresses of trusted proxies.
+func ParseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q: %v", c, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
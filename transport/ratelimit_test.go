@@ -0,0 +1,133 @@
+package transport
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter_Allow(t *testing.T) {
+	now := time.Unix(1570000000, 0)
+	limiter := NewRateLimiter(1, 2)
+	limiter.now = func() time.Time { return now }
+
+	ok, remaining, _ := limiter.Allow("john")
+	assert.True(t, ok)
+	assert.Equal(t, 1, remaining)
+
+	ok, remaining, _ = limiter.Allow("john")
+	assert.True(t, ok)
+	assert.Equal(t, 0, remaining)
+
+	ok, _, retryAfter := limiter.Allow("john")
+	assert.False(t, ok, "bucket should be exhausted")
+	assert.Equal(t, time.Second, retryAfter)
+
+	ok, _, _ = limiter.Allow("ala")
+	assert.True(t, ok, "other keys should have own bucket")
+
+	now = now.Add(time.Second)
+	ok, _, _ = limiter.Allow("john")
+	assert.True(t, ok, "bucket should be refilled")
+}
+
+func TestHttp_IPRateLimit(t *testing.T) {
+	log := zerolog.Nop()
+	h := &Http{log: &log}
+	now := time.Unix(1570000000, 0)
+	limiter := NewRateLimiter(1, 1)
+	limiter.now = func() time.Time { return now }
+	handler := h.IPRateLimit(limiter, false, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/trend", nil)
+	req.RemoteAddr = "10.0.0.1:5000"
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "0", rec.Header().Get("X-RateLimit-Remaining"))
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+	assert.Equal(t, "1570000001", rec.Header().Get("X-RateLimit-Reset"))
+}
+
+func TestRateLimiter_Charge(t *testing.T) {
+	now := time.Unix(1570000000, 0)
+	limiter := NewRateLimiter(1, 10)
+	limiter.now = func() time.Time { return now }
+
+	ok, _, _ := limiter.Allow("john")
+	assert.True(t, ok)
+	limiter.Charge("john", 14)
+
+	ok, _, retryAfter := limiter.Allow("john")
+	assert.False(t, ok, "charge over burst should leave bucket in debt")
+	assert.Equal(t, 6*time.Second, retryAfter)
+
+	now = now.Add(6 * time.Second)
+	ok, _, _ = limiter.Allow("john")
+	assert.True(t, ok, "debt should be paid back by refill")
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseCIDRs([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+	_, err = ParseCIDRs([]string{"10.0.0.0"})
+	assert.Error(t, err)
+
+	for _, tt := range []struct {
+		name      string
+		forwarded []string
+		trust     bool
+		want      string
+	}{
+		{name: "forwarded not trusted", forwarded: []string{"203.0.113.7"}, want: "10.0.0.1"},
+		{name: "no header", trust: true, want: "10.0.0.1"},
+		{name: "spoofed leftmost entry", forwarded: []string{"1.2.3.4, 203.0.113.7"}, trust: true, want: "203.0.113.7"},
+		{name: "trusted proxies skipped", forwarded: []string{"1.2.3.4, 203.0.113.7, 10.1.1.1", "10.2.2.2"}, trust: true, want: "203.0.113.7"},
+		{name: "only proxies", forwarded: []string{"10.1.1.1"}, trust: true, want: "10.0.0.1"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/trend", nil)
+			req.RemoteAddr = "10.0.0.1:5000"
+			for _, v := range tt.forwarded {
+				req.Header.Add("X-Forwarded-For", v)
+			}
+			assert.Equal(t, tt.want, clientIP(req, tt.trust, proxies))
+		})
+	}
+}
+
+func TestHttp_CreateMessages_RateLimit(t *testing.T) {
+	log := zerolog.Nop()
+	h := NewHttp(&replayingDunder{}, nil, nil, nil, &log)
+	limiter := NewRateLimiter(1, 10)
+	now := time.Unix(1570000000, 0)
+	limiter.now = func() time.Time { return now }
+	handler := h.UserRateLimit(limiter)(http.HandlerFunc(h.CreateMessages))
+
+	post := func(n int) int {
+		body := "[" + strings.TrimSuffix(strings.Repeat(`{"text":"a"},`, n), ",") + "]"
+		req := httptest.NewRequest(http.MethodPost, "/messages:batch", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer am9obg==")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusCreated, post(5))
+	assert.Equal(t, http.StatusCreated, post(5))
+	assert.Equal(t, http.StatusTooManyRequests, post(1), "batch should cost token per message")
+}
@@ -0,0 +1,42 @@
+package transport
+
+import "net/http"
+
+// RateLimit tells which rate limiter should guard route.
+type RateLimit int
+
+const (
+	NoLimit RateLimit = iota
+	// UserLimit limits requests per authenticated user.
+	UserLimit
+	// IPLimit limits requests per client IP.
+	IPLimit
+)
+
+// Route describes single API endpoint. Routes are documented by OpenAPI
+// specification served at `/openapi.json`.
+type Route struct {
+	Method    string
+	Path      string
+	Handler   http.HandlerFunc
+	RateLimit RateLimit
+}
+
+func (h *Http) Routes() []Route {
+	return []Route{
+		{Method: http.MethodPost, Path: "/message", Handler: h.CreateMessage, RateLimit: UserLimit},
+		{Method: http.MethodPost, Path: "/messages:batch", Handler: h.CreateMessages, RateLimit: UserLimit},
+		{Method: http.MethodGet, Path: "/message", Handler: h.MessageQuery, RateLimit: IPLimit},
+		{Method: http.MethodGet, Path: "/message/{ulid}", Handler: h.MessageQuery, RateLimit: IPLimit},
+		{Method: http.MethodPatch, Path: "/message/{ulid}", Handler: h.EditMessage, RateLimit: UserLimit},
+		{Method: http.MethodDelete, Path: "/message/{ulid}", Handler: h.DeleteMessage, RateLimit: UserLimit},
+		{Method: http.MethodGet, Path: "/trend", Handler: h.Trends, RateLimit: IPLimit},
+		{Method: http.MethodPost, Path: "/webhook", Handler: h.CreateWebhook, RateLimit: UserLimit},
+		{Method: http.MethodGet, Path: "/webhook", Handler: h.Webhooks, RateLimit: IPLimit},
+		{Method: http.MethodDelete, Path: "/webhook/{id}", Handler: h.DeleteWebhook, RateLimit: UserLimit},
+		{Method: http.MethodGet, Path: "/webhook/{id}/deliveries", Handler: h.WebhookDeliveries, RateLimit: IPLimit},
+		{Method: http.MethodGet, Path: "/healthz", Handler: h.Liveness},
+		{Method: http.MethodGet, Path: "/readyz", Handler: h.Readiness},
+		{Method: http.MethodGet, Path: "/openapi.json", Handler: h.OpenAPI},
+	}
+}
@@ -0,0 +1,87 @@
+package transport
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/jozuenoon/dunder/model"
+)
+
+func (h *Http) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	user, err := h.authenticate(r)
+	if err != nil {
+		h.writeError(err, w)
+		return
+	}
+	var req model.CreateWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(err, w)
+		return
+	}
+	resp, err := h.webhooks.CreateWebhook(r.Context(), user, &req)
+	if err != nil {
+		h.writeError(err, w)
+		return
+	}
+	buf, err := h.prepareResponse(resp)
+	if err != nil {
+		h.writeError(err, w)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	h.writeResponse(buf, w)
+}
+
+func (h *Http) Webhooks(w http.ResponseWriter, r *http.Request) {
+	user, err := h.authenticate(r)
+	if err != nil {
+		h.writeError(err, w)
+		return
+	}
+	resp, err := h.webhooks.Webhooks(r.Context(), user)
+	if err != nil {
+		h.writeError(err, w)
+		return
+	}
+	buf, err := h.prepareResponse(resp)
+	if err != nil {
+		h.writeError(err, w)
+		return
+	}
+	h.writeResponse(buf, w)
+}
+
+func (h *Http) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	user, err := h.authenticate(r)
+	if err != nil {
+		h.writeError(err, w)
+		return
+	}
+	if err := h.webhooks.DeleteWebhook(r.Context(), user, mux.Vars(r)["id"]); err != nil {
+		h.writeError(err, w)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// WebhookDeliveries returns delivery log, `dead=true` parameter limits it to dead letters.
+func (h *Http) WebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	user, err := h.authenticate(r)
+	if err != nil {
+		h.writeError(err, w)
+		return
+	}
+	deadOnly := r.URL.Query().Get("dead") == "true"
+	resp, err := h.webhooks.Deliveries(r.Context(), user, mux.Vars(r)["id"], deadOnly)
+	if err != nil {
+		h.writeError(err, w)
+		return
+	}
+	buf, err := h.prepareResponse(resp)
+	if err != nil {
+		h.writeError(err, w)
+		return
+	}
+	h.writeResponse(buf, w)
+}
@@ -0,0 +1,337 @@
+// Package webhook delivers message events to subscribed HTTP endpoints.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jozuenoon/dunder/model"
+	"github.com/jozuenoon/dunder/repository"
+	"github.com/rs/zerolog"
+)
+
+const (
+	HeaderEvent     = "X-Dunder-Event"
+	HeaderDelivery  = "X-Dunder-Delivery"
+	HeaderTimestamp = "X-Dunder-Timestamp"
+	// HeaderSignature holds `sha256=<hex>` HMAC of `<timestamp>.<body>` keyed with subscription secret.
+	HeaderSignature = "X-Dunder-Signature"
+)
+
+var ErrClosed = errors.New("webhook dispatcher closed")
+
+type Config struct {
+	Workers int
+	// QueueSize is number of due deliveries claimed at once, they wait for
+	// workers in memory.
+	QueueSize      int
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Timeout of single delivery request.
+	Timeout time.Duration
+	// PollInterval is delay between checks for due deliveries.
+	PollInterval time.Duration
+	// AllowedNetworks may be targeted by webhooks despite being internal, eg. loopback in tests.
+	AllowedNetworks []*net.IPNet
+}
+
+func NewDispatcher(store repository.WebhookStore, cfg *Config, log *zerolog.Logger) *Dispatcher {
+	guard := NewGuard(cfg.AllowedNetworks)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// Requests go straight to subscribers, so guard checks their addresses.
+	transport.Proxy = nil
+	transport.DialContext = (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   guard.control,
+	}).DialContext
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &Dispatcher{
+		store:  store,
+		cfg:    *cfg,
+		http:   &http.Client{Timeout: cfg.Timeout, Transport: transport},
+		log:    log,
+		now:    time.Now,
+		jobs:   make(chan *repository.WebhookJob),
+		wake:   make(chan struct{}, 1),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	for i := 0; i < cfg.Workers; i++ {
+		d.wg.Add(1)
+		go d.worker()
+	}
+	go d.run(ctx)
+	return d
+}
+
+// Dispatcher fans out events to matching subscriptions and delivers them with
+// retries and exponential backoff. Deliveries are stored as jobs before event
+// is accepted, retries are scheduled in store, so neither crash nor restart
+// loses them. Every attempt is recorded in delivery log, last failed attempt
+// is marked as dead letter.
+type Dispatcher struct {
+	store repository.WebhookStore
+	cfg   Config
+	http  *http.Client
+	log   *zerolog.Logger
+	now   func() time.Time
+
+	mu     sync.RWMutex
+	closed bool
+	jobs   chan *repository.WebhookJob
+	wake   chan struct{}
+	wg     sync.WaitGroup
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Publish schedules event delivery to all matching subscriptions.
+func (d *Dispatcher) Publish(ctx context.Context, event *model.Event) error {
+	// Archived messages are still readable and restored ones were published
+	// by instance they come from, subscribers aren't notified.
+	if event.Message == nil || event.Type == model.EventMessageArchived || event.Type == model.EventMessageRestored {
+		return nil
+	}
+	d.mu.RLock()
+	closed := d.closed
+	d.mu.RUnlock()
+	if closed {
+		return ErrClosed
+	}
+
+	subs, err := d.store.MatchingWebhooks(ctx, event.Message.User.Name, event.Message.Hashtags)
+	if err != nil {
+		return err
+	}
+	if len(subs) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	now := d.now()
+	jobs := make([]*repository.WebhookJob, 0, len(subs))
+	for _, sub := range subs {
+		jobs = append(jobs, &repository.WebhookJob{
+			CreatedAt:       now,
+			SubscriptionRef: sub.ID,
+			EventID:         event.ID,
+			EventType:       event.Type,
+			Payload:         string(body),
+			NextAttemptAt:   now,
+		})
+	}
+	if err := d.store.EnqueueDeliveries(ctx, jobs); err != nil {
+		return err
+	}
+	// Don't wait for next poll with fresh deliveries.
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Close stops accepting events and waits for deliveries in progress. Scheduled
+// deliveries stay in store and are sent after restart.
+func (d *Dispatcher) Close(ctx context.Context) error {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return nil
+	}
+	d.closed = true
+	d.mu.Unlock()
+
+	d.cancel()
+	select {
+	case <-d.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run claims due deliveries and hands them to workers until cancelled.
+func (d *Dispatcher) run(ctx context.Context) {
+	defer close(d.done)
+	defer d.wg.Wait()
+	defer close(d.jobs)
+
+	ticker := time.NewTicker(d.cfg.PollInterval)
+	defer ticker.Stop()
+	for {
+		for {
+			n, err := d.claim(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					d.log.Error().Err(err).Msg("webhook: failed to claim due deliveries")
+				}
+				break
+			}
+			if n < d.cfg.QueueSize {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+func (d *Dispatcher) claim(ctx context.Context) (int, error) {
+	jobs, err := d.store.DueDeliveries(ctx, d.now(), d.lease(), uint(d.cfg.QueueSize))
+	if err != nil {
+		return 0, err
+	}
+	for i, j := range jobs {
+		select {
+		case d.jobs <- j:
+		case <-ctx.Done():
+			d.release(jobs[i:])
+			return i, ctx.Err()
+		}
+	}
+	return len(jobs), nil
+}
+
+// lease is time claimed deliveries have to be sent, it covers wait behind
+// other claimed deliveries and request itself.
+func (d *Dispatcher) lease() time.Duration {
+	return d.cfg.Timeout * time.Duration(d.cfg.QueueSize/d.cfg.Workers+2)
+}
+
+// release makes claimed deliveries due again, so other instance doesn't wait
+// for their lease to pass.
+func (d *Dispatcher) release(jobs []*repository.WebhookJob) {
+	for _, j := range jobs {
+		if err := d.store.RescheduleDelivery(context.Background(), j.ID, j.Attempt, d.now()); err != nil {
+			d.log.Error().Err(err).Str("event", j.EventID).Msg("webhook: failed to release delivery")
+		}
+	}
+}
+
+func (d *Dispatcher) worker() {
+	defer d.wg.Done()
+	for j := range d.jobs {
+		d.deliver(j)
+	}
+}
+
+// deliver makes single attempt, failed delivery is scheduled for retry until
+// attempts are exhausted.
+func (d *Dispatcher) deliver(j *repository.WebhookJob) {
+	attempt := j.Attempt + 1
+	status, err := d.send(j)
+	delivery := d.newDelivery(j, attempt, status, err)
+	delivery.Dead = err != nil && attempt >= d.cfg.MaxAttempts
+	d.record(delivery)
+
+	ctx := context.Background()
+	if err == nil || delivery.Dead {
+		err = d.store.CompleteDelivery(ctx, j.ID)
+	} else {
+		err = d.store.RescheduleDelivery(ctx, j.ID, attempt, d.now().Add(d.backoff(attempt)))
+	}
+	// Delivery is attempted again when its lease passes.
+	if err != nil {
+		d.log.Error().Err(err).Str("event", j.EventID).Msg("webhook: failed to update scheduled delivery")
+	}
+}
+
+// backoff returns delay after given failed attempt, doubled on each attempt.
+func (d *Dispatcher) backoff(attempt int) time.Duration {
+	backoff := d.cfg.InitialBackoff
+	for i := 1; i < attempt && backoff < d.cfg.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > d.cfg.MaxBackoff {
+		backoff = d.cfg.MaxBackoff
+	}
+	return backoff
+}
+
+func (d *Dispatcher) newDelivery(j *repository.WebhookJob, attempt, status int, err error) *repository.WebhookDelivery {
+	delivery := &repository.WebhookDelivery{
+		CreatedAt:       d.now(),
+		SubscriptionRef: j.SubscriptionRef,
+		EventID:         j.EventID,
+		EventType:       j.EventType,
+		Attempt:         attempt,
+		StatusCode:      status,
+	}
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+	return delivery
+}
+
+func (d *Dispatcher) record(delivery *repository.WebhookDelivery) {
+	if err := d.store.RecordDelivery(context.Background(), delivery); err != nil {
+		d.log.Error().Err(err).Str("event", delivery.EventID).Msg("webhook: failed to record delivery")
+	}
+	if delivery.Dead {
+		d.log.Warn().Str("event", delivery.EventID).Uint("subscription", delivery.SubscriptionRef).
+			Str("error", delivery.Error).Msg("webhook: delivery failed permanently")
+	}
+}
+
+func (d *Dispatcher) send(j *repository.WebhookJob) (int, error) {
+	body := []byte(j.Payload)
+	req, err := http.NewRequest(http.MethodPost, j.Subscription.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(d.now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Dunder-Webhook")
+	req.Header.Set(HeaderEvent, j.EventType)
+	req.Header.Set(HeaderDelivery, j.EventID)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(j.Subscription.Secret, timestamp, body))
+
+	resp, err := d.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign computes signature receivers use to verify payload authenticity.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks signature header value against payload.
+func Verify(secret, timestamp string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
@@ -0,0 +1,258 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jozuenoon/dunder/model"
+	"github.com/jozuenoon/dunder/repository"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memoryStore struct {
+	repository.WebhookStore
+
+	subs       []*repository.WebhookSubscription
+	mu         sync.Mutex
+	jobs       []*repository.WebhookJob
+	deliveries []*repository.WebhookDelivery
+}
+
+func (s *memoryStore) MatchingWebhooks(ctx context.Context, userName string, hashtags []string) ([]*repository.WebhookSubscription, error) {
+	return s.subs, nil
+}
+
+func (s *memoryStore) EnqueueDeliveries(ctx context.Context, jobs []*repository.WebhookJob) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, j := range jobs {
+		j.ID = uint(len(s.jobs) + 1)
+		s.jobs = append(s.jobs, j)
+	}
+	return nil
+}
+
+func (s *memoryStore) DueDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit uint) ([]*repository.WebhookJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var due []*repository.WebhookJob
+	for _, j := range s.jobs {
+		if uint(len(due)) < limit && !j.NextAttemptAt.After(now) {
+			j.NextAttemptAt = now.Add(lease)
+			claimed := *j
+			claimed.Subscription = *s.subs[0]
+			due = append(due, &claimed)
+		}
+	}
+	return due, nil
+}
+
+func (s *memoryStore) RescheduleDelivery(ctx context.Context, id uint, attempt int, next time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, j := range s.jobs {
+		if j.ID == id {
+			j.Attempt = attempt
+			j.NextAttemptAt = next
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) CompleteDelivery(ctx context.Context, id uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, j := range s.jobs {
+		if j.ID == id {
+			s.jobs = append(s.jobs[:i], s.jobs[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) RecordDelivery(ctx context.Context, delivery *repository.WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deliveries = append(s.deliveries, delivery)
+	return nil
+}
+
+func (s *memoryStore) recorded() []*repository.WebhookDelivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*repository.WebhookDelivery(nil), s.deliveries...)
+}
+
+func (s *memoryStore) scheduled() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.jobs)
+}
+
+func newTestStore(url string) *memoryStore {
+	return &memoryStore{subs: []*repository.WebhookSubscription{{ID: 7, URL: url, Secret: "s3cret"}}}
+}
+
+func newTestDispatcher(store *memoryStore, maxAttempts int) *Dispatcher {
+	log := zerolog.Nop()
+	return NewDispatcher(store, &Config{
+		Workers:        1,
+		QueueSize:      10,
+		MaxAttempts:    maxAttempts,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     2 * time.Millisecond,
+		Timeout:        time.Second,
+		PollInterval:   time.Millisecond,
+		// Test servers listen on loopback.
+		AllowedNetworks: []*net.IPNet{{IP: net.IPv4(127, 0, 0, 0), Mask: net.CIDRMask(8, 32)}},
+	}, &log)
+}
+
+func testEvent() *model.Event {
+	return &model.Event{
+		ID:      "01DQEVENT",
+		Type:    model.EventMessageCreated,
+		Message: &model.Message{ID: "01DQMSG", Text: "hello", User: model.User{Name: "john"}},
+	}
+}
+
+// waitDelivered waits until all scheduled deliveries are completed.
+func waitDelivered(t *testing.T, store *memoryStore) {
+	t.Helper()
+	require.Eventually(t, func() bool { return store.scheduled() == 0 }, 5*time.Second, time.Millisecond)
+}
+
+func TestDispatcher_SignedDeliveryWithRetry(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		assert.True(t, Verify("s3cret", r.Header.Get(HeaderTimestamp), body, r.Header.Get(HeaderSignature)))
+		assert.Equal(t, model.EventMessageCreated, r.Header.Get(HeaderEvent))
+		assert.Equal(t, "01DQEVENT", r.Header.Get(HeaderDelivery))
+
+		var event model.Event
+		require.NoError(t, json.Unmarshal(body, &event))
+		assert.Equal(t, "01DQMSG", event.Message.ID)
+
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	store := newTestStore(srv.URL)
+	d := newTestDispatcher(store, 3)
+	require.NoError(t, d.Publish(context.Background(), testEvent()))
+	waitDelivered(t, store)
+	require.NoError(t, d.Close(context.Background()))
+
+	deliveries := store.recorded()
+	require.Len(t, deliveries, 2)
+	assert.Equal(t, http.StatusServiceUnavailable, deliveries[0].StatusCode)
+	assert.NotEmpty(t, deliveries[0].Error)
+	assert.False(t, deliveries[0].Dead)
+	assert.Equal(t, 2, deliveries[1].Attempt)
+	assert.Equal(t, http.StatusNoContent, deliveries[1].StatusCode)
+	assert.Empty(t, deliveries[1].Error)
+	assert.Equal(t, uint(7), deliveries[1].SubscriptionRef)
+}
+
+func TestDispatcher_DeadLetter(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	store := newTestStore(srv.URL)
+	d := newTestDispatcher(store, 3)
+	require.NoError(t, d.Publish(context.Background(), testEvent()))
+	waitDelivered(t, store)
+	require.NoError(t, d.Close(context.Background()))
+
+	deliveries := store.recorded()
+	require.Len(t, deliveries, 3)
+	assert.False(t, deliveries[1].Dead)
+	assert.True(t, deliveries[2].Dead)
+	assert.Equal(t, 3, deliveries[2].Attempt)
+}
+
+// TestDispatcher_ResumesScheduled checks retries scheduled by previous process are sent.
+func TestDispatcher_ResumesScheduled(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	store := newTestStore(srv.URL)
+	body, err := json.Marshal(testEvent())
+	require.NoError(t, err)
+	require.NoError(t, store.EnqueueDeliveries(context.Background(), []*repository.WebhookJob{{
+		SubscriptionRef: 7,
+		EventID:         "01DQEVENT",
+		EventType:       model.EventMessageCreated,
+		Payload:         string(body),
+		Attempt:         2,
+		NextAttemptAt:   time.Now(),
+	}}))
+
+	d := newTestDispatcher(store, 3)
+	waitDelivered(t, store)
+	require.NoError(t, d.Close(context.Background()))
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	deliveries := store.recorded()
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, 3, deliveries[0].Attempt)
+	assert.False(t, deliveries[0].Dead)
+}
+
+func TestDispatcher_Backoff(t *testing.T) {
+	d := &Dispatcher{cfg: Config{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}}
+	var backoffs []time.Duration
+	for attempt := 1; attempt <= 5; attempt++ {
+		backoffs = append(backoffs, d.backoff(attempt))
+	}
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}, backoffs)
+}
+
+func TestDispatcher_PublishAfterClose(t *testing.T) {
+	d := newTestDispatcher(newTestStore("http://127.0.0.1:0"), 1)
+	require.NoError(t, d.Close(context.Background()))
+	assert.Equal(t, ErrClosed, d.Publish(context.Background(), testEvent()))
+}
+
+func TestDispatcher_SkipsArchivedAndRestored(t *testing.T) {
+	store := newTestStore("http://127.0.0.1:0")
+	d := newTestDispatcher(store, 1)
+	defer d.Close(context.Background())
+	for _, eventType := range []string{model.EventMessageArchived, model.EventMessageRestored} {
+		event := testEvent()
+		event.Type = eventType
+		require.NoError(t, d.Publish(context.Background(), event))
+	}
+	assert.Zero(t, store.scheduled())
+}
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"id":"1"}`)
+	signature := Sign("secret", "1570000000", body)
+	assert.True(t, Verify("secret", "1570000000", body, signature))
+	assert.False(t, Verify("secret", "1570000001", body, signature))
+	assert.False(t, Verify("other", "1570000000", body, signature))
+}
@@ -0,0 +1,101 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"syscall"
+)
+
+// ErrForbiddenTarget is returned for webhook urls pointing into internal networks.
+var ErrForbiddenTarget = errors.New("webhook target is not public address")
+
+// internalNetworks are private and shared address ranges not covered by net.IP
+// predicates available in Go 1.13.
+var internalNetworks = mustParseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"fc00::/7",
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+// NewGuard creates guard which lets webhooks target only public addresses and
+// given allowed networks.
+func NewGuard(allowed []*net.IPNet) *Guard {
+	return &Guard{allowed: allowed, resolver: net.DefaultResolver}
+}
+
+// Guard keeps webhooks from reaching loopback, link-local, private and other
+// internal addresses, so subscriptions can't be used to probe network of
+// Dunder. Urls are checked on subscription and every connection is checked
+// again when dialed, as DNS answers may change meanwhile.
+type Guard struct {
+	allowed  []*net.IPNet
+	resolver *net.Resolver
+}
+
+// CheckURL resolves host of webhook url and checks all its addresses.
+func (g *Guard) CheckURL(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	addrs, err := g.resolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if err := g.CheckIP(addr.IP); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckIP tells whether webhook may connect to ip.
+func (g *Guard) CheckIP(ip net.IP) error {
+	for _, n := range g.allowed {
+		if n.Contains(ip) {
+			return nil
+		}
+	}
+	internal := ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified()
+	for _, n := range internalNetworks {
+		internal = internal || n.Contains(ip)
+	}
+	if internal {
+		return fmt.Errorf("%w: %s", ErrForbiddenTarget, ip)
+	}
+	return nil
+}
+
+// control checks address of connection before it's established, it's used
+// as net.Dialer Control.
+func (g *Guard) control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("%w: %s", ErrForbiddenTarget, host)
+	}
+	return g.CheckIP(ip)
+}
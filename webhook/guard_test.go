@@ -0,0 +1,72 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGuard_CheckIP(t *testing.T) {
+	_, allowed, err := net.ParseCIDR("10.1.0.0/16")
+	require.NoError(t, err)
+	g := NewGuard([]*net.IPNet{allowed})
+
+	for ip, ok := range map[string]bool{
+		"93.184.216.34":    true,
+		"2606:4700::1111":  true,
+		"10.1.2.3":         true,
+		"10.2.0.1":         false,
+		"127.0.0.1":        false,
+		"::1":              false,
+		"::ffff:127.0.0.1": false,
+		"169.254.169.254":  false,
+		"172.16.0.1":       false,
+		"192.168.1.1":      false,
+		"100.64.0.1":       false,
+		"0.0.0.0":          false,
+		"fd00::1":          false,
+		"fe80::1":          false,
+		"224.0.0.1":        false,
+	} {
+		err := g.CheckIP(net.ParseIP(ip))
+		if ok {
+			assert.NoError(t, err, ip)
+		} else {
+			assert.True(t, errors.Is(err, ErrForbiddenTarget), ip)
+		}
+	}
+}
+
+func TestGuard_CheckURL(t *testing.T) {
+	g := NewGuard(nil)
+	assert.True(t, errors.Is(g.CheckURL(context.Background(), "http://127.0.0.1:8080/hook"), ErrForbiddenTarget))
+	assert.True(t, errors.Is(g.CheckURL(context.Background(), "http://localhost/hook"), ErrForbiddenTarget))
+	assert.NoError(t, g.CheckURL(context.Background(), "https://93.184.216.34/hook"))
+}
+
+// TestDispatcher_ForbiddenTarget checks connections are guarded, eg. when DNS
+// answer changed after subscription.
+func TestDispatcher_ForbiddenTarget(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("internal address was reached")
+	}))
+	defer srv.Close()
+
+	store := newTestStore(srv.URL)
+	d := newTestDispatcher(store, 1)
+	d.http.Transport.(*http.Transport).DialContext = (&net.Dialer{Control: NewGuard(nil).control}).DialContext
+	require.NoError(t, d.Publish(context.Background(), testEvent()))
+	waitDelivered(t, store)
+	require.NoError(t, d.Close(context.Background()))
+
+	deliveries := store.recorded()
+	require.Len(t, deliveries, 1)
+	assert.True(t, deliveries[0].Dead)
+	assert.Contains(t, deliveries[0].Error, ErrForbiddenTarget.Error())
+}